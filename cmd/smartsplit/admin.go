@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/monolith"
+)
+
+// cliUserAgent identifies the administrative commands in the audit log.
+const cliUserAgent = "smartsplit-cli"
+
+// withApplication runs an administrative command against the same wiring as
+// the server. Logs go to stderr, so output can be piped.
+func withApplication(fn func(ctx context.Context, app *monolith.Application) error) error {
+	cfg, logger, closeLog, err := loadConfig(os.Stderr)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	db, err := openDB(logger, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := auth.WithAuditRequest(context.Background(), &auth.AuditRequest{UserAgent: cliUserAgent})
+
+	if err := checkSchema(ctx, logger, cfg, db); err != nil {
+		logger.Error("database schema is not up to date, run smartsplit migrate up", "error", err)
+		return err
+	}
+
+	// The modules are only set up, not started, so no background work runs
+	// alongside the command.
+	app, err := newApplication(ctx, logger, cfg, db)
+	if err != nil {
+		return err
+	}
+
+	return fn(ctx, app)
+}
+
+// subcommand returns the name of a subcommand and its arguments.
+func subcommand(group string, args []string) (string, []string, error) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return "", nil, fmt.Errorf("missing %s command", group)
+	}
+	return args[0], args[1:], nil
+}
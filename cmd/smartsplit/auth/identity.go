@@ -5,32 +5,128 @@ import (
 	"database/sql"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/db"
 	"github.com/evenlwanvik/smartsplit/internal/monolith"
+	"github.com/evenlwanvik/smartsplit/internal/scheduler"
 )
 
 const moduleName string = "auth"
 
 type Module struct {
-	logger   *slog.Logger
-	name     string
-	version  string
-	db       *sql.DB
-	mux      *http.ServeMux
-	handlers auth.UserHandler
+	logger     *slog.Logger
+	name       string
+	version    string
+	db         *sql.DB
+	dialect    db.Dialect
+	mux        *http.ServeMux
+	config     *config.AuthConfig
+	users      *auth.UserService
+	sessions   *auth.SessionService
+	lockouts   *auth.LockoutService
+	audit      *auth.AuditService
+	handlers   auth.UserHandler
+	tokens     auth.TokenHandler
+	logins     auth.SessionHandler
+	oidc       auth.OIDCHandler
+	auditLog   auth.AuditHandler
+	middleware auth.Middleware
 }
 
-func (m *Module) Setup(ctx context.Context, mono monolith.Monolith) {
+func (m *Module) Name() string { return moduleName }
+
+func (m *Module) Dependencies() []string { return nil }
+
+func (m *Module) Setup(ctx context.Context, mono monolith.Monolith) error {
 	m.initModuleLogger(mono.Logger())
 
 	m.logger.Info("injecting database connection pool")
 	m.db = mono.DB()
+	m.dialect = mono.Dialect()
 
+	m.logger.Info("injecting config")
+	m.config = mono.Config().Auth
+
+	m.audit = auth.NewAuditService(
+		auth.NewAuditRepository(m.db, m.dialect),
+		m.config.Audit.Retention,
+	)
+	m.auditLog = auth.AuditHandler{
+		Service: m.audit,
+	}
+
+	userRepository := auth.NewUserRepository(m.db, m.dialect)
+	m.users = auth.NewUserService(
+		userRepository,
+		m.audit,
+		m.config.Deletion.GracePeriod,
+	)
 	m.handlers = auth.UserHandler{
-		Service: auth.NewUserService(
-			auth.NewUserRepository(m.db),
+		Service: m.users,
+	}
+
+	tokenService := auth.NewTokenService(auth.NewTokenRepository(m.db, m.dialect), m.audit)
+	m.tokens = auth.TokenHandler{
+		Service: tokenService,
+	}
+
+	m.lockouts = auth.NewLockoutService(
+		auth.NewLockoutRepository(m.db, m.dialect),
+		m.audit,
+		auth.LockoutPolicy{
+			AccountThreshold: m.config.Lockout.AccountThreshold,
+			IPThreshold:      m.config.Lockout.IPThreshold,
+			BaseDelay:        m.config.Lockout.BaseDelay,
+			MaxDelay:         m.config.Lockout.MaxDelay,
+			ResetAfter:       m.config.Lockout.ResetAfter,
+		},
+	)
+	m.sessions = auth.NewSessionService(
+		auth.NewSessionRepository(m.db, m.dialect),
+		userRepository,
+		m.lockouts,
+		m.audit,
+		m.config.Session.TTL,
+	)
+	m.logins = auth.SessionHandler{
+		Service:      m.sessions,
+		Lockouts:     m.lockouts,
+		SecureCookie: m.config.Session.SecureCookie,
+	}
+
+	var providers []auth.OIDCSettings
+	for _, p := range m.config.OIDC.Providers {
+		providers = append(providers, auth.OIDCSettings{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		})
+	}
+	m.oidc = auth.OIDCHandler{
+		Service: auth.NewOIDCService(
+			auth.NewIdentityRepository(m.db, m.dialect),
+			m.sessions,
+			m.audit,
+			providers,
 		),
+		SecureCookie:       m.config.Session.SecureCookie,
+		RedirectAfterLogin: m.config.OIDC.RedirectAfterLogin,
+	}
+
+	m.middleware = auth.Middleware{
+		Tokens:   tokenService,
+		Sessions: m.sessions,
+	}
+	// Metrics served on the port of the application are for admins only,
+	// and scrapers read them with an admin token.
+	if metrics := mono.Config().App.Metrics; metrics != nil && metrics.Enabled && metrics.Port == 0 {
+		m.middleware.MetricsPath = metrics.Path
 	}
 
 	m.logger.Info("injecting mux")
@@ -38,13 +134,92 @@ func (m *Module) Setup(ctx context.Context, mono monolith.Monolith) {
 
 	m.logger.Info("registering routes")
 	m.handlers.RegisterRoutes(ctx, m.mux)
+	m.tokens.RegisterRoutes(ctx, m.mux)
+	m.logins.RegisterRoutes(ctx, m.mux)
+	m.oidc.RegisterRoutes(ctx, m.mux)
+	m.auditLog.RegisterRoutes(ctx, m.mux)
+
+	m.logger.Info("subscribing to events")
+	if err := m.audit.SubscribeEvents(mono.Events()); err != nil {
+		return err
+	}
+
+	m.logger.Info("registering jobs", "interval", m.purgeInterval())
+	if err := m.registerJobs(mono.Scheduler()); err != nil {
+		return err
+	}
+
+	return nil
 }
 
-func (m *Module) PostSetup() {
-	m.logger.Info("performing post setup process")
+func (m *Module) Start(ctx context.Context) error { return nil }
+
+func (m *Module) Stop(ctx context.Context) error { return nil }
+
+func (m *Module) purgeInterval() time.Duration {
+	if m.config.Deletion.PurgeInterval > 0 {
+		return m.config.Deletion.PurgeInterval
+	}
+	return time.Hour
 }
 
-func (m *Module) Shutdown() {}
+// registerJobs schedules the purge of users whose deletion grace period has
+// passed, expired sessions, stale failed login attempts and audit records
+// past retention.
+func (m *Module) registerJobs(s *scheduler.Scheduler) error {
+	jobs := []scheduler.Job{
+		{
+			Name: "auth.purge_users",
+			Run: func(ctx context.Context) error {
+				n, err := m.users.PurgeScheduledUsers(ctx)
+				if n > 0 {
+					m.logger.Info("purged deleted users", "count", n)
+				}
+				return err
+			},
+		},
+		{
+			Name: "auth.prune_sessions",
+			Run: func(ctx context.Context) error {
+				n, err := m.sessions.PruneExpired(ctx)
+				if n > 0 {
+					m.logger.Info("pruned expired sessions", "count", n)
+				}
+				return err
+			},
+		},
+		{
+			Name: "auth.prune_login_attempts",
+			Run: func(ctx context.Context) error {
+				n, err := m.lockouts.Prune(ctx)
+				if n > 0 {
+					m.logger.Info("pruned login attempts", "count", n)
+				}
+				return err
+			},
+		},
+		{
+			Name: "auth.prune_audit_log",
+			Run: func(ctx context.Context) error {
+				n, err := m.audit.Prune(ctx)
+				if n > 0 {
+					m.logger.Info("pruned audit log", "records", n)
+				}
+				return err
+			},
+		},
+	}
+
+	for _, job := range jobs {
+		job.Schedule = scheduler.Every(m.purgeInterval())
+		job.Retries = 2
+		job.Backoff = 30 * time.Second
+		if err := s.Register(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 func (m *Module) initModuleLogger(monoLogger *slog.Logger) {
 	m.logger = monoLogger.With(slog.Group("module", slog.String("name", moduleName)))
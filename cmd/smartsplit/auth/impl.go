@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+)
+
+func (m *Module) Authenticate(next http.Handler) http.Handler {
+	return m.middleware.Authenticate(next)
+}
+
+func (m *Module) Audit(next http.Handler) http.Handler {
+	return auth.Audit(next)
+}
+
+func (m *Module) RecordAudit(
+	ctx context.Context, action string, targetType string, targetID string, before any, after any,
+) {
+	m.audit.Record(ctx, auth.AuditEntry{
+		Action:     auth.AuditAction(action),
+		TargetType: targetType,
+		TargetID:   targetID,
+		Changes:    auth.AuditDiff(before, after),
+	})
+}
+
+func (m *Module) CreateUser(ctx context.Context, user *auth.CreateUser) (*auth.User, error) {
+	return m.users.CreateUser(ctx, user)
+}
+
+func (m *Module) ListUsers(ctx context.Context) ([]*auth.User, error) {
+	return m.users.ListUsers(ctx)
+}
+
+func (m *Module) DisableUser(ctx context.Context, id int) (*auth.User, error) {
+	return m.users.DisableUser(ctx, id)
+}
+
+func (m *Module) ResetPassword(ctx context.Context, id int, password string) error {
+	return m.users.ResetPassword(ctx, id, password)
+}
+
+func (m *Module) RegisterUserCleanup(name string, fn auth.UserCleanupFunc) {
+	m.users.RegisterUserCleanup(name, fn)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
+
+// printConfig runs the config subcommands. Only print is supported, which
+// writes the loaded config as JSON, after environment overrides. Secrets are
+// left out, as they are hidden from JSON.
+func printConfig(args []string) error {
+	cmd, _, err := subcommand("config", args)
+	if err != nil {
+		return err
+	}
+	if cmd != "print" {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown config command %q", cmd)
+	}
+
+	cfg, err := config.New()
+	if err != nil {
+		return err
+	}
+
+	return writeConfig(os.Stdout, cfg)
+}
+
+// writeConfig writes the config as indented JSON.
+func writeConfig(w io.Writer, cfg *config.Config) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cfg)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
+
+func TestWriteConfigHidesSecrets(t *testing.T) {
+	cfg := &config.Config{
+		App: &config.AppConfig{Env: config.DevelopmentEnvironment, Port: 5000},
+		Database: &config.DatabaseConfig{
+			Driver:   config.DriverPostgres,
+			Host:     "localhost",
+			Password: "db-s3cret",
+		},
+		Auth: &config.AuthConfig{
+			OIDC: &config.OIDCConfig{Providers: []config.OIDCProviderConfig{{
+				Name:         "company",
+				ClientID:     "smartsplit",
+				ClientSecret: "oidc-s3cret",
+			}}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeConfig(&buf, cfg); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, secret := range []string{"db-s3cret", "oidc-s3cret", `"password"`, `"client_secret"`} {
+		if strings.Contains(out, secret) {
+			t.Errorf("got %s, want %s left out", out, secret)
+		}
+	}
+	for _, want := range []string{`"host": "localhost"`, `"client_id": "smartsplit"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got %s, want it to contain %s", out, want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/evenlwanvik/smartsplit/internal/events"
+	"github.com/evenlwanvik/smartsplit/internal/monolith"
+)
+
+// eventsCommand runs the events subcommands.
+func eventsCommand(args []string) error {
+	cmd, args, err := subcommand("events", args)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "replay":
+		return replayEvents(args)
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown events command %q", cmd)
+}
+
+// replayEvents delivers stored events to the subscribers registered by the
+// modules again, whether or not they have been delivered before.
+func replayEvents(args []string) error {
+	fs := flag.NewFlagSet("events replay", flag.ExitOnError)
+	fromID := fs.Int64("from-id", 0, "ID of the first event to replay")
+	toID := fs.Int64("to-id", 0, "ID of the last event to replay, all events by default")
+	name := fs.String("name", "", "only replay events with this name, e.g. workout.plan.created")
+	subscriber := fs.String("subscriber", "", "only deliver to this subscriber, e.g. auth.audit")
+	fs.Parse(args)
+
+	filter := events.ReplayFilter{FromID: *fromID}
+	if *toID != 0 {
+		filter.ToID = toID
+	}
+	if *name != "" {
+		filter.Name = name
+	}
+	if *subscriber != "" {
+		filter.Subscriber = subscriber
+	}
+
+	return withApplication(func(ctx context.Context, app *monolith.Application) error {
+		n, err := app.Events().Replay(ctx, filter)
+		fmt.Printf("replayed %d deliveries\n", n)
+		return err
+	})
+}
@@ -2,25 +2,51 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/google/uuid"
 
-	_ "github.com/mattn/go-sqlite3"
-
 	"github.com/evenlwanvik/smartsplit/cmd/smartsplit/auth"
 	"github.com/evenlwanvik/smartsplit/cmd/smartsplit/web"
 	"github.com/evenlwanvik/smartsplit/cmd/smartsplit/workout"
 
+	"github.com/evenlwanvik/smartsplit/db/migrations"
 	"github.com/evenlwanvik/smartsplit/internal/config"
 	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/logging"
 	"github.com/evenlwanvik/smartsplit/internal/monolith"
+	"github.com/evenlwanvik/smartsplit/internal/tracing"
 )
 
+const usage = `usage: smartsplit [command]
+
+commands:
+  serve                       run the server (default)
+  migrate up                  apply all pending migrations
+  migrate down [steps]        roll back migrations, one by default
+  migrate goto <version>      migrate up or down to a version
+  migrate status              print the schema version and pending migrations
+  user create [flags]         create a user
+  user list                   list all users
+  user disable -id <id>       disable a user and end its sessions
+  user reset-password [flags] set a new password for a user
+  muscle import <file>        import muscles from a JSON file, or - for stdin
+  plan export [flags]         export workout plans as JSON
+  events replay [flags]       deliver stored events to their subscribers again
+  config print                print the loaded config, without secrets
+
+Run a command with -h for its flags.
+`
+
 func main() {
-	err := run()
+	err := run(os.Args[1:])
 	if err != nil {
 		slog.Error("an error occured while starting the application", "error", err)
 		os.Exit(1)
@@ -28,57 +54,112 @@ func main() {
 	os.Exit(0)
 }
 
-func run() error {
-	ctx := context.Background()
-	slog.Info("starting smartsplit application")
+func run(args []string) error {
+	if len(args) == 0 {
+		return serve()
+	}
+	switch args[0] {
+	case "serve":
+		return serve()
+	case "migrate":
+		return migrate(args[1:])
+	case "user":
+		return user(args[1:])
+	case "muscle":
+		return muscle(args[1:])
+	case "plan":
+		return plan(args[1:])
+	case "events":
+		return eventsCommand(args[1:])
+	case "config":
+		return printConfig(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// loadConfig loads the config and creates the logger it configures, which
+// writes to w unless the config names a file or stderr. The returned
+// function closes the log output.
+func loadConfig(w io.Writer) (*config.Config, *slog.Logger, func() error, error) {
+	cfg, err := config.New()
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		return nil, nil, nil, err
+	}
+
+	logger, closeLog, err := newLogger(cfg.App.Log, w)
+	if err != nil {
+		slog.Error("failed to set up logging", "error", err)
+		return nil, nil, nil, err
+	}
+	return cfg, logger, closeLog, nil
+}
 
-	slog.Info("initializing logger")
-	handler := slog.NewJSONHandler(os.Stdout, nil)
-	jsonLogger := slog.New(handler)
-	logger := jsonLogger.With(
+func newLogger(cfg *config.LogConfig, w io.Writer) (*slog.Logger, func() error, error) {
+	baseLogger, closeLog, err := logging.New(cfg, w)
+	if err != nil {
+		return nil, nil, err
+	}
+	logger := baseLogger.With(
 		slog.Group(
 			"instance",
 			slog.String("id", uuid.New().String()),
 		),
 	)
 	slog.SetDefault(logger)
+	return logger, closeLog, nil
+}
 
-	logger.Info("initializing database connection")
-	dsn := db.PostgresDB{
-		Host:     "localhost",
-		Port:     5032,
-		User:     "smartsplit",
-		Password: "smartsplit",
-		Database: "smartsplit",
-		SSLMode:  "disable",
-	}
-
-	logger.Info("Connecting to the database", "dsn", dsn)
-	db, err := db.NewDB(dsn)
+// openDB connects to the database.
+func openDB(logger *slog.Logger, cfg *config.Config) (*sql.DB, error) {
+	logger.Info("connecting to the database", "database", cfg.Database)
+	db, err := db.NewDB(cfg.Database)
 	if err != nil {
 		logger.Error("Failed to connect to the database", "error", err)
-		return err
+		return nil, err
 	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			logger.Error(
-				"unable to close database connection",
-				slog.String("error", err.Error()),
-			)
-		}
-	}()
+	return db, nil
+}
 
-	mux := http.NewServeMux()
+// newMigrator reads the migrations written for the configured driver.
+func newMigrator(cfg *config.Config, sqlDB *sql.DB) (*db.Migrator, error) {
+	dialect := db.Dialect(cfg.Database.Driver)
+	if dialect == db.SQLite {
+		return db.NewMigrator(sqlDB, dialect, migrations.SQLite)
+	}
+	return db.NewMigrator(sqlDB, dialect, migrations.FS)
+}
 
-	logger.Info("loading config")
-	cfg, err := config.New()
+// checkSchema makes sure the schema is at the version the code expects,
+// migrating up first if the config allows it.
+func checkSchema(ctx context.Context, logger *slog.Logger, cfg *config.Config, sqlDB *sql.DB) error {
+	migrator, err := newMigrator(cfg, sqlDB)
 	if err != nil {
-		logger.Error("failed to load config")
 		return err
 	}
 
-	app := monolith.NewApplication(
-		db,
+	err = migrator.Check(ctx)
+	if err == nil || !errors.Is(err, db.ErrSchemaMismatch) || cfg.Database.Migrations != config.MigrationsAuto {
+		return err
+	}
+
+	logger.Info("migrating database", "version", migrator.Latest())
+	return migrator.Up(ctx)
+}
+
+// newApplication wires up the modules the same way for the server and the
+// administrative commands.
+func newApplication(
+	ctx context.Context, logger *slog.Logger, cfg *config.Config, sqlDB *sql.DB,
+) (*monolith.Application, error) {
+	mux := http.NewServeMux()
+
+	app, err := monolith.NewApplication(
+		sqlDB,
 		mux,
 		logger,
 		cfg,
@@ -88,21 +169,100 @@ func run() error {
 			Workout: &workout.Module{},
 		},
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The schema is checked on startup, but may still be changed by hand or
+	// by a newer instance while running. A newer schema keeps this instance
+	// ready, so a rolling deploy does not take the old instances out.
+	migrator, err := newMigrator(cfg, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	app.RegisterHealthCheck("migrations", migrator.Ready)
+
+	logger.Info("running module setup procedures")
+	if err := app.SetupModules(ctx); err != nil {
+		logger.Error("failed to set up modules", "error", err)
+		return nil, err
+	}
+	return app, nil
+}
+
+func serve() error {
+	ctx := context.Background()
+	slog.Info("starting smartsplit application")
+
+	slog.Info("loading config")
+	cfg, logger, closeLog, err := loadConfig(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	db, err := openDB(logger, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Error(
+				"unable to close database connection",
+				slog.String("error", err.Error()),
+			)
+		}
+	}()
+
+	logger.Info("checking database schema")
+	err = checkSchema(ctx, logger, cfg, db)
+	if err != nil {
+		logger.Error("database schema is not up to date, run smartsplit migrate up", "error", err)
+		return err
+	}
+
+	logger.Info("setting up tracing", "exporter", cfg.App.Tracing.Exporter)
+	shutdownTracing, err := tracing.Setup(ctx, cfg.App.Tracing)
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		return err
+	}
+	// Pending spans are flushed last, so the shutdown itself is traced.
+	defer func() {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("failed to flush traces", "error", err)
+		}
+	}()
 
-	logger.Info("running module startup procedures")
-	app.SetupModules(ctx)
-	app.PostSetupModules()
+	app, err := newApplication(ctx, logger, cfg, db)
+	if err != nil {
+		return err
+	}
 
-	err = app.Serve()
+	err = app.StartModules(ctx)
 	if err != nil {
-		logger.Error("unable to start server", "error", err)
+		logger.Error("failed to start modules", "error", err)
 		return err
 	}
 
-	app.Logger().Info("shutting down modules")
-	app.ShutdownModules()
+	serveErr := app.Serve()
+	if serveErr != nil {
+		logger.Error("unable to start server", "error", serveErr)
+	}
+
+	// Modules are stopped even if the server failed, as they may have
+	// started background work.
+	app.Logger().Info("stopping modules")
+	stopCtx, cancel := context.WithTimeout(ctx, app.ShutdownTimeout())
+	defer cancel()
+	err = app.StopModules(stopCtx)
+	if err != nil {
+		logger.Error("failed to stop modules", "error", err)
+	}
 
 	app.Logger().Info("exiting...")
 
-	return nil
+	return errors.Join(serveErr, err)
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// migrate runs the migrate subcommand.
+func migrate(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("missing migrate command")
+	}
+
+	ctx := context.Background()
+	cfg, logger, closeLog, err := loadConfig(os.Stderr)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	sqlDB, err := openDB(logger, cfg)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	migrator, err := newMigrator(cfg, sqlDB)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive number, got %q", args[1])
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case "goto":
+		if len(args) < 2 {
+			return errors.New("missing version to migrate to")
+		}
+		version, perr := strconv.ParseUint(args[1], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("version must be a number, got %q", args[1])
+		}
+		err = migrator.Goto(ctx, version)
+	case "status":
+		// Only the status is printed below.
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("version: %d (latest %d)\n", status.Version, status.Latest)
+	if status.Dirty {
+		fmt.Println("dirty: the last migration failed, fix the schema by hand")
+	}
+	for _, m := range status.Pending {
+		fmt.Printf("pending: %d_%s\n", m.Version, m.Name)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/evenlwanvik/smartsplit/internal/monolith"
+	"github.com/evenlwanvik/smartsplit/internal/workout"
+)
+
+// muscle runs the muscle subcommands.
+func muscle(args []string) error {
+	cmd, args, err := subcommand("muscle", args)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "import":
+		return importMuscles(args)
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown muscle command %q", cmd)
+}
+
+// importMuscles imports a JSON array of muscles. Muscles with the same name
+// as an existing one are skipped, so a file can be imported again after
+// adding to it.
+func importMuscles(args []string) error {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("expected a single file to import")
+	}
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	inputs, err := decodeMuscles(r)
+	if err != nil {
+		return err
+	}
+
+	return withApplication(func(ctx context.Context, app *monolith.Application) error {
+		created, skipped, err := createMissingMuscles(ctx, app.Modules().Workout, inputs)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("imported %d muscles, skipped %d existing\n", created, skipped)
+		return nil
+	})
+}
+
+// decodeMuscles decodes and validates a JSON array of muscles.
+func decodeMuscles(r io.Reader) ([]*workout.MuscleInput, error) {
+	var inputs []*workout.MuscleInput
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&inputs); err != nil {
+		return nil, fmt.Errorf("decoding muscles: %w", err)
+	}
+	for i, in := range inputs {
+		if strings.TrimSpace(in.Name) == "" || strings.TrimSpace(in.MuscleGroup) == "" {
+			return nil, fmt.Errorf("muscle %d: name and muscle_group are required", i)
+		}
+	}
+	return inputs, nil
+}
+
+// createMissingMuscles creates the muscles not already named the same,
+// ignoring case, and returns how many were created and skipped.
+func createMissingMuscles(ctx context.Context, client workout.Client, inputs []*workout.MuscleInput) (int, int, error) {
+	existing, err := client.ReadMuscles(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	names := make(map[string]bool, len(existing))
+	for _, m := range existing {
+		names[strings.ToLower(m.Name)] = true
+	}
+
+	var created, skipped int
+	for _, in := range inputs {
+		if names[strings.ToLower(in.Name)] {
+			skipped++
+			continue
+		}
+		m, err := client.CreateMuscle(ctx, in)
+		if err != nil {
+			return created, skipped, fmt.Errorf("creating muscle %q: %w", in.Name, err)
+		}
+		names[strings.ToLower(m.Name)] = true
+		created++
+	}
+	return created, skipped, nil
+}
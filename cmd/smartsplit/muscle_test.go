@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/db/dbtest"
+	"github.com/evenlwanvik/smartsplit/internal/workout"
+)
+
+// noAudit discards audit records.
+type noAudit struct{}
+
+func (noAudit) RecordAudit(context.Context, string, string, string, any, any) {}
+
+func TestDecodeMuscles(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int
+		wantErr string
+	}{
+		{name: "valid", input: `[{"name": "Calves", "muscle_group": "Legs"}, {"name": "Neck", "muscle_group": "Neck", "description": "x"}]`, want: 2},
+		{name: "empty", input: `[]`},
+		{name: "missing name", input: `[{"muscle_group": "Legs"}]`, wantErr: "muscle 0: name and muscle_group are required"},
+		{name: "blank group", input: `[{"name": "Calves", "muscle_group": "Legs"}, {"name": "Neck", "muscle_group": " "}]`, wantErr: "muscle 1"},
+		{name: "unknown field", input: `[{"name": "Calves", "muscle_group": "Legs", "group": "Legs"}]`, wantErr: "unknown field"},
+		{name: "not an array", input: `{"name": "Calves"}`, wantErr: "decoding muscles"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs, err := decodeMuscles(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one about %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(inputs) != tt.want {
+				t.Errorf("got %d muscles, want %d", len(inputs), tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateMissingMuscles(t *testing.T) {
+	ctx := context.Background()
+	svc := workout.NewService(workout.NewRepository(dbtest.NewSQLite(t), db.SQLite), noAudit{})
+	before, err := svc.ReadMuscles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Biceps is seeded, and names are compared ignoring case, also within
+	// the imported file.
+	inputs := []*workout.MuscleInput{
+		{Name: "BICEPS", MuscleGroup: "Arms"},
+		{Name: "Forearms", MuscleGroup: "Arms"},
+		{Name: "forearms", MuscleGroup: "Arms"},
+	}
+	created, skipped, err := createMissingMuscles(ctx, svc, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 || skipped != 2 {
+		t.Errorf("got %d created and %d skipped, want 1 and 2", created, skipped)
+	}
+
+	// Importing the same file again creates nothing.
+	created, skipped, err = createMissingMuscles(ctx, svc, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 0 || skipped != 3 {
+		t.Errorf("got %d created and %d skipped importing again, want 0 and 3", created, skipped)
+	}
+
+	after, err := svc.ReadMuscles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before)+1 {
+		t.Errorf("got %d muscles, want %d", len(after), len(before)+1)
+	}
+}
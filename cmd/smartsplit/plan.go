@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/evenlwanvik/smartsplit/internal/monolith"
+	"github.com/evenlwanvik/smartsplit/internal/workout"
+)
+
+// plan runs the plan subcommands.
+func plan(args []string) error {
+	cmd, args, err := subcommand("plan", args)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "export":
+		return exportPlans(args)
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown plan command %q", cmd)
+}
+
+// exportPlans writes plans with their entries as a JSON array to stdout.
+func exportPlans(args []string) error {
+	fs := flag.NewFlagSet("plan export", flag.ExitOnError)
+	userID := fs.Int("user-id", 0, "only export the plans of this user")
+	fs.Parse(args)
+
+	var filters workout.Filters
+	if *userID != 0 {
+		filters.UserID = userID
+	}
+
+	return withApplication(func(ctx context.Context, app *monolith.Application) error {
+		plans, _, err := app.Modules().Workout.ListPLans(ctx, filters)
+		if err != nil {
+			return err
+		}
+		if plans == nil {
+			plans = []*workout.Plan{}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plans)
+	})
+}
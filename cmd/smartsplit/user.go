@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/monolith"
+)
+
+// user runs the user subcommands.
+func user(args []string) error {
+	cmd, args, err := subcommand("user", args)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "create":
+		return createUser(args)
+	case "list":
+		return withApplication(listUsers)
+	case "disable":
+		return disableUser(args)
+	case "reset-password":
+		return resetPassword(args)
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown user command %q", cmd)
+}
+
+// readPassword returns the password read from stdin, or a generated one if
+// not reading from stdin. Generated passwords are printed, as there is no
+// other way to learn them.
+func readPassword(fromStdin bool) (string, error) {
+	if !fromStdin {
+		b := make([]byte, 18)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		password := base64.RawURLEncoding.EncodeToString(b)
+		fmt.Printf("generated password: %s\n", password)
+		return password, nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading password from stdin: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func createUser(args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ExitOnError)
+	email := fs.String("email", "", "email of the user (required)")
+	username := fs.String("username", "", "username of the user (required)")
+	firstName := fs.String("first-name", "", "first name of the user")
+	lastName := fs.String("last-name", "", "last name of the user")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin instead of generating one")
+	fs.Parse(args)
+
+	if *email == "" || *username == "" {
+		fs.Usage()
+		return errors.New("email and username are required")
+	}
+
+	return withApplication(func(ctx context.Context, app *monolith.Application) error {
+		password, err := readPassword(*passwordStdin)
+		if err != nil {
+			return err
+		}
+
+		u, err := app.Modules().Auth.CreateUser(ctx, &auth.CreateUser{
+			Email:     *email,
+			Username:  *username,
+			FirstName: *firstName,
+			LastName:  *lastName,
+			Password:  password,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created user %d (%s)\n", u.ID, u.Username)
+		return nil
+	})
+}
+
+func listUsers(ctx context.Context, app *monolith.Application) error {
+	users, err := app.Modules().Auth.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tROLE\tSTATUS\tCREATED")
+	for _, u := range users {
+		status := "active"
+		switch {
+		case u.DisabledAt != nil:
+			status = "disabled"
+		case u.DeleteAfter != nil:
+			status = "deleting"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			u.ID, u.Username, u.Email, u.Role, status, u.CreatedAt.Format("2006-01-02"))
+	}
+	return w.Flush()
+}
+
+func disableUser(args []string) error {
+	fs := flag.NewFlagSet("user disable", flag.ExitOnError)
+	id := fs.Int("id", 0, "ID of the user (required)")
+	fs.Parse(args)
+
+	if *id == 0 {
+		fs.Usage()
+		return errors.New("id is required")
+	}
+
+	return withApplication(func(ctx context.Context, app *monolith.Application) error {
+		u, err := app.Modules().Auth.DisableUser(ctx, *id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("disabled user %d (%s)\n", u.ID, u.Username)
+		return nil
+	})
+}
+
+func resetPassword(args []string) error {
+	fs := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+	id := fs.Int("id", 0, "ID of the user (required)")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin instead of generating one")
+	fs.Parse(args)
+
+	if *id == 0 {
+		fs.Usage()
+		return errors.New("id is required")
+	}
+
+	return withApplication(func(ctx context.Context, app *monolith.Application) error {
+		password, err := readPassword(*passwordStdin)
+		if err != nil {
+			return err
+		}
+		if err := app.Modules().Auth.ResetPassword(ctx, *id, password); err != nil {
+			return err
+		}
+		fmt.Printf("reset password of user %d, all of its sessions have ended\n", *id)
+		return nil
+	})
+}
@@ -21,24 +21,29 @@ type Module struct {
 	workout workout.Client
 }
 
-func (m *Module) Setup(ctx context.Context, mono monolith.Monolith) {
+func (m *Module) Name() string { return moduleName }
+
+func (m *Module) Dependencies() []string { return []string{"workout"} }
+
+func (m *Module) Setup(ctx context.Context, mono monolith.Monolith) error {
 	m.initModuleLogger(mono.Logger())
 
-	m.web = web.NewService(mono.Modules().Workout)
+	m.logger.Info("injecting workout module")
+	m.workout = mono.Modules().Workout
+	m.web = web.NewService(m.workout, mono.Flags())
 
-	// TODO: We have to wait for the monolith to be fully initialized before we can inject modules
 	m.logger.Info("injecting mux")
 	m.mux = mono.Mux()
 
 	m.logger.Info("registering routes")
 	m.web.RegisterRoutes(ctx, m.mux)
-}
 
-func (m *Module) PostSetup() {
-	m.logger.Info("performing post setup process")
+	return nil
 }
 
-func (m *Module) Shutdown() {}
+func (m *Module) Start(ctx context.Context) error { return nil }
+
+func (m *Module) Stop(ctx context.Context) error { return nil }
 
 func (m *Module) initModuleLogger(monoLogger *slog.Logger) {
 	m.logger = monoLogger.With(slog.Group("module", slog.String("name", moduleName)))
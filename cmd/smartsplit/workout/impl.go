@@ -10,6 +10,10 @@ func (m *Module) ReadMuscles(ctx context.Context) ([]*workout.Muscle, error) {
 	return m.svc.ReadMuscles(ctx)
 }
 
+func (m *Module) CreateMuscle(ctx context.Context, input *workout.MuscleInput) (*workout.Muscle, error) {
+	return m.svc.CreateMuscle(ctx, input)
+}
+
 func (m *Module) CreatePlanWithEntries(
 	ctx context.Context,
 	notes string,
@@ -6,6 +6,8 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/db"
 	"github.com/evenlwanvik/smartsplit/internal/monolith"
 	"github.com/evenlwanvik/smartsplit/internal/workout"
 )
@@ -17,18 +19,33 @@ type Module struct {
 	name     string
 	version  string
 	db       *sql.DB
+	dialect  db.Dialect
+	dbConfig *config.DatabaseConfig
 	mux      *http.ServeMux
 	handlers workout.Handlers
 	svc      *workout.Service
+	auth     monolith.Auth
+
+	stop context.CancelFunc
+	done chan struct{}
 }
 
-func (m *Module) Setup(ctx context.Context, mono monolith.Monolith) {
+func (m *Module) Name() string { return moduleName }
+
+func (m *Module) Dependencies() []string { return []string{"auth"} }
+
+func (m *Module) Setup(ctx context.Context, mono monolith.Monolith) error {
 	m.initModuleLogger(mono.Logger())
 
 	m.logger.Info("injecting database connection pool")
 	m.db = mono.DB()
+	m.dialect = mono.Dialect()
+	m.dbConfig = mono.Config().Database
 
-	m.svc = workout.NewService(workout.NewRepository(m.db))
+	m.logger.Info("injecting auth module")
+	m.auth = mono.Modules().Auth
+
+	m.svc = workout.NewService(workout.NewRepository(m.db, m.dialect), m.auth)
 
 	m.handlers = workout.Handlers{
 		Svc: m.svc,
@@ -39,13 +56,45 @@ func (m *Module) Setup(ctx context.Context, mono monolith.Monolith) {
 
 	m.logger.Info("registering routes")
 	m.handlers.RegisterRoutes(ctx, m.mux)
+
+	m.logger.Info("registering user cleanup hook")
+	m.auth.RegisterUserCleanup(moduleName, m.svc.DeleteUserData)
+
+	return nil
 }
 
-func (m *Module) PostSetup() {
-	m.logger.Info("performing post setup process")
+// Start listens for changes to the muscles made by other instances, to
+// invalidate the cached muscles. SQLite is only used by a single instance.
+func (m *Module) Start(ctx context.Context) error {
+	if m.dialect != db.Postgres {
+		return nil
+	}
+
+	ctx, m.stop = context.WithCancel(context.WithoutCancel(ctx))
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		m.logger.Info("listening for muscle changes", "channel", workout.MusclesChannel)
+		err := db.Listen(ctx, m.dbConfig, workout.MusclesChannel, m.logger, m.svc.InvalidateMuscles)
+		if err != nil {
+			m.logger.Error("failed to listen for muscle changes", "error", err)
+		}
+	}()
+	return nil
 }
 
-func (m *Module) Shutdown() {}
+func (m *Module) Stop(ctx context.Context) error {
+	if m.stop == nil {
+		return nil
+	}
+	m.stop()
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
 func (m *Module) initModuleLogger(monoLogger *slog.Logger) {
 	m.logger = monoLogger.With(slog.Group("module", slog.String("name", moduleName)))
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS auth.personal_access_tokens
+(
+    id           SERIAL PRIMARY KEY,
+    user_id      INT         NOT NULL REFERENCES auth.users (id),
+    name         TEXT        NOT NULL,
+    token_hash   TEXT        NOT NULL UNIQUE,
+    token_prefix TEXT        NOT NULL,
+    scopes       TEXT[]      NOT NULL DEFAULT '{}',
+    expires_at   TIMESTAMPTZ NULL,
+    last_used_at TIMESTAMPTZ NULL,
+    revoked_at   TIMESTAMPTZ NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS personal_access_tokens_user_id_idx
+    ON auth.personal_access_tokens (user_id);
+
+-- A name may be reused once the previous token with that name is revoked.
+CREATE UNIQUE INDEX IF NOT EXISTS personal_access_tokens_user_id_name_idx
+    ON auth.personal_access_tokens (user_id, name)
+    WHERE revoked_at IS NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS auth.users_delete_after_idx;
+
+ALTER TABLE auth.users
+    DROP COLUMN IF EXISTS delete_after;
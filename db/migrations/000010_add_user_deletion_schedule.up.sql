@@ -0,0 +1,6 @@
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS delete_after TIMESTAMPTZ NULL;
+
+CREATE INDEX IF NOT EXISTS users_delete_after_idx
+    ON auth.users (delete_after)
+    WHERE delete_after IS NOT NULL;
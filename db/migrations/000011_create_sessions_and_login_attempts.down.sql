@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS auth.login_attempts;
+
+DROP TABLE IF EXISTS auth.sessions;
+
+ALTER TABLE auth.users
+    DROP COLUMN IF EXISTS role;
@@ -0,0 +1,32 @@
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+
+-- The default user administers the instance until other admins are created.
+UPDATE auth.users SET role = 'admin' WHERE username = 'a';
+
+CREATE TABLE IF NOT EXISTS auth.sessions
+(
+    id           SERIAL PRIMARY KEY,
+    user_id      INT         NOT NULL REFERENCES auth.users (id),
+    token_hash   TEXT        NOT NULL UNIQUE,
+    ip           TEXT        NOT NULL,
+    user_agent   TEXT        NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx
+    ON auth.sessions (user_id);
+
+-- Failed login attempts are counted both per account and per client IP, so
+-- limits hold across every instance of the application.
+CREATE TABLE IF NOT EXISTS auth.login_attempts
+(
+    scope           TEXT        NOT NULL,
+    key             TEXT        NOT NULL,
+    failures        INT         NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until    TIMESTAMPTZ NULL,
+    PRIMARY KEY (scope, key)
+);
@@ -0,0 +1,15 @@
+-- External identities from OpenID Connect providers, linked to local users.
+CREATE TABLE IF NOT EXISTS auth.identities
+(
+    id            SERIAL PRIMARY KEY,
+    user_id       INT         NOT NULL REFERENCES auth.users (id),
+    provider      TEXT        NOT NULL,
+    subject       TEXT        NOT NULL,
+    email         TEXT        NOT NULL,
+    last_login_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS identities_user_id_idx
+    ON auth.identities (user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS auth.audit_log;
+DROP FUNCTION IF EXISTS auth.audit_log_append_only();
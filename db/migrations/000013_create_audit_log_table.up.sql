@@ -0,0 +1,40 @@
+-- Append-only log of security relevant actions. Actors are not foreign keys,
+-- so the log outlives the users it refers to.
+CREATE TABLE IF NOT EXISTS auth.audit_log
+(
+    id          BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor_id    INT,
+    token_id    INT,
+    action      TEXT        NOT NULL,
+    target_type TEXT        NOT NULL,
+    target_id   TEXT        NOT NULL,
+    ip          TEXT        NOT NULL DEFAULT '',
+    user_agent  TEXT        NOT NULL DEFAULT '',
+    request_id  TEXT        NOT NULL DEFAULT '',
+    changes     JSONB       NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_occurred_at_idx
+    ON auth.audit_log (occurred_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx
+    ON auth.audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_target_idx
+    ON auth.audit_log (target_type, target_id);
+
+-- Records can never be updated, and only be deleted by the retention purge,
+-- which sets smartsplit.audit_purge for its transaction.
+CREATE OR REPLACE FUNCTION auth.audit_log_append_only() RETURNS trigger AS
+$$
+BEGIN
+    IF TG_OP = 'DELETE' AND current_setting('smartsplit.audit_purge', true) = 'on' THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'auth.audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON auth.audit_log
+    FOR EACH ROW
+EXECUTE FUNCTION auth.audit_log_append_only();
@@ -0,0 +1,2 @@
+ALTER TABLE auth.users
+    DROP COLUMN IF EXISTS disabled_at;
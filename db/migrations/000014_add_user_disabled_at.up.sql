@@ -0,0 +1,3 @@
+-- Disabled users can no longer log in or use their sessions and tokens.
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ NULL;
@@ -0,0 +1,2 @@
+-- Nothing to undo, the sequence is only moved past existing IDs.
+SELECT 1;
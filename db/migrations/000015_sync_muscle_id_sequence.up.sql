@@ -0,0 +1,7 @@
+-- The common muscles were inserted with explicit IDs, which left the
+-- sequence behind, so new muscles collided with them.
+SELECT setval(
+    pg_get_serial_sequence('workout.muscles', 'id'),
+    COALESCE((SELECT MAX(id) FROM workout.muscles), 0) + 1,
+    false
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS scheduler.job_runs;
+DROP SCHEMA IF EXISTS scheduler;
@@ -0,0 +1,18 @@
+CREATE SCHEMA IF NOT EXISTS scheduler;
+
+-- History of background job runs. A run is recorded when it starts, and
+-- updated with its outcome when it finishes.
+CREATE TABLE IF NOT EXISTS scheduler.job_runs
+(
+    id          BIGSERIAL PRIMARY KEY,
+    job         TEXT        NOT NULL,
+    instance    TEXT        NOT NULL,
+    started_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ,
+    attempts    INT         NOT NULL DEFAULT 0,
+    status      TEXT        NOT NULL DEFAULT 'running',
+    error       TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS job_runs_job_started_at_idx
+    ON scheduler.job_runs (job, started_at DESC);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS events.deliveries;
+DROP TABLE IF EXISTS events.outbox;
+DROP SCHEMA IF EXISTS events;
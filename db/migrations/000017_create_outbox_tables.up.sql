@@ -0,0 +1,28 @@
+CREATE SCHEMA IF NOT EXISTS events;
+
+-- Domain events are written here in the same transaction as the change they
+-- describe, and delivered to subscribers afterwards.
+CREATE TABLE IF NOT EXISTS events.outbox
+(
+    id              BIGSERIAL PRIMARY KEY,
+    name            TEXT        NOT NULL,
+    payload         JSONB       NOT NULL,
+    occurred_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    published_at    TIMESTAMPTZ,
+    attempts        INT         NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error      TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx
+    ON events.outbox (next_attempt_at) WHERE published_at IS NULL;
+
+-- Subscribers that have handled an event, so a failing subscriber does not
+-- cause the event to be delivered again to the others.
+CREATE TABLE IF NOT EXISTS events.deliveries
+(
+    event_id     BIGINT      NOT NULL REFERENCES events.outbox (id) ON DELETE CASCADE,
+    subscriber   TEXT        NOT NULL,
+    delivered_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (event_id, subscriber)
+);
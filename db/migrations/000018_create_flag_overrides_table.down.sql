@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS flags.overrides;
+DROP SCHEMA IF EXISTS flags;
@@ -0,0 +1,14 @@
+CREATE SCHEMA IF NOT EXISTS flags;
+
+-- Runtime overrides of the feature flags defined in the config. An override
+-- replaces the rule of the flag until it is deleted.
+CREATE TABLE IF NOT EXISTS flags.overrides
+(
+    name       TEXT PRIMARY KEY,
+    enabled    BOOLEAN     NOT NULL DEFAULT false,
+    percentage INT         NOT NULL DEFAULT 0 CHECK (percentage BETWEEN 0 AND 100),
+    users      INT[]       NOT NULL DEFAULT '{}',
+    roles      TEXT[]      NOT NULL DEFAULT '{}',
+    updated_by INT REFERENCES auth.users (id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS muscles_changed ON workout.muscles;
+DROP FUNCTION IF EXISTS workout.notify_muscles_changed();
@@ -0,0 +1,15 @@
+-- Notify the instances caching the muscle catalog when it changes, whether
+-- through the application, an import or by hand. The notification is sent
+-- when the transaction commits.
+CREATE OR REPLACE FUNCTION workout.notify_muscles_changed() RETURNS trigger AS
+$$
+BEGIN
+    PERFORM pg_notify('workout_muscles_changed', TG_OP);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER muscles_changed
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON workout.muscles
+    FOR EACH STATEMENT
+EXECUTE FUNCTION workout.notify_muscles_changed();
@@ -0,0 +1,2 @@
+ALTER TABLE events.outbox
+    DROP COLUMN IF EXISTS locked_until;
@@ -0,0 +1,5 @@
+-- Claimed events are leased to an instance until locked_until, instead of
+-- being locked for as long as their delivery takes. An instance that dies
+-- mid-delivery leaves the lease to expire.
+ALTER TABLE events.outbox
+    ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ NULL;
@@ -0,0 +1,22 @@
+// Package migrations embeds the database migrations, so they ship with the
+// binary.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// FS holds the Postgres migration files, named
+// {version}_{title}.{up|down}.sql as expected by golang-migrate.
+//
+//go:embed *.sql
+var FS embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFS embed.FS
+
+// SQLite holds the migration files of the sqlite driver. They are numbered
+// on their own, and create the same tables as the Postgres migrations, with
+// the schema folded into the table names.
+var SQLite, _ = fs.Sub(sqliteFS, "sqlite")
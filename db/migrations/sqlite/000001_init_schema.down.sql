@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS flags_overrides;
+DROP TABLE IF EXISTS events_deliveries;
+DROP TABLE IF EXISTS events_outbox;
+DROP TABLE IF EXISTS scheduler_job_runs;
+DROP TABLE IF EXISTS workout_plan_entries;
+DROP TABLE IF EXISTS workout_plans;
+DROP TABLE IF EXISTS workout_muscle_ranks;
+DROP TABLE IF EXISTS workout_muscles;
+DROP TABLE IF EXISTS auth_audit_log;
+DROP TABLE IF EXISTS auth_identities;
+DROP TABLE IF EXISTS auth_login_attempts;
+DROP TABLE IF EXISTS auth_sessions;
+DROP TABLE IF EXISTS auth_personal_access_tokens;
+DROP TABLE IF EXISTS auth_users;
@@ -0,0 +1,229 @@
+-- SQLite has no schemas, so tables are prefixed with the schema they have on
+-- Postgres, and the queries are rewritten to match. Times are stored as text
+-- in UTC, in the format the driver reads them back in.
+
+CREATE TABLE auth_users
+(
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    email         TEXT      NOT NULL UNIQUE,
+    first_name    TEXT      NOT NULL,
+    last_name     TEXT      NOT NULL,
+    username      TEXT      NOT NULL UNIQUE,
+    password_hash TEXT      NOT NULL,
+    role          TEXT      NOT NULL DEFAULT 'user',
+    delete_after  TIMESTAMP NULL,
+    disabled_at   TIMESTAMP NULL,
+    created_at    TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    updated_at    TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX users_delete_after_idx
+    ON auth_users (delete_after)
+    WHERE delete_after IS NOT NULL;
+
+-- The default user administers the instance until other admins are created.
+INSERT INTO auth_users (email, first_name, last_name, username, password_hash, role)
+VALUES ('a.a@a', 'A', 'A', 'a', '$2y$10$eImiTMZG8MrAwWj7v5b1uO9z3Z5f6k1F4m5Y5Z5F5Z5F5Z5F5Z5F5Z', 'admin');
+
+CREATE TABLE auth_personal_access_tokens
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id      INTEGER   NOT NULL REFERENCES auth_users (id),
+    name         TEXT      NOT NULL,
+    token_hash   TEXT      NOT NULL UNIQUE,
+    token_prefix TEXT      NOT NULL,
+    -- Scopes are stored as a Postgres array literal, e.g. {read,write}.
+    scopes       TEXT      NOT NULL DEFAULT '{}',
+    expires_at   TIMESTAMP NULL,
+    last_used_at TIMESTAMP NULL,
+    revoked_at   TIMESTAMP NULL,
+    created_at   TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX personal_access_tokens_user_id_idx
+    ON auth_personal_access_tokens (user_id);
+
+-- A name may be reused once the previous token with that name is revoked.
+CREATE UNIQUE INDEX personal_access_tokens_user_id_name_idx
+    ON auth_personal_access_tokens (user_id, name)
+    WHERE revoked_at IS NULL;
+
+CREATE TABLE auth_sessions
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id      INTEGER   NOT NULL REFERENCES auth_users (id),
+    token_hash   TEXT      NOT NULL UNIQUE,
+    ip           TEXT      NOT NULL,
+    user_agent   TEXT      NOT NULL,
+    expires_at   TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    created_at   TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX sessions_user_id_idx
+    ON auth_sessions (user_id);
+
+CREATE TABLE auth_login_attempts
+(
+    scope           TEXT      NOT NULL,
+    key             TEXT      NOT NULL,
+    failures        INTEGER   NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    locked_until    TIMESTAMP NULL,
+    PRIMARY KEY (scope, key)
+);
+
+-- External identities from OpenID Connect providers, linked to local users.
+CREATE TABLE auth_identities
+(
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id       INTEGER   NOT NULL REFERENCES auth_users (id),
+    provider      TEXT      NOT NULL,
+    subject       TEXT      NOT NULL,
+    email         TEXT      NOT NULL,
+    last_login_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    created_at    TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX identities_user_id_idx
+    ON auth_identities (user_id);
+
+-- Append-only log of security relevant actions. Actors are not foreign keys,
+-- so the log outlives the users it refers to.
+CREATE TABLE auth_audit_log
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    occurred_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    actor_id    INTEGER,
+    token_id    INTEGER,
+    action      TEXT      NOT NULL,
+    target_type TEXT      NOT NULL,
+    target_id   TEXT      NOT NULL,
+    ip          TEXT      NOT NULL DEFAULT '',
+    user_agent  TEXT      NOT NULL DEFAULT '',
+    request_id  TEXT      NOT NULL DEFAULT '',
+    changes     TEXT      NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX audit_log_occurred_at_idx
+    ON auth_audit_log (occurred_at);
+CREATE INDEX audit_log_actor_id_idx
+    ON auth_audit_log (actor_id);
+CREATE INDEX audit_log_target_idx
+    ON auth_audit_log (target_type, target_id);
+
+-- Records can never be updated. Unlike on Postgres, deletes cannot be
+-- limited to the retention purge.
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE ON auth_audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'auth.audit_log is append-only');
+END;
+
+CREATE TABLE workout_muscles
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    name         TEXT NOT NULL,
+    muscle_group TEXT NOT NULL,
+    description  TEXT
+);
+
+INSERT INTO workout_muscles (id, name, muscle_group, description) VALUES
+(1, 'Biceps', 'Back', 'Muscle located in the upper arm, responsible for flexing the elbow.'),
+(2, 'Triceps', 'Front', 'Muscle located at the back of the upper arm, responsible for extending the elbow.'),
+(3, 'Chest', 'Front', 'Large muscle in the chest that helps with arm movement and stability.'),
+(4, 'Back', 'Back', 'Muscles in the upper and lower back that help with posture and movement.'),
+(5, 'Quads', 'Legs', 'Muscle group at the front of the thigh, responsible for extending the knee.'),
+(6, 'Hamstrings', 'Legs', 'Muscle group at the back of the thigh, responsible for flexing the knee.'),
+(7, 'Calves', 'Legs', 'Muscles located at the back of the lower leg, responsible for plantar flexion of the foot.'),
+(8, 'Shoulders', 'Shoulders', 'Muscles that help with arm movement and stability of the shoulder joint.'),
+(9, 'Abs', 'Core', 'Muscles in the abdominal area that help with core stability and movement.'),
+(10, 'Glutes', 'Legs', 'Muscles in the buttocks that help with hip movement and stability.');
+
+CREATE TABLE workout_muscle_ranks
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER   NOT NULL REFERENCES auth_users (id),
+    muscle_id  INTEGER   NOT NULL REFERENCES workout_muscles (id),
+    rank       INTEGER   NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    UNIQUE (user_id, muscle_id)
+);
+
+CREATE TABLE workout_plans
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER   NOT NULL REFERENCES auth_users (id),
+    date       DATE      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    notes      TEXT
+);
+
+CREATE TABLE workout_plan_entries
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    plan_id    INTEGER   NOT NULL REFERENCES workout_plans (id),
+    muscle_id  INTEGER   NOT NULL REFERENCES workout_muscles (id),
+    sets       INTEGER   NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    UNIQUE (plan_id, muscle_id)
+);
+
+-- History of background job runs. A run is recorded when it starts, and
+-- updated with its outcome when it finishes.
+CREATE TABLE scheduler_job_runs
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    job         TEXT      NOT NULL,
+    instance    TEXT      NOT NULL,
+    started_at  TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    finished_at TIMESTAMP,
+    attempts    INTEGER   NOT NULL DEFAULT 0,
+    status      TEXT      NOT NULL DEFAULT 'running',
+    error       TEXT      NOT NULL DEFAULT ''
+);
+
+CREATE INDEX job_runs_job_started_at_idx
+    ON scheduler_job_runs (job, started_at DESC);
+
+-- Domain events are written here in the same transaction as the change they
+-- describe, and delivered to subscribers afterwards.
+CREATE TABLE events_outbox
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    name            TEXT      NOT NULL,
+    payload         TEXT      NOT NULL,
+    occurred_at     TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    published_at    TIMESTAMP,
+    attempts        INTEGER   NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_error      TEXT      NOT NULL DEFAULT ''
+);
+
+CREATE INDEX outbox_pending_idx
+    ON events_outbox (next_attempt_at) WHERE published_at IS NULL;
+
+-- Subscribers that have handled an event, so a failing subscriber does not
+-- cause the event to be delivered again to the others.
+CREATE TABLE events_deliveries
+(
+    event_id     INTEGER   NOT NULL REFERENCES events_outbox (id) ON DELETE CASCADE,
+    subscriber   TEXT      NOT NULL,
+    delivered_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    PRIMARY KEY (event_id, subscriber)
+);
+
+-- Runtime overrides of the feature flags defined in the config. An override
+-- replaces the rule of the flag until it is deleted.
+CREATE TABLE flags_overrides
+(
+    name       TEXT PRIMARY KEY,
+    enabled    BOOLEAN   NOT NULL DEFAULT false,
+    percentage INTEGER   NOT NULL DEFAULT 0 CHECK (percentage BETWEEN 0 AND 100),
+    -- Users and roles are stored as Postgres array literals.
+    users      TEXT      NOT NULL DEFAULT '{}',
+    roles      TEXT      NOT NULL DEFAULT '{}',
+    updated_by INTEGER REFERENCES auth_users (id) ON DELETE SET NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
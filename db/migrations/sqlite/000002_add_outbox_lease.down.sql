@@ -0,0 +1,2 @@
+ALTER TABLE events_outbox
+    DROP COLUMN locked_until;
@@ -0,0 +1,4 @@
+-- Claimed events are leased to an instance until locked_until, instead of
+-- being locked for as long as their delivery takes.
+ALTER TABLE events_outbox
+    ADD COLUMN locked_until TIMESTAMP NULL;
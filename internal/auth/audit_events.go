@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/evenlwanvik/smartsplit/internal/events"
+)
+
+// auditSubscriber is the name the audit log subscribes to events by.
+const auditSubscriber = "auth.audit"
+
+// SubscribeEvents records the workout events published on the bus in the
+// audit log, attributed to the owner of the plan.
+func (svc *AuditService) SubscribeEvents(bus *events.Bus) error {
+	return errors.Join(
+		events.Subscribe(bus, auditSubscriber, func(ctx context.Context, meta events.Metadata, ev events.PlanCreated) error {
+			return svc.recordEvent(ctx, meta, AuditEntry{
+				Action:     "workout.plan.create",
+				TargetType: "plan",
+				TargetID:   strconv.Itoa(ev.PlanID),
+				ActorID:    &ev.UserID,
+				Changes:    AuditDiff(nil, ev),
+			})
+		}),
+		events.Subscribe(bus, auditSubscriber, func(ctx context.Context, meta events.Metadata, ev events.PlanDeleted) error {
+			return svc.recordEvent(ctx, meta, AuditEntry{
+				Action:     "workout.plan.delete",
+				TargetType: "plan",
+				TargetID:   strconv.Itoa(ev.PlanID),
+				ActorID:    &ev.UserID,
+				Changes:    AuditDiff(ev, nil),
+			})
+		}),
+		events.Subscribe(bus, auditSubscriber, func(ctx context.Context, meta events.Metadata, ev events.EntryUpdated) error {
+			return svc.recordEvent(ctx, meta, AuditEntry{
+				Action:     "workout.entry.update",
+				TargetType: "plan_entry",
+				TargetID:   strconv.Itoa(ev.EntryID),
+				Changes:    AuditDiff(nil, map[string]int{"sets": ev.Sets}),
+			})
+		}),
+	)
+}
+
+// recordEvent appends an entry for an event to the audit log. The event ID
+// is used as request ID, so an event delivered more than once is only
+// recorded once. Unlike Record, failures are returned, so the delivery is
+// retried.
+func (svc *AuditService) recordEvent(ctx context.Context, meta events.Metadata, entry AuditEntry) error {
+	record := &AuditRecord{
+		ActorID:    entry.ActorID,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		RequestID:  fmt.Sprintf("event:%d", meta.ID),
+		Changes:    entry.Changes,
+	}
+	if record.Changes == nil {
+		record.Changes = map[string]AuditChange{}
+	}
+	_, err := svc.repo.InsertOnce(ctx, record)
+	return err
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// AuditHandler defines HTTP handlers for the audit log.
+type AuditHandler struct {
+	Service *AuditService
+}
+
+// RegisterRoutes hooks up endpoints.
+func (h *AuditHandler) RegisterRoutes(ctx context.Context, mux *http.ServeMux) {
+	logger := logging.LoggerFromContext(ctx)
+
+	routeDefinitions := rest.RouteDefinitionList{
+		{
+			Path:    "GET /api/v0/auth/audit",
+			Handler: RequireAdmin(h.listRecordsHandler),
+		},
+	}
+
+	for _, d := range routeDefinitions {
+		logger.Info("adding route", "route", d.Path)
+		mux.Handle(d.Path, d)
+	}
+}
+
+// auditFiltersFromRequest reads the filters from the query string. Times are
+// expected in RFC 3339 format.
+func auditFiltersFromRequest(r *http.Request) (AuditFilters, error) {
+	query := r.URL.Query()
+	filters := AuditFilters{
+		ActorID:  rest.GetQueryParamInt(r, "actor_id"),
+		PageSize: rest.GetQueryParamInt(r, "page_size"),
+	}
+
+	for key, field := range map[string]**string{
+		"action":      &filters.Action,
+		"target_type": &filters.TargetType,
+		"target_id":   &filters.TargetID,
+	} {
+		if v := query.Get(key); v != "" {
+			*field = &v
+		}
+	}
+
+	for key, field := range map[string]**time.Time{
+		"from": &filters.From,
+		"to":   &filters.To,
+	} {
+		v := query.Get(key)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filters, fmt.Errorf("%s must be an RFC 3339 timestamp", key)
+		}
+		*field = &t
+	}
+
+	if v := query.Get("last_seen"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filters, fmt.Errorf("last_seen must be an integer")
+		}
+		filters.LastSeen = &id
+	}
+
+	pageSize := defaultAuditPageSize
+	if filters.PageSize != nil {
+		pageSize = min(max(*filters.PageSize, 1), maxAuditPageSize)
+	}
+	filters.PageSize = &pageSize
+
+	return filters, nil
+}
+
+func (h *AuditHandler) listRecordsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	filters, err := auditFiltersFromRequest(r)
+	if err != nil {
+		rest.BadRequestResponse(w, r, err.Error(), err)
+		return
+	}
+	logger = logger.With(slog.Group("input", slog.Any("filters", filters)))
+
+	logger.Info("reading audit records")
+	records, metadata, err := h.Service.ListRecords(ctx, filters)
+	if err != nil {
+		logger.Error("failed to read audit records", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, AuditRecordList{
+		Records:  records,
+		Metadata: metadata,
+	})
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"time"
+)
+
+// AuditAction is the kind of action recorded in the audit log. Actions of
+// other modules are namespaced by module, e.g. "workout.muscle.create".
+type AuditAction string
+
+const (
+	AuditUserRegister       AuditAction = "user.register"
+	AuditUserUpdate         AuditAction = "user.update"
+	AuditUserPasswordChange AuditAction = "user.password_change"
+	AuditUserPasswordReset  AuditAction = "user.password_reset"
+	AuditUserDisable        AuditAction = "user.disable"
+	AuditUserDelete         AuditAction = "user.delete"
+	AuditUserRestore        AuditAction = "user.restore"
+	AuditUserPurge          AuditAction = "user.purge"
+	AuditTokenCreate        AuditAction = "token.create"
+	AuditTokenRevoke        AuditAction = "token.revoke"
+	AuditLogin              AuditAction = "session.login"
+	AuditLoginFailed        AuditAction = "session.login_failed"
+	AuditLogout             AuditAction = "session.logout"
+	AuditIdentityLink       AuditAction = "identity.link"
+	AuditLockoutUnlock      AuditAction = "lockout.unlock"
+)
+
+// AuditChange is the value of a field before and after an action. Before is
+// nil for created resources, and After is nil for deleted ones.
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditRecord is an entry in the audit log.
+type AuditRecord struct {
+	ID         int64                  `json:"id"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	ActorID    *int                   `json:"actor_id"`
+	TokenID    *int                   `json:"token_id,omitempty"`
+	Action     AuditAction            `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   string                 `json:"target_id"`
+	IP         string                 `json:"ip"`
+	UserAgent  string                 `json:"user_agent"`
+	RequestID  string                 `json:"request_id"`
+	Changes    map[string]AuditChange `json:"changes"`
+}
+
+// AuditFilters narrows down a query of the audit log. Records are returned
+// newest first, and LastSeen is the ID of the last record of the previous
+// page.
+type AuditFilters struct {
+	ActorID    *int       `json:"actor_id,omitempty"`
+	Action     *string    `json:"action,omitempty"`
+	TargetType *string    `json:"target_type,omitempty"`
+	TargetID   *string    `json:"target_id,omitempty"`
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+	PageSize   *int       `json:"page_size,omitempty"`
+	LastSeen   *int64     `json:"last_seen,omitempty"`
+}
+
+// AuditMetadata is returned along with a page of audit records.
+type AuditMetadata struct {
+	LastSeen int64 `json:"last_seen"`
+}
+
+// AuditRecordList is a page of audit records.
+type AuditRecordList struct {
+	Records  []*AuditRecord `json:"records"`
+	Metadata *AuditMetadata `json:"metadata"`
+}
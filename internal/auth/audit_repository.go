@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+// AuditRepository provides access to the audit log. Records can only be
+// appended, and are only ever removed by Prune once they are past retention.
+type AuditRepository struct {
+	db      *sql.DB
+	dialect db.Dialect
+	// q runs the queries rewritten to the dialect.
+	q db.Querier
+}
+
+// NewAuditRepository creates a new AuditRepository.
+func NewAuditRepository(db *sql.DB, dialect db.Dialect) *AuditRepository {
+	return &AuditRepository{db: db, dialect: dialect, q: dialect.Wrap(db)}
+}
+
+// Insert appends a record to the audit log.
+func (r *AuditRepository) Insert(ctx context.Context, record *AuditRecord) error {
+	defer metrics.ObserveQuery("audit", "Insert", time.Now())
+	changes, err := json.Marshal(record.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO auth.audit_log
+		(actor_id, token_id, action, target_type, target_id, ip, user_agent, request_id, changes)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id, occurred_at
+	`
+	return r.q.QueryRowContext(
+		ctx,
+		query,
+		record.ActorID,
+		record.TokenID,
+		record.Action,
+		record.TargetType,
+		record.TargetID,
+		record.IP,
+		record.UserAgent,
+		record.RequestID,
+		changes,
+	).Scan(&record.ID, &record.OccurredAt)
+}
+
+// InsertOnce appends a record to the audit log unless a record with the
+// same action and request ID exists. It reports whether the record was
+// appended.
+func (r *AuditRepository) InsertOnce(ctx context.Context, record *AuditRecord) (bool, error) {
+	defer metrics.ObserveQuery("audit", "InsertOnce", time.Now())
+	changes, err := json.Marshal(record.Changes)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+	INSERT INTO auth.audit_log
+		(actor_id, token_id, action, target_type, target_id, ip, user_agent, request_id, changes)
+	SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9
+	WHERE NOT EXISTS (
+		SELECT 1 FROM auth.audit_log WHERE action = $3 AND request_id = $8
+	)
+	RETURNING id, occurred_at
+	`
+	err = r.q.QueryRowContext(
+		ctx,
+		query,
+		record.ActorID,
+		record.TokenID,
+		record.Action,
+		record.TargetType,
+		record.TargetID,
+		record.IP,
+		record.UserAgent,
+		record.RequestID,
+		changes,
+	).Scan(&record.ID, &record.OccurredAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// List returns the records matching the filters, newest first.
+func (r *AuditRepository) List(ctx context.Context, filters AuditFilters) ([]*AuditRecord, *AuditMetadata, error) {
+	defer metrics.ObserveQuery("audit", "List", time.Now())
+	query := `
+	SELECT id, occurred_at, actor_id, token_id, action, target_type, target_id, ip, user_agent, request_id, changes
+	FROM auth.audit_log
+	WHERE ($1::int IS NULL OR actor_id = $1)
+	AND ($2::text IS NULL OR action = $2)
+	AND ($3::text IS NULL OR target_type = $3)
+	AND ($4::text IS NULL OR target_id = $4)
+	AND ($5::timestamptz IS NULL OR occurred_at >= $5)
+	AND ($6::timestamptz IS NULL OR occurred_at < $6)
+	AND ($7::bigint IS NULL OR id < $7)
+	ORDER BY id DESC
+	LIMIT $8
+	`
+	rows, err := r.q.QueryContext(
+		ctx,
+		query,
+		filters.ActorID,
+		filters.Action,
+		filters.TargetType,
+		filters.TargetID,
+		filters.From,
+		filters.To,
+		filters.LastSeen,
+		filters.PageSize,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	records := []*AuditRecord{}
+	for rows.Next() {
+		var (
+			rec     AuditRecord
+			changes []byte
+		)
+		err := rows.Scan(
+			&rec.ID,
+			&rec.OccurredAt,
+			&rec.ActorID,
+			&rec.TokenID,
+			&rec.Action,
+			&rec.TargetType,
+			&rec.TargetID,
+			&rec.IP,
+			&rec.UserAgent,
+			&rec.RequestID,
+			&changes,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(changes, &rec.Changes); err != nil {
+			return nil, nil, err
+		}
+		records = append(records, &rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var metadata AuditMetadata
+	if len(records) > 0 {
+		metadata.LastSeen = records[len(records)-1].ID
+	}
+	return records, &metadata, nil
+}
+
+// Prune removes records that occurred before the given time, and returns
+// the number of removed records.
+func (r *AuditRepository) Prune(ctx context.Context, before time.Time) (int64, error) {
+	defer metrics.ObserveQuery("audit", "Prune", time.Now())
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// The append-only trigger only lets deletes through when this is set.
+	// SQLite has no settings, and its trigger only refuses updates.
+	if r.dialect == db.Postgres {
+		if _, err := tx.ExecContext(ctx, `SET LOCAL smartsplit.audit_purge = 'on'`); err != nil {
+			return 0, err
+		}
+	}
+
+	query := `DELETE FROM auth.audit_log WHERE occurred_at < $1`
+	res, err := r.dialect.Wrap(tx).ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/common"
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+)
+
+const AuditRequestCtxKey common.ContextKey = "audit_request"
+
+// AuditRequest describes the request an action is performed in.
+type AuditRequest struct {
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// WithAuditRequest embeds the request details in the given context.
+func WithAuditRequest(ctx context.Context, req *AuditRequest) context.Context {
+	return context.WithValue(ctx, AuditRequestCtxKey, req)
+}
+
+// AuditRequestFromContext attempts to extract embedded request details from
+// the given context. The second return value is false outside of requests,
+// e.g. for scheduled jobs.
+func AuditRequestFromContext(ctx context.Context) (*AuditRequest, bool) {
+	req, ok := ctx.Value(AuditRequestCtxKey).(*AuditRequest)
+	return req, ok
+}
+
+// AuditEntry is an action to record in the audit log.
+type AuditEntry struct {
+	Action     AuditAction
+	TargetType string
+	TargetID   string
+	// ActorID is the actor of anonymous requests, such as the user logging
+	// in. The principal of the request takes precedence when there is one.
+	ActorID *int
+	Changes map[string]AuditChange
+}
+
+// AuditService records security relevant actions in the audit log.
+type AuditService struct {
+	repo *AuditRepository
+	// retention is how long records are kept. Records are kept forever if
+	// it is zero.
+	retention time.Duration
+}
+
+// NewAuditService creates a new AuditService.
+func NewAuditService(repo *AuditRepository, retention time.Duration) *AuditService {
+	return &AuditService{repo: repo, retention: retention}
+}
+
+// Record appends an entry to the audit log, attributed to the principal and
+// request found in the context.
+//
+// Recording is best effort: the action has already taken place, so a
+// failure is logged rather than returned.
+func (svc *AuditService) Record(ctx context.Context, entry AuditEntry) {
+	logger := logging.LoggerFromContext(ctx)
+
+	record := &AuditRecord{
+		ActorID:    entry.ActorID,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Changes:    entry.Changes,
+	}
+	if record.Changes == nil {
+		record.Changes = map[string]AuditChange{}
+	}
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		record.ActorID = &principal.UserID
+		record.TokenID = principal.TokenID
+	}
+	if req, ok := AuditRequestFromContext(ctx); ok {
+		record.IP = req.IP
+		record.UserAgent = req.UserAgent
+		record.RequestID = req.RequestID
+	}
+
+	if err := svc.repo.Insert(ctx, record); err != nil {
+		logger.Error(
+			"failed to record audit entry",
+			slog.String("action", string(entry.Action)),
+			slog.String("target_type", entry.TargetType),
+			slog.String("target_id", entry.TargetID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// ListRecords returns the records matching the filters, newest first.
+func (svc *AuditService) ListRecords(ctx context.Context, filters AuditFilters) ([]*AuditRecord, *AuditMetadata, error) {
+	return svc.repo.List(ctx, filters)
+}
+
+// Prune removes records past the retention period.
+func (svc *AuditService) Prune(ctx context.Context) (int64, error) {
+	if svc.retention <= 0 {
+		return 0, nil
+	}
+	return svc.repo.Prune(ctx, time.Now().Add(-svc.retention))
+}
+
+// auditIgnoredFields change on every write and are left out of diffs.
+var auditIgnoredFields = []string{"created_at", "updated_at"}
+
+// AuditDiff returns the fields that differ between the JSON representations
+// of before and after. Either may be nil, for created or deleted resources.
+// Fields hidden from JSON, such as password hashes, never end up in a diff.
+func AuditDiff(before any, after any) map[string]AuditChange {
+	b, a := auditFields(before), auditFields(after)
+
+	changes := make(map[string]AuditChange)
+	for key, value := range b {
+		if !reflect.DeepEqual(value, a[key]) {
+			changes[key] = AuditChange{Before: value, After: a[key]}
+		}
+	}
+	for key, value := range a {
+		if _, ok := b[key]; !ok {
+			changes[key] = AuditChange{Before: nil, After: value}
+		}
+	}
+	for _, key := range auditIgnoredFields {
+		delete(changes, key)
+	}
+	return changes
+}
+
+func auditFields(v any) map[string]any {
+	fields := map[string]any{}
+	if v == nil {
+		return fields
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	_ = json.Unmarshal(js, &fields)
+	return fields
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+)
+
+func TestAuditDiff(t *testing.T) {
+	type account struct {
+		Name      string    `json:"name"`
+		Email     string    `json:"email"`
+		Hash      string    `json:"-"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	before := &account{Name: "ada", Email: "ada@example.com", Hash: "a", UpdatedAt: time.Unix(1, 0)}
+	after := &account{Name: "ada", Email: "ada@example.org", Hash: "b", UpdatedAt: time.Unix(2, 0)}
+
+	tests := []struct {
+		name   string
+		before any
+		after  any
+		want   map[string]AuditChange
+	}{
+		{
+			name:   "updated",
+			before: before,
+			after:  after,
+			want:   map[string]AuditChange{"email": {Before: "ada@example.com", After: "ada@example.org"}},
+		},
+		{
+			name:  "created",
+			after: before,
+			want: map[string]AuditChange{
+				"name":  {Before: nil, After: "ada"},
+				"email": {Before: nil, After: "ada@example.com"},
+			},
+		},
+		{
+			name:   "deleted",
+			before: before,
+			want: map[string]AuditChange{
+				"name":  {Before: "ada", After: nil},
+				"email": {Before: "ada@example.com", After: nil},
+			},
+		},
+		{name: "unchanged", before: before, after: before, want: map[string]AuditChange{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AuditDiff(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	s := newTestServices(t)
+	userID := s.createUser(t, "ada")
+
+	tokenID := 9
+	ctx := WithAuditRequest(
+		WithPrincipal(context.Background(), &Principal{UserID: userID, Role: RoleUser, TokenID: &tokenID}),
+		&AuditRequest{IP: "192.0.2.1", UserAgent: "test", RequestID: "req-1"},
+	)
+	for i := range 3 {
+		s.audit.Record(ctx, AuditEntry{Action: AuditTokenCreate, TargetType: "token", TargetID: strconv.Itoa(i)})
+	}
+
+	list := func(query string) (int, AuditRecordList) {
+		t.Helper()
+		w := serve(&AuditHandler{Service: s.audit}, admin, http.MethodGet, "/api/v0/auth/audit"+query, "")
+		var page AuditRecordList
+		json.NewDecoder(w.Body).Decode(&page)
+		return w.Code, page
+	}
+
+	code, page := list("?action=token.create&page_size=2")
+	if code != http.StatusOK || len(page.Records) != 2 {
+		t.Fatalf("got %d with %d records, want a page of 2", code, len(page.Records))
+	}
+	got := page.Records[0]
+	if got.TargetID != "2" || *got.ActorID != userID || *got.TokenID != tokenID || got.IP != "192.0.2.1" || got.RequestID != "req-1" {
+		t.Errorf("got %+v, want the newest record attributed to the principal and request", got)
+	}
+
+	_, next := list("?action=token.create&page_size=2&last_seen=" + strconv.FormatInt(page.Metadata.LastSeen, 10))
+	if len(next.Records) != 1 || next.Records[0].TargetID != "0" {
+		t.Errorf("got %+v, want the oldest record on the next page", next.Records)
+	}
+
+	if _, page := list("?actor_id=" + strconv.Itoa(userID) + "&target_type=user"); len(page.Records) != 1 || page.Records[0].Action != AuditUserRegister {
+		t.Errorf("got %+v, want the registration of the user", page.Records)
+	}
+	if _, page := list("?to=2000-01-01T00:00:00Z"); len(page.Records) != 0 {
+		t.Errorf("got %d records before 2000", len(page.Records))
+	}
+	if code, _ := list("?from=yesterday"); code != http.StatusBadRequest {
+		t.Errorf("got %d for a bad timestamp, want %d", code, http.StatusBadRequest)
+	}
+	if w := serve(&AuditHandler{Service: s.audit}, userPrincipal(userID), http.MethodGet, "/api/v0/auth/audit", ""); w.Code != http.StatusForbidden {
+		t.Errorf("got %d for a user, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestAuditRetention(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	// The log is append-only, so the records are aged by waiting.
+	s.audit.Record(ctx, AuditEntry{Action: AuditLogin, TargetType: "user", TargetID: "1"})
+	time.Sleep(200 * time.Millisecond)
+	s.audit.Record(ctx, AuditEntry{Action: AuditLogout, TargetType: "user", TargetID: "1"})
+
+	if _, err := s.db.Exec(`UPDATE auth_audit_log SET target_id = '2'`); err == nil {
+		t.Error("got audit records updated, want the log append-only")
+	}
+
+	// Records are kept forever without a retention period.
+	if n, err := s.audit.Prune(ctx); err != nil || n != 0 {
+		t.Fatalf("got %d, %v pruning without retention", n, err)
+	}
+
+	audit := NewAuditService(NewAuditRepository(s.db, db.SQLite), 100*time.Millisecond)
+	if n, err := audit.Prune(ctx); err != nil || n != 1 {
+		t.Fatalf("got %d, %v, want the old record pruned", n, err)
+	}
+	records, _, err := audit.ListRecords(ctx, AuditFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Action != AuditLogout {
+		t.Errorf("got %+v, want the recent record kept", records)
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/db/dbtest"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	os.Exit(m.Run())
+}
+
+// adminID is the admin user seeded by the migrations.
+const adminID = 1
+
+var (
+	anonymous = (*Principal)(nil)
+	admin     = &Principal{UserID: adminID, Role: RoleAdmin}
+)
+
+// testServices are the services of the handlers under test, on a migrated
+// SQLite database.
+type testServices struct {
+	db       *sql.DB
+	audit    *AuditService
+	users    *UserService
+	tokens   *TokenService
+	lockouts *LockoutService
+	sessions *SessionService
+}
+
+// testLockoutPolicy locks an account out after three failures, and an IP
+// after five.
+var testLockoutPolicy = LockoutPolicy{
+	AccountThreshold: 3,
+	IPThreshold:      5,
+	BaseDelay:        time.Minute,
+	MaxDelay:         time.Hour,
+	ResetAfter:       time.Hour,
+}
+
+func newTestServices(t *testing.T) *testServices {
+	t.Helper()
+	sqlDB := dbtest.NewSQLite(t)
+	audit := NewAuditService(NewAuditRepository(sqlDB, db.SQLite), 0)
+	users := NewUserRepository(sqlDB, db.SQLite)
+	lockouts := NewLockoutService(NewLockoutRepository(sqlDB, db.SQLite), audit, testLockoutPolicy)
+	return &testServices{
+		db:       sqlDB,
+		audit:    audit,
+		users:    NewUserService(users, audit, 24*time.Hour),
+		tokens:   NewTokenService(NewTokenRepository(sqlDB, db.SQLite), audit),
+		lockouts: lockouts,
+		sessions: NewSessionService(NewSessionRepository(sqlDB, db.SQLite), users, lockouts, audit, time.Hour),
+	}
+}
+
+// createUser creates a user with the given username, returning its ID.
+func (s *testServices) createUser(t *testing.T, username string) int {
+	t.Helper()
+	user, err := s.users.CreateUser(context.Background(), &CreateUser{
+		Email:     username + "@example.com",
+		FirstName: "Test",
+		LastName:  "User",
+		Username:  username,
+		Password:  "correct horse battery staple",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// userPrincipal is a principal authenticated as the given user.
+func userPrincipal(id int) *Principal {
+	return &Principal{UserID: id, Role: RoleUser}
+}
+
+// serve sends a request as the given principal, or anonymously if it is
+// nil, through a mux with the routes registered.
+func serve(
+	routes interface {
+		RegisterRoutes(context.Context, *http.ServeMux)
+	},
+	principal *Principal, method, target, body string,
+) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	routes.RegisterRoutes(context.Background(), mux)
+
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if principal != nil {
+		r = r.WithContext(WithPrincipal(r.Context(), principal))
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+var (
+	// ErrIdentityNotFound is returned when an external identity is not
+	// linked to any user.
+	ErrIdentityNotFound = errors.New("identity not found")
+)
+
+// IdentityRepository provides access to the external identities store.
+type IdentityRepository struct {
+	// db runs the queries rewritten to the dialect.
+	db db.Querier
+}
+
+// NewIdentityRepository creates a new IdentityRepository.
+func NewIdentityRepository(db *sql.DB, dialect db.Dialect) *IdentityRepository {
+	return &IdentityRepository{db: dialect.Wrap(db)}
+}
+
+// Touch records a login with an external identity, and returns the ID of the
+// user it is linked to.
+func (r *IdentityRepository) Touch(ctx context.Context, provider string, subject string, email string) (int, error) {
+	defer metrics.ObserveQuery("identity", "Touch", time.Now())
+	query := `
+	UPDATE auth.identities
+	SET last_login_at = now(), email = $3
+	WHERE provider = $1 AND subject = $2
+	AND user_id IN (SELECT id FROM auth.users WHERE disabled_at IS NULL)
+	RETURNING user_id
+	`
+	var userID int
+	err := r.db.QueryRowContext(ctx, query, provider, subject, email).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrIdentityNotFound
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Link links an external identity to the user with the given verified email,
+// and returns the ID of the user.
+func (r *IdentityRepository) Link(ctx context.Context, provider string, subject string, email string) (int, error) {
+	defer metrics.ObserveQuery("identity", "Link", time.Now())
+	query := `
+	INSERT INTO auth.identities (user_id, provider, subject, email)
+	SELECT id, $1, $2, $3
+	FROM auth.users
+	WHERE lower(email) = lower($3)
+	AND disabled_at IS NULL
+	ON CONFLICT (provider, subject) DO UPDATE
+	SET last_login_at = now(), email = EXCLUDED.email
+	RETURNING user_id
+	`
+	var userID int
+	err := r.db.QueryRowContext(ctx, query, provider, subject, email).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	return userID, nil
+}
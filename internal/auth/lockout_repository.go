@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+var (
+	// ErrLockoutNotFound is returned when there are no failed attempts
+	// recorded for a scope and key.
+	ErrLockoutNotFound = errors.New("lockout not found")
+)
+
+// LockoutRepository provides access to the failed login attempts store.
+type LockoutRepository struct {
+	// db runs the queries rewritten to the dialect.
+	db      db.Querier
+	dialect db.Dialect
+}
+
+// NewLockoutRepository creates a new LockoutRepository.
+func NewLockoutRepository(db *sql.DB, dialect db.Dialect) *LockoutRepository {
+	return &LockoutRepository{db: dialect.Wrap(db), dialect: dialect}
+}
+
+// LockedUntil returns the time a scope and key is locked until, or nil if it
+// is not locked.
+func (r *LockoutRepository) LockedUntil(ctx context.Context, scope LockoutScope, key string) (*time.Time, error) {
+	defer metrics.ObserveQuery("lockout", "LockedUntil", time.Now())
+	query := `
+	SELECT locked_until
+	FROM auth.login_attempts
+	WHERE scope = $1 AND key = $2 AND locked_until > now()
+	`
+	var lockedUntil time.Time
+	err := r.db.QueryRowContext(ctx, query, scope, key).Scan(&lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &lockedUntil, nil
+}
+
+// RecordAttempt counts a login attempt, and returns the number of
+// consecutive attempts, or ErrLocked if the attempt is not allowed. Attempts
+// older than resetAfter are forgotten.
+//
+// An attempt is allowed while the scope and key is not locked and below the
+// threshold, and once after every lockout has passed. The check and the
+// count are a single statement, so concurrent attempts are counted one after
+// the other, and cannot all pass before any of them is counted.
+func (r *LockoutRepository) RecordAttempt(
+	ctx context.Context, scope LockoutScope, key string, threshold int, resetAfter time.Duration,
+) (int, error) {
+	defer metrics.ObserveQuery("lockout", "RecordAttempt", time.Now())
+	query := `
+	INSERT INTO auth.login_attempts AS a (scope, key, failures, last_failure_at)
+	VALUES ($1, $2, 1, now())
+	ON CONFLICT (scope, key) DO UPDATE
+	SET failures = CASE
+			WHEN a.last_failure_at < now() - make_interval(secs => $3) THEN 1
+			ELSE a.failures + 1
+		END,
+		last_failure_at = now()
+	WHERE (a.locked_until IS NULL OR a.locked_until <= now())
+	AND (
+		$4 <= 0
+		OR a.failures < $4
+		OR a.last_failure_at < now() - make_interval(secs => $3)
+		OR a.last_failure_at <= a.locked_until
+	)
+	RETURNING failures
+	`
+	if r.dialect == db.SQLite {
+		query = `
+		INSERT INTO auth.login_attempts AS a (scope, key, failures, last_failure_at)
+		VALUES ($1, $2, 1, now())
+		ON CONFLICT (scope, key) DO UPDATE
+		SET failures = CASE
+				WHEN a.last_failure_at < strftime('%Y-%m-%d %H:%M:%f+00:00', 'now', -$3 || ' seconds') THEN 1
+				ELSE a.failures + 1
+			END,
+			last_failure_at = now()
+		WHERE (a.locked_until IS NULL OR a.locked_until <= now())
+		AND (
+			$4 <= 0
+			OR a.failures < $4
+			OR a.last_failure_at < strftime('%Y-%m-%d %H:%M:%f+00:00', 'now', -$3 || ' seconds')
+			OR a.last_failure_at <= a.locked_until
+		)
+		RETURNING failures
+		`
+	}
+	var failures int
+	err := r.db.QueryRowContext(ctx, query, scope, key, resetAfter.Seconds(), threshold).Scan(&failures)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrLocked
+	}
+	return failures, err
+}
+
+// Release takes back an attempt counted by RecordAttempt, for attempts that
+// turned out not to be failures. A lockout that has passed is moved up to
+// now, so the attempt allowed after it can be made again.
+func (r *LockoutRepository) Release(ctx context.Context, scope LockoutScope, key string) error {
+	defer metrics.ObserveQuery("lockout", "Release", time.Now())
+	query := `
+	UPDATE auth.login_attempts
+	SET failures = failures - 1,
+		locked_until = CASE WHEN locked_until <= now() THEN now() ELSE locked_until END
+	WHERE scope = $1 AND key = $2 AND failures > 0
+	`
+	_, err := r.db.ExecContext(ctx, query, scope, key)
+	return err
+}
+
+// Lock locks a scope and key until the given time.
+func (r *LockoutRepository) Lock(ctx context.Context, scope LockoutScope, key string, until time.Time) error {
+	defer metrics.ObserveQuery("lockout", "Lock", time.Now())
+	query := `
+	UPDATE auth.login_attempts
+	SET locked_until = $3
+	WHERE scope = $1 AND key = $2
+	`
+	_, err := r.db.ExecContext(ctx, query, scope, key, until)
+	return err
+}
+
+// Reset forgets all failed attempts for a scope and key, which also lifts any
+// lockout.
+func (r *LockoutRepository) Reset(ctx context.Context, scope LockoutScope, key string) error {
+	defer metrics.ObserveQuery("lockout", "Reset", time.Now())
+	query := `DELETE FROM auth.login_attempts WHERE scope = $1 AND key = $2`
+	result, err := r.db.ExecContext(ctx, query, scope, key)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockoutNotFound
+	}
+	return nil
+}
+
+// ListLocked returns every scope and key that is currently locked.
+func (r *LockoutRepository) ListLocked(ctx context.Context) ([]*Lockout, error) {
+	defer metrics.ObserveQuery("lockout", "ListLocked", time.Now())
+	query := `
+	SELECT scope, key, failures, last_failure_at, locked_until
+	FROM auth.login_attempts
+	WHERE locked_until > now()
+	ORDER BY locked_until DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []*Lockout{}
+	for rows.Next() {
+		var l Lockout
+		if err := rows.Scan(&l.Scope, &l.Key, &l.Failures, &l.LastFailureAt, &l.LockedUntil); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, &l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return lockouts, nil
+}
+
+// Prune removes attempts that are neither locked nor recent enough to count
+// towards a lockout, and returns the number of removed rows.
+func (r *LockoutRepository) Prune(ctx context.Context, resetAfter time.Duration) (int64, error) {
+	defer metrics.ObserveQuery("lockout", "Prune", time.Now())
+	query := `
+	DELETE FROM auth.login_attempts
+	WHERE last_failure_at < now() - make_interval(secs => $1)
+	AND (locked_until IS NULL OR locked_until <= now())
+	`
+	if r.dialect == db.SQLite {
+		query = `
+		DELETE FROM auth.login_attempts
+		WHERE last_failure_at < strftime('%Y-%m-%d %H:%M:%f+00:00', 'now', -$1 || ' seconds')
+		AND (locked_until IS NULL OR locked_until <= now())
+		`
+	}
+	result, err := r.db.ExecContext(ctx, query, resetAfter.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
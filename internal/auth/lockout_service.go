@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+)
+
+var (
+	// ErrLocked is returned when a login is attempted for an account or
+	// from an IP that is temporarily locked out.
+	ErrLocked = errors.New("too many failed login attempts")
+	// ErrInvalidLockoutScope is returned when unlocking an unknown scope.
+	ErrInvalidLockoutScope = errors.New("invalid lockout scope")
+)
+
+// LockedError is returned when a login is attempted while locked out.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrLocked, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockedError) Is(target error) bool { return target == ErrLocked }
+
+// LockoutPolicy decides when failed login attempts lead to a lockout.
+type LockoutPolicy struct {
+	// AccountThreshold is the number of consecutive failures for an account
+	// before it is locked.
+	AccountThreshold int
+	// IPThreshold is the number of consecutive failures from an IP before
+	// it is locked.
+	IPThreshold int
+	// BaseDelay is how long the first lockout lasts. Every failure after
+	// the threshold doubles it.
+	BaseDelay time.Duration
+	// MaxDelay caps how long a single lockout lasts.
+	MaxDelay time.Duration
+	// ResetAfter is how long after the last failure the count restarts.
+	ResetAfter time.Duration
+}
+
+// delay returns how long to lock out after the given number of failures, or
+// zero if the threshold has not been reached.
+func (p LockoutPolicy) delay(failures int, threshold int) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// LockoutService tracks failed login attempts per account and per IP, and
+// temporarily locks them out with exponential backoff.
+type LockoutService struct {
+	repo   *LockoutRepository
+	audit  *AuditService
+	policy LockoutPolicy
+}
+
+// NewLockoutService creates a new LockoutService.
+func NewLockoutService(repo *LockoutRepository, audit *AuditService, policy LockoutPolicy) *LockoutService {
+	return &LockoutService{repo: repo, audit: audit, policy: policy}
+}
+
+// normalizeAccount makes the account key independent of case and
+// surrounding whitespace, so variations of a login count as one account.
+func normalizeAccount(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+// LoginAttempt is a login attempt counted against an IP and an account,
+// until it is known whether it failed.
+type LoginAttempt struct {
+	account string
+	ip      string
+	// counted are the scopes the attempt was counted against, with the
+	// number of consecutive attempts including it.
+	counted []countedAttempt
+}
+
+type countedAttempt struct {
+	scope     LockoutScope
+	key       string
+	threshold int
+	failures  int
+}
+
+// Attempt counts a login attempt against the IP and the account, and returns
+// a LockedError if either is locked out. Attempts are counted before the
+// password is checked, so concurrent guesses cannot all get past the check
+// before any of them fails; the attempts over the threshold are refused
+// while the earlier ones are still being checked.
+//
+// The IP is counted first, so attempts from a locked out IP do not count
+// against the account.
+func (svc *LockoutService) Attempt(ctx context.Context, login string, ip string) (*LoginAttempt, error) {
+	attempt := &LoginAttempt{account: normalizeAccount(login), ip: ip}
+	scopes := []countedAttempt{
+		{scope: LockoutScopeIP, key: ip, threshold: svc.policy.IPThreshold},
+		{scope: LockoutScopeAccount, key: attempt.account, threshold: svc.policy.AccountThreshold},
+	}
+	for _, c := range scopes {
+		failures, err := svc.repo.RecordAttempt(ctx, c.scope, c.key, c.threshold, svc.policy.ResetAfter)
+		if err != nil {
+			if errors.Is(err, ErrLocked) {
+				err = svc.lockedError(ctx, c.scope, c.key)
+			}
+			return nil, errors.Join(err, svc.Release(ctx, attempt))
+		}
+		c.failures = failures
+		attempt.counted = append(attempt.counted, c)
+	}
+	return attempt, nil
+}
+
+// lockedError returns the LockedError of a refused attempt. Attempts may be
+// refused before the scope is locked, while the attempts that used up the
+// threshold are still being checked.
+func (svc *LockoutService) lockedError(ctx context.Context, scope LockoutScope, key string) error {
+	lockedUntil, err := svc.repo.LockedUntil(ctx, scope, key)
+	if err != nil {
+		return err
+	}
+	if lockedUntil == nil {
+		return &LockedError{RetryAfter: svc.policy.BaseDelay}
+	}
+	return &LockedError{RetryAfter: time.Until(*lockedUntil)}
+}
+
+// RecordFailure keeps a failed attempt counted, and locks out the account or
+// the IP if it has reached its threshold.
+func (svc *LockoutService) RecordFailure(ctx context.Context, attempt *LoginAttempt) error {
+	logger := logging.LoggerFromContext(ctx)
+
+	for _, c := range attempt.counted {
+		delay := svc.policy.delay(c.failures, c.threshold)
+		if delay == 0 {
+			continue
+		}
+		logger.Warn(
+			"locking out after failed login attempts",
+			slog.String("scope", string(c.scope)),
+			slog.Int("failures", c.failures),
+			slog.Duration("delay", delay),
+		)
+		if err := svc.repo.Lock(ctx, c.scope, c.key, time.Now().Add(delay)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordSuccess forgets the failed attempts of an account after a successful
+// login. Earlier failures from the IP are kept, so one valid account cannot be
+// used to reset the IP limit while spraying passwords against others.
+func (svc *LockoutService) RecordSuccess(ctx context.Context, attempt *LoginAttempt) error {
+	err := svc.repo.Reset(ctx, LockoutScopeAccount, attempt.account)
+	if err != nil && !errors.Is(err, ErrLockoutNotFound) {
+		return err
+	}
+	return svc.repo.Release(ctx, LockoutScopeIP, attempt.ip)
+}
+
+// Release takes back an attempt that could not be checked, e.g. as the
+// database failed, so it does not count as a failure.
+func (svc *LockoutService) Release(ctx context.Context, attempt *LoginAttempt) error {
+	var errs []error
+	for _, c := range attempt.counted {
+		errs = append(errs, svc.repo.Release(ctx, c.scope, c.key))
+	}
+	attempt.counted = nil
+	return errors.Join(errs...)
+}
+
+// ListLockouts returns every account and IP that is currently locked out.
+func (svc *LockoutService) ListLockouts(ctx context.Context) ([]*Lockout, error) {
+	return svc.repo.ListLocked(ctx)
+}
+
+// Unlock lifts a lockout and forgets its failed attempts.
+func (svc *LockoutService) Unlock(ctx context.Context, scope LockoutScope, key string) error {
+	switch scope {
+	case LockoutScopeAccount:
+		key = normalizeAccount(key)
+	case LockoutScopeIP:
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidLockoutScope, scope)
+	}
+	if err := svc.repo.Reset(ctx, scope, key); err != nil {
+		return err
+	}
+
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditLockoutUnlock,
+		TargetType: "lockout",
+		TargetID:   string(scope) + "/" + key,
+	})
+	return nil
+}
+
+// Prune removes failed attempts that no longer count towards a lockout.
+func (svc *LockoutService) Prune(ctx context.Context) (int64, error) {
+	return svc.repo.Prune(ctx, svc.policy.ResetAfter)
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures  int
+		threshold int
+		want      time.Duration
+	}{
+		{failures: 2, threshold: 3, want: 0},
+		{failures: 3, threshold: 3, want: time.Minute},
+		{failures: 4, threshold: 3, want: 2 * time.Minute},
+		{failures: 8, threshold: 3, want: 32 * time.Minute},
+		{failures: 9, threshold: 3, want: time.Hour},
+		{failures: 1000, threshold: 3, want: time.Hour},
+		{failures: 1000, threshold: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := testLockoutPolicy.delay(tt.failures, tt.threshold); got != tt.want {
+			t.Errorf("delay(%d, %d) = %v, want %v", tt.failures, tt.threshold, got, tt.want)
+		}
+	}
+}
+
+// retryAfter returns how long a login is locked out, or zero if it is not.
+// The attempt is released again, so it does not count.
+func retryAfter(t *testing.T, svc *LockoutService, login, ip string) time.Duration {
+	t.Helper()
+	attempt, err := svc.Attempt(context.Background(), login, ip)
+	var locked *LockedError
+	switch {
+	case err == nil:
+		if err := svc.Release(context.Background(), attempt); err != nil {
+			t.Fatal(err)
+		}
+		return 0
+	case errors.As(err, &locked):
+		return locked.RetryAfter
+	default:
+		t.Fatal(err)
+		return 0
+	}
+}
+
+func TestLockoutService(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestServices(t).lockouts
+	attempt := func(login, ip string) *LoginAttempt {
+		t.Helper()
+		a, err := svc.Attempt(ctx, login, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	fail := func(login, ip string) {
+		t.Helper()
+		if err := svc.RecordFailure(ctx, attempt(login, ip)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	succeed := func(login, ip string) {
+		t.Helper()
+		if err := svc.RecordSuccess(ctx, attempt(login, ip)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	near := func(got, want time.Duration) bool {
+		return got > want-5*time.Second && got <= want
+	}
+
+	// Failures count against the account however the login is written.
+	fail("ada", "10.0.0.1")
+	fail(" Ada", "10.0.0.2")
+	if got := retryAfter(t, svc, "ada", "10.0.0.9"); got != 0 {
+		t.Fatalf("locked out for %v before the threshold", got)
+	}
+	fail("ADA ", "10.0.0.3")
+	if got := retryAfter(t, svc, "ada", "10.0.0.9"); !near(got, time.Minute) {
+		t.Fatalf("got %v, want the account locked out for a minute", got)
+	}
+	if _, err := svc.Attempt(ctx, "ada", "10.0.0.9"); !errors.Is(err, ErrLocked) {
+		t.Error("got a lockout error not matching ErrLocked")
+	}
+
+	// Each failure after the threshold doubles the delay, once the lockout
+	// has passed.
+	if _, err := svc.repo.db.ExecContext(ctx, `UPDATE auth.login_attempts SET locked_until = last_failure_at`); err != nil {
+		t.Fatal(err)
+	}
+	fail("ada", "10.0.0.4")
+	if got := retryAfter(t, svc, "ada", "10.0.0.9"); !near(got, 2*time.Minute) {
+		t.Fatalf("got %v, want the lockout doubled", got)
+	}
+
+	// An unlock lifts the lockout, and a successful login forgets the
+	// failures of the account.
+	if err := svc.Unlock(ctx, LockoutScopeAccount, "ada"); err != nil {
+		t.Fatal(err)
+	}
+	fail("ada", "10.0.0.1")
+	fail("ada", "10.0.0.2")
+	succeed("Ada", "10.0.0.3")
+	fail("ada", "10.0.0.1")
+	fail("ada", "10.0.0.2")
+	if got := retryAfter(t, svc, "ada", "10.0.0.9"); got != 0 {
+		t.Errorf("got %v after a successful login, want no lockout", got)
+	}
+	succeed("unknown", "10.0.0.1")
+
+	// Failures from an IP count across accounts, and a successful login
+	// does not reset them, nor count as a failure.
+	for _, login := range []string{"a", "b", "c", "d"} {
+		fail(login, "10.0.0.5")
+	}
+	succeed("e", "10.0.0.5")
+	if got := retryAfter(t, svc, "f", "10.0.0.5"); got != 0 {
+		t.Fatalf("got %v, want a successful login not to count", got)
+	}
+	fail("e", "10.0.0.5")
+	if got := retryAfter(t, svc, "f", "10.0.0.5"); !near(got, time.Minute) {
+		t.Fatalf("got %v, want the IP locked out", got)
+	}
+	// Attempts from a locked out IP do not count against the account.
+	if got := retryAfter(t, svc, "g", "10.0.0.5"); got == 0 {
+		t.Fatal("got no lockout from the locked out IP")
+	}
+	if got := retryAfter(t, svc, "g", "10.0.0.6"); got != 0 {
+		t.Fatalf("got %v, want the account not locked out", got)
+	}
+
+	lockouts, err := svc.ListLockouts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Scope != LockoutScopeIP || lockouts[0].Key != "10.0.0.5" || lockouts[0].Failures != 5 {
+		t.Fatalf("got lockouts %+v, want the IP", lockouts)
+	}
+
+	if err := svc.Unlock(ctx, LockoutScopeIP, "10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if got := retryAfter(t, svc, "f", "10.0.0.5"); got != 0 {
+		t.Errorf("got %v after unlocking, want no lockout", got)
+	}
+	if err := svc.Unlock(ctx, "user", "ada"); !errors.Is(err, ErrInvalidLockoutScope) {
+		t.Errorf("got %v unlocking an unknown scope, want %v", err, ErrInvalidLockoutScope)
+	}
+}
+
+func TestLockoutConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestServices(t).lockouts
+
+	// Guesses against one account, all in flight at once, only get as many
+	// attempts as the threshold allows.
+	const guesses = 10
+	var wg sync.WaitGroup
+	errs := make([]error, guesses)
+	for i := range guesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = svc.Attempt(ctx, "ada", fmt.Sprintf("10.0.1.%d", i))
+		}()
+	}
+	wg.Wait()
+
+	allowed := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			allowed++
+		case !errors.Is(err, ErrLocked):
+			t.Fatal(err)
+		}
+	}
+	if allowed != testLockoutPolicy.AccountThreshold {
+		t.Errorf("got %d attempts allowed, want %d", allowed, testLockoutPolicy.AccountThreshold)
+	}
+}
+
+func TestLoginAndSessions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	m := &Middleware{Tokens: s.tokens, Sessions: s.sessions}
+	userID := s.createUser(t, "ada")
+	login := func(password string) (string, error) {
+		t.Helper()
+		_, _, raw, err := s.sessions.Login(ctx, &Login{Login: "ada", Password: password}, "10.0.0.1", "test")
+		return raw, err
+	}
+
+	for range testLockoutPolicy.AccountThreshold {
+		if _, err := login("wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("got %v, want %v", err, ErrInvalidCredentials)
+		}
+	}
+	// Once locked out, even the right password is refused.
+	if _, err := login("correct horse battery staple"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v, want %v", err, ErrLocked)
+	}
+
+	if err := s.lockouts.Unlock(ctx, LockoutScopeAccount, "ada"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := login("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookie := &http.Cookie{Name: SessionCookieName, Value: raw}
+	w, principal := authenticate(m, http.MethodGet, "/dashboard", "", cookie)
+	if w.Code != http.StatusOK || principal == nil || principal.UserID != userID || principal.SessionID == nil {
+		t.Fatalf("got status %d and principal %+v, want the session of user %d", w.Code, principal, userID)
+	}
+
+	// An ended session is cleared, and the request passed on as anonymous.
+	if err := s.sessions.Logout(ctx, raw); err != nil {
+		t.Fatal(err)
+	}
+	w, principal = authenticate(m, http.MethodGet, "/dashboard", "", cookie)
+	if w.Code != http.StatusOK || principal != nil {
+		t.Fatalf("got status %d and principal %+v, want an anonymous request", w.Code, principal)
+	}
+	cleared := w.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Name != SessionCookieName || cleared[0].MaxAge >= 0 {
+		t.Errorf("got cookies %v, want the session cookie cleared", cleared)
+	}
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+var (
+	usersRegistered = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "auth",
+		Name:      "users_registered_total",
+		Help:      "Number of users registered.",
+	})
+	// logins counts logins by method, "password" or the name of the OIDC
+	// provider, and by result.
+	logins = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "auth",
+		Name:      "logins_total",
+		Help:      "Number of login attempts by method and result.",
+	}, []string{"method", "result"})
+)
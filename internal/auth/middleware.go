@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+// Authenticator resolves the caller of a request and embeds it in the
+// request context.
+type Authenticator interface {
+	Authenticate(next http.Handler) http.Handler
+}
+
+// Middleware authenticates requests on behalf of the monolith.
+type Middleware struct {
+	Tokens   *TokenService
+	Sessions *SessionService
+	// MetricsPath is the path of the metrics when served on the port of the
+	// application, which tokens with ScopeMetricsRead may read. It is empty
+	// if they are served on a separate port.
+	MetricsPath string
+}
+
+// Authenticate resolves the principal of a request from its credentials. A
+// personal access token is accepted as a bearer token in the Authorization
+// header, and is only allowed to reach API routes covered by its scopes, and
+// the metrics.
+// Otherwise, the session cookie set on login is used.
+//
+// Requests without credentials are passed through as anonymous.
+func (m *Middleware) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		raw, ok := bearerToken(r)
+		if !ok {
+			m.authenticateSession(w, r, next)
+			return
+		}
+
+		principal, err := m.Tokens.Authenticate(ctx, raw)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrTokenNotFound):
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				rest.UnauthorizedResponse(w, r, err)
+			default:
+				rest.InternalServerErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		scope, ok := m.requiredScope(r)
+		if !ok || !principal.HasScope(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			rest.ForbiddenResponse(w, r, fmt.Errorf("token lacks scope %q", scope))
+			return
+		}
+
+		logger := logging.LoggerFromContext(ctx).With(
+			slog.Group(
+				"principal",
+				slog.Int("user_id", principal.UserID),
+				slog.Int("token_id", *principal.TokenID),
+			),
+		)
+		ctx = logging.WithLogger(ctx, logger)
+		ctx = WithPrincipal(ctx, principal)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateSession resolves the principal from the session cookie. An
+// unknown or expired session is cleared and the request is passed through as
+// anonymous, so the caller can log in again.
+func (m *Middleware) authenticateSession(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	ctx := r.Context()
+
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil || cookie.Value == "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	principal, err := m.Sessions.Authenticate(ctx, cookie.Value)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrSessionNotFound):
+			http.SetCookie(w, &http.Cookie{Name: SessionCookieName, Path: "/", MaxAge: -1})
+			next.ServeHTTP(w, r)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	logger := logging.LoggerFromContext(ctx).With(
+		slog.Group(
+			"principal",
+			slog.Int("user_id", principal.UserID),
+			slog.Int("session_id", *principal.SessionID),
+		),
+	)
+	ctx = logging.WithLogger(ctx, logger)
+	ctx = WithPrincipal(ctx, principal)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Audit embeds the details of a request that are recorded in the audit log
+// in the request context.
+func Audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithAuditRequest(r.Context(), &AuditRequest{
+			IP:        rest.ClientIP(r),
+			UserAgent: r.UserAgent(),
+			RequestID: rest.RequestIDFromContext(r.Context()),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireAdmin only lets requests from admins through.
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			rest.UnauthorizedResponse(w, r, errors.New("admin route requires authentication"))
+			return
+		}
+		if !principal.IsAdmin() {
+			rest.ForbiddenResponse(w, r, fmt.Errorf("user %d is not an admin", principal.UserID))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// bearerToken extracts a bearer token from the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// requiredScope derives the scope needed to call an API route. API routes are
+// namespaced by module, i.e. /api/{version}/{module}/..., and safe methods
+// only require read access. Routes outside the API cannot be reached with a
+// token, except for reading the metrics, so scrapers can authenticate.
+func (m *Middleware) requiredScope(r *http.Request) (Scope, bool) {
+	if m.MetricsPath != "" && r.URL.Path == m.MetricsPath {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return "", false
+		}
+		return ScopeMetricsRead, true
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "api" || parts[2] == "" {
+		return "", false
+	}
+
+	access := "write"
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		access = "read"
+	}
+	return Scope(parts[2] + ":" + access), true
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   Scope
+		wantOK bool
+	}{
+		{method: http.MethodGet, path: "/api/v0/workout/plans", want: ScopeWorkoutRead, wantOK: true},
+		{method: http.MethodHead, path: "/api/v0/workout/plans", want: ScopeWorkoutRead, wantOK: true},
+		{method: http.MethodPost, path: "/api/v0/workout/plans", want: ScopeWorkoutWrite, wantOK: true},
+		{method: http.MethodDelete, path: "/api/v0/auth/users/2", want: ScopeAuthWrite, wantOK: true},
+		{method: http.MethodPatch, path: "/api/v1/auth/users/2", want: ScopeAuthWrite, wantOK: true},
+		{method: http.MethodGet, path: "/api/v0/billing", want: "billing:read", wantOK: true},
+		{method: http.MethodGet, path: "/api/v0/", wantOK: false},
+		{method: http.MethodGet, path: "/api/v0", wantOK: false},
+		{method: http.MethodGet, path: "/dashboard", wantOK: false},
+		{method: http.MethodPost, path: "/plans/new", wantOK: false},
+		{method: http.MethodGet, path: "/metrics", want: ScopeMetricsRead, wantOK: true},
+		{method: http.MethodPost, path: "/metrics", wantOK: false},
+	}
+	m := &Middleware{MetricsPath: "/metrics"}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			got, ok := m.requiredScope(httptest.NewRequest(tt.method, tt.path, nil))
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// authenticate sends a request with the given Authorization header and
+// cookies through the middleware, and returns the response and the
+// principal the handler saw.
+func authenticate(m *Middleware, method, target, authorization string, cookies ...*http.Cookie) (*httptest.ResponseRecorder, *Principal) {
+	var principal *Principal
+	h := m.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(method, target, nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w, principal
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	s := newTestServices(t)
+	m := &Middleware{Tokens: s.tokens, Sessions: s.sessions}
+	userID := s.createUser(t, "ada")
+
+	created, err := s.tokens.CreateToken(context.Background(), userID, &CreatePersonalAccessToken{
+		Name:   "ci",
+		Scopes: []Scope{ScopeWorkoutRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer := "Bearer " + created.Token
+
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		authorization string
+		wantStatus    int
+		wantAuth      string
+		wantPrincipal bool
+	}{
+		{name: "scope granted", method: http.MethodGet, target: "/api/v0/workout/plans", authorization: bearer, wantStatus: http.StatusOK, wantPrincipal: true},
+		{name: "scheme is case insensitive", method: http.MethodGet, target: "/api/v0/workout/plans", authorization: "bearer " + created.Token, wantStatus: http.StatusOK, wantPrincipal: true},
+		{name: "write without the scope", method: http.MethodPost, target: "/api/v0/workout/plans", authorization: bearer, wantStatus: http.StatusForbidden, wantAuth: `Bearer error="insufficient_scope", scope="workout:write"`},
+		{name: "other module", method: http.MethodGet, target: "/api/v0/auth/users", authorization: bearer, wantStatus: http.StatusForbidden, wantAuth: `Bearer error="insufficient_scope", scope="auth:read"`},
+		{name: "pages", method: http.MethodGet, target: "/dashboard", authorization: bearer, wantStatus: http.StatusForbidden},
+		{name: "unknown token", method: http.MethodGet, target: "/api/v0/workout/plans", authorization: "Bearer " + TokenPrefix + "unknown", wantStatus: http.StatusUnauthorized, wantAuth: `Bearer error="invalid_token"`},
+		{name: "other scheme", method: http.MethodGet, target: "/api/v0/workout/plans", authorization: "Basic YWRhOnB3", wantStatus: http.StatusOK},
+		{name: "no credentials", method: http.MethodGet, target: "/api/v0/workout/plans", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, principal := authenticate(m, tt.method, tt.target, tt.authorization)
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantAuth != "" && w.Header().Get("WWW-Authenticate") != tt.wantAuth {
+				t.Errorf("got WWW-Authenticate %q, want %q", w.Header().Get("WWW-Authenticate"), tt.wantAuth)
+			}
+			if (principal != nil) != tt.wantPrincipal {
+				t.Fatalf("got principal %+v, want one: %v", principal, tt.wantPrincipal)
+			}
+			if principal != nil && (principal.UserID != userID || principal.TokenID == nil || *principal.TokenID != created.ID) {
+				t.Errorf("got principal %+v, want user %d with token %d", principal, userID, created.ID)
+			}
+		})
+	}
+
+	// Revoked tokens are refused.
+	if _, err := s.tokens.RevokeToken(context.Background(), userID, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if w, _ := authenticate(m, http.MethodGet, "/api/v0/workout/plans", bearer); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d for a revoked token, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAuthenticateMetricsToken(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	m := &Middleware{Tokens: s.tokens, Sessions: s.sessions, MetricsPath: "/metrics"}
+	userID := s.createUser(t, "ada")
+	tokens := 0
+	bearer := func(userID int, scope Scope) string {
+		t.Helper()
+		tokens++
+		name := fmt.Sprintf("scraper %d", tokens)
+		created, err := s.tokens.CreateToken(ctx, userID, &CreatePersonalAccessToken{Name: name, Scopes: []Scope{scope}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + created.Token
+	}
+	metrics := RequireAdmin(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name          string
+		m             *Middleware
+		authorization string
+		want          int
+	}{
+		{name: "admin with the scope", m: m, authorization: bearer(adminID, ScopeMetricsRead), want: http.StatusOK},
+		{name: "admin without the scope", m: m, authorization: bearer(adminID, ScopeAuthRead), want: http.StatusForbidden},
+		{name: "user with the scope", m: m, authorization: bearer(userID, ScopeMetricsRead), want: http.StatusForbidden},
+		{
+			name:          "metrics on a separate port",
+			m:             &Middleware{Tokens: s.tokens, Sessions: s.sessions},
+			authorization: bearer(adminID, ScopeMetricsRead),
+			want:          http.StatusForbidden,
+		},
+		{name: "no credentials", m: m, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			tt.m.Authenticate(metrics).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	h := RequireAdmin(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name      string
+		principal *Principal
+		want      int
+	}{
+		{name: "anonymous", principal: anonymous, want: http.StatusUnauthorized},
+		{name: "user", principal: userPrincipal(2), want: http.StatusForbidden},
+		{name: "admin", principal: admin, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v0/auth/audit", nil)
+			if tt.principal != nil {
+				r = r.WithContext(WithPrincipal(r.Context(), tt.principal))
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+const (
+	// OIDCStateCookieName is the name of the cookie keeping the state of an
+	// authorization code flow between the login redirect and the callback.
+	OIDCStateCookieName = "smartsplit_oidc"
+	oidcStateCookiePath = "/api/v0/auth/oidc/"
+	// oidcStateMaxAge is how long the user has to authenticate with the
+	// provider, in seconds.
+	oidcStateMaxAge = 600
+)
+
+var (
+	// ErrInvalidOIDCState is returned when the callback does not match the
+	// login it was started from.
+	ErrInvalidOIDCState = errors.New("invalid or missing login state")
+)
+
+// OIDCHandler defines HTTP handlers for logins with OpenID Connect providers.
+type OIDCHandler struct {
+	Service *OIDCService
+	// SecureCookie marks the cookies as only to be sent over HTTPS.
+	SecureCookie bool
+	// RedirectAfterLogin is where the user is sent after a successful login.
+	RedirectAfterLogin string
+}
+
+// RegisterRoutes hooks up endpoints.
+func (h *OIDCHandler) RegisterRoutes(ctx context.Context, mux *http.ServeMux) {
+	logger := logging.LoggerFromContext(ctx)
+
+	routeDefinitions := rest.RouteDefinitionList{
+		{
+			Path:    "GET /api/v0/auth/oidc/providers",
+			Handler: h.listProvidersHandler,
+		},
+		{
+			Path:    "GET /api/v0/auth/oidc/{provider}/login",
+			Handler: h.loginHandler,
+			Class:   rest.RateClassAuth,
+		},
+		{
+			Path:    "GET /api/v0/auth/oidc/{provider}/callback",
+			Handler: h.callbackHandler,
+			Class:   rest.RateClassAuth,
+		},
+	}
+
+	for _, d := range routeDefinitions {
+		logger.Info("adding route", "route", d.Path)
+		mux.Handle(d.Path, d)
+	}
+}
+
+func (h *OIDCHandler) listProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	err := rest.WriteJSONResponse(w, http.StatusOK, h.Service.Providers())
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *OIDCHandler) loginHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	provider := r.PathValue("provider")
+	logger = logger.With(slog.Group("input", slog.String("provider", provider)))
+
+	logger.Info("starting login")
+	req, url, err := h.Service.StartLogin(ctx, provider)
+	if err != nil {
+		logger.Error("failed to start login", "error", err)
+		switch {
+		case errors.Is(err, ErrUnknownProvider):
+			rest.NotFoundResponse(w, r, err)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(b),
+		Path:     oidcStateCookiePath,
+		MaxAge:   oidcStateMaxAge,
+		HttpOnly: true,
+		Secure:   h.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// authRequestFromCookie reads the state of the login in progress, and
+// checks that the callback belongs to it.
+func authRequestFromCookie(r *http.Request) (*OIDCAuthRequest, error) {
+	cookie, err := r.Cookie(OIDCStateCookieName)
+	if err != nil {
+		return nil, ErrInvalidOIDCState
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, ErrInvalidOIDCState
+	}
+	var req OIDCAuthRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return nil, ErrInvalidOIDCState
+	}
+
+	state := r.URL.Query().Get("state")
+	if req.Provider != r.PathValue("provider") || req.State == "" ||
+		subtle.ConstantTimeCompare([]byte(req.State), []byte(state)) != 1 {
+		return nil, ErrInvalidOIDCState
+	}
+	return &req, nil
+}
+
+func (h *OIDCHandler) callbackHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	provider := r.PathValue("provider")
+	logger = logger.With(slog.Group("input", slog.String("provider", provider)))
+
+	// The state is only good for a single attempt.
+	http.SetCookie(w, &http.Cookie{Name: OIDCStateCookieName, Path: oidcStateCookiePath, MaxAge: -1})
+
+	req, err := authRequestFromCookie(r)
+	if err != nil {
+		rest.BadRequestResponse(w, r, err.Error(), err)
+		return
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		err := fmt.Errorf("provider returned error %q: %s", e, query.Get("error_description"))
+		rest.UnauthorizedResponse(w, r, err)
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		rest.BadRequestResponse(w, r, "missing authorization code", errors.New("missing authorization code"))
+		return
+	}
+
+	logger.Info("finishing login")
+	raw, session, err := h.Service.FinishLogin(ctx, req, code, rest.ClientIP(r), r.UserAgent())
+	if err != nil {
+		logger.Warn("failed to finish login", "error", err)
+		switch {
+		case errors.Is(err, ErrUnknownProvider):
+			rest.NotFoundResponse(w, r, err)
+		case errors.Is(err, ErrInvalidIDToken):
+			rest.UnauthorizedResponse(w, r, err)
+		case errors.Is(err, ErrEmailNotVerified), errors.Is(err, ErrNoLinkedUser):
+			rest.ErrorResponse(w, r, http.StatusForbidden, err.Error())
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	setSessionCookie(w, raw, session, h.SecureCookie)
+
+	target := h.RedirectAfterLogin
+	if target == "" {
+		target = "/"
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
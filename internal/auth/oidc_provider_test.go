@@ -0,0 +1,462 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+)
+
+const testClientID = "smartsplit"
+
+// fakeProvider is an OpenID Connect provider serving discovery, a key set
+// and a token endpoint, which signs ID tokens with its keys.
+type fakeProvider struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu sync.Mutex
+	// keys are the published signing keys by ID.
+	keys        map[string]crypto.Signer
+	jwksFetches int
+	// codes are the authorization requests by the code issued for them.
+	codes map[string]url.Values
+	// claims are added to the ID tokens issued by the token endpoint.
+	claims map[string]any
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	p := &fakeProvider{
+		t:     t,
+		keys:  map[string]crypto.Signer{"rsa": newRSAKey(t), "ec": newECKey(t)},
+		codes: map[string]url.Values{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                p.srv.URL,
+			AuthorizationEndpoint: p.srv.URL + "/authorize",
+			TokenEndpoint:         p.srv.URL + "/token",
+			JWKSURI:               p.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", p.jwksHandler)
+	mux.HandleFunc("POST /token", p.tokenHandler)
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// provider returns a client of the fake provider.
+func (p *fakeProvider) provider() *OIDCProvider {
+	return NewOIDCProvider(OIDCSettings{
+		Name:        "fake",
+		Issuer:      p.srv.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://smartsplit.example.com/callback",
+	})
+}
+
+// rotate replaces the published keys.
+func (p *fakeProvider) rotate(keys map[string]crypto.Signer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+}
+
+func (p *fakeProvider) fetches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jwksFetches
+}
+
+func (p *fakeProvider) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jwksFetches++
+
+	enc := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	for kid, key := range p.keys {
+		switch k := key.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jsonWebKey{
+				Kty: "RSA", Kid: kid, Use: "sig",
+				N: enc(k.N.Bytes()), E: enc(big.NewInt(int64(k.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jsonWebKey{
+				Kty: "EC", Kid: kid, Use: "sig", Crv: "P-256",
+				X: enc(k.X.FillBytes(make([]byte, 32))), Y: enc(k.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+// authorize stands in for the user authenticating with the provider, and
+// returns the code the provider redirects back with.
+func (p *fakeProvider) authorize(authURL string) (code string, state string) {
+	p.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	params := u.Query()
+	if params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "" {
+		p.t.Fatalf("authorization request without an S256 code challenge: %s", authURL)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code = "code-" + params.Get("state")
+	p.codes[code] = params
+	return code, params.Get("state")
+}
+
+// tokenHandler exchanges a code for an ID token, checking the code verifier
+// against the challenge of the authorization request.
+func (p *fakeProvider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	params, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || params.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := p.validClaims(params.Get("nonce"))
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign("rsa", "RS256", claims)})
+}
+
+// validClaims returns the claims of a valid ID token with the given nonce.
+func (p *fakeProvider) validClaims(nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":   p.srv.URL,
+		"sub":   "subject",
+		"aud":   testClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+}
+
+// sign returns a token with the claims, signed with the given key. The
+// algorithm is only put in the header, and does not change how it is signed.
+func (p *fakeProvider) sign(kid string, alg string, claims map[string]any) string {
+	p.t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	p.mu.Lock()
+	key := p.keys[kid]
+	p.mu.Unlock()
+
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			p.t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		p.t.Fatalf("no key %q", kid)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCProviderVerify(t *testing.T) {
+	p := newFakeProvider(t)
+	provider := p.provider()
+	const nonce = "nonce"
+
+	with := func(changes map[string]any) map[string]any {
+		claims := p.validClaims(nonce)
+		for k, v := range changes {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "rsa", token: p.sign("rsa", "RS256", with(nil))},
+		{name: "ec", token: p.sign("ec", "ES256", with(nil))},
+		{
+			name:  "expired within clock skew",
+			token: p.sign("rsa", "RS256", with(map[string]any{"exp": now.Add(-clockSkew / 2).Unix()})),
+		},
+		{
+			name:  "multiple audiences authorized for this client",
+			token: p.sign("rsa", "RS256", with(map[string]any{"aud": []string{testClientID, "other"}, "azp": testClientID})),
+		},
+		{
+			name:    "bad signature",
+			token:   tamper(p.sign("rsa", "RS256", with(nil)), with(map[string]any{"sub": "admin"})),
+			wantErr: "verification error",
+		},
+		{
+			name:    "signed by another key",
+			token:   resign(p.sign("rsa", "RS256", with(nil)), p.sign("ec", "ES256", with(nil))),
+			wantErr: "verification error",
+		},
+		{name: "alg none", token: unsigned("rsa", with(nil)), wantErr: `unsupported algorithm "none"`},
+		{name: "alg hmac", token: p.sign("rsa", "HS256", with(nil)), wantErr: `unsupported algorithm "HS256"`},
+		{name: "alg mismatch", token: p.sign("rsa", "ES256", with(nil)), wantErr: "does not match rsa key"},
+		{name: "wrong issuer", token: p.sign("rsa", "RS256", with(map[string]any{"iss": "https://evil.example.com"})), wantErr: "unexpected issuer"},
+		{name: "wrong audience", token: p.sign("rsa", "RS256", with(map[string]any{"aud": "other"})), wantErr: "not issued for this client"},
+		{
+			name:    "multiple audiences authorized for another party",
+			token:   p.sign("rsa", "RS256", with(map[string]any{"aud": []string{testClientID, "other"}, "azp": "other"})),
+			wantErr: "authorized for another party",
+		},
+		{name: "wrong nonce", token: p.sign("rsa", "RS256", with(map[string]any{"nonce": "replayed"})), wantErr: "nonce mismatch"},
+		{name: "missing nonce", token: p.sign("rsa", "RS256", with(map[string]any{"nonce": nil})), wantErr: "nonce mismatch"},
+		{name: "missing subject", token: p.sign("rsa", "RS256", with(map[string]any{"sub": nil})), wantErr: "missing subject"},
+		{name: "missing expiry", token: p.sign("rsa", "RS256", with(map[string]any{"exp": nil})), wantErr: "expired"},
+		{name: "expired", token: p.sign("rsa", "RS256", with(map[string]any{"exp": now.Add(-2 * clockSkew).Unix()})), wantErr: "expired"},
+		{name: "not valid yet", token: p.sign("rsa", "RS256", with(map[string]any{"nbf": now.Add(2 * clockSkew).Unix()})), wantErr: "not valid yet"},
+		{name: "malformed", token: "header.payload", wantErr: "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := provider.Verify(context.Background(), tt.token, nonce)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if claims.Subject != "subject" {
+					t.Errorf("got subject %q, want %q", claims.Subject, "subject")
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidIDToken) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// tamper replaces the claims of a signed token, keeping its signature.
+func tamper(token string, claims map[string]any) string {
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(claims)
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+}
+
+// resign replaces the signature of a token with the one of another.
+func resign(token string, other string) string {
+	return token[:strings.LastIndex(token, ".")] + other[strings.LastIndex(other, "."):]
+}
+
+// unsigned returns a token with the none algorithm and no signature.
+func unsigned(kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func TestOIDCProviderRefreshesKeys(t *testing.T) {
+	ctx := context.Background()
+	p := newFakeProvider(t)
+	provider := p.provider()
+	const nonce = "nonce"
+
+	if _, err := provider.Verify(ctx, p.sign("rsa", "RS256", p.validClaims(nonce)), nonce); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.fetches(); got != 1 {
+		t.Fatalf("got %d key set fetches, want 1", got)
+	}
+
+	// The token is signed before the new key is published, as the fake
+	// provider signs with its published keys.
+	p.rotate(map[string]crypto.Signer{"rotated": newRSAKey(t)})
+	token := p.sign("rotated", "RS256", p.validClaims(nonce))
+
+	_, err := provider.Verify(ctx, token, nonce)
+	if !errors.Is(err, ErrInvalidIDToken) || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("got error %v, want an unknown signing key", err)
+	}
+	if got := p.fetches(); got != 1 {
+		t.Fatalf("got %d key set fetches, want the key set not to be refetched within %s", got, jwksMinRefresh)
+	}
+
+	provider.mu.Lock()
+	provider.keysFetched = time.Now().Add(-jwksMinRefresh)
+	provider.mu.Unlock()
+
+	if _, err := provider.Verify(ctx, token, nonce); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.fetches(); got != 2 {
+		t.Fatalf("got %d key set fetches, want 2", got)
+	}
+	if _, err := provider.Verify(ctx, p.sign("rotated", "RS256", p.validClaims(nonce)), nonce); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.fetches(); got != 2 {
+		t.Fatalf("got %d key set fetches, want the known key not to refetch", got)
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s := newTestServices(t)
+	p := newFakeProvider(t)
+	s.createUser(t, "oidc")
+	p.claims = map[string]any{"email": "oidc@example.com", "email_verified": true}
+
+	svc := NewOIDCService(NewIdentityRepository(s.db, db.SQLite), s.sessions, s.audit, nil)
+	svc.providers["fake"] = p.provider()
+	svc.names = []string{"fake"}
+	h := &OIDCHandler{Service: svc, RedirectAfterLogin: "/dashboard"}
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(context.Background(), mux)
+
+	// login starts the flow, keeping its state in a cookie
+	login := func(t *testing.T) (*http.Cookie, string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v0/auth/oidc/fake/login", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusFound, w.Body)
+		}
+		cookie := findCookie(w.Result(), OIDCStateCookieName)
+		if cookie == nil {
+			t.Fatal("login did not set the state cookie")
+		}
+		return cookie, w.Header().Get("Location")
+	}
+	callback := func(cookie *http.Cookie, code string, state string) *httptest.ResponseRecorder {
+		target := "/api/v0/auth/oidc/fake/callback?" + url.Values{"code": {code}, "state": {state}}.Encode()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		cookie, authURL := login(t)
+		code, state := p.authorize(authURL)
+
+		w := callback(cookie, code, state)
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "/dashboard" {
+			t.Fatalf("got status %d to %q, want a redirect to /dashboard: %s", w.Code, w.Header().Get("Location"), w.Body)
+		}
+		if findCookie(w.Result(), SessionCookieName) == nil {
+			t.Error("callback did not start a session")
+		}
+	})
+
+	t.Run("wrong state", func(t *testing.T) {
+		cookie, authURL := login(t)
+		code, _ := p.authorize(authURL)
+
+		if w := callback(cookie, code, "forged"); w.Code != http.StatusBadRequest {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("missing state cookie", func(t *testing.T) {
+		_, authURL := login(t)
+		code, state := p.authorize(authURL)
+
+		if w := callback(nil, code, state); w.Code != http.StatusBadRequest {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("state of another login", func(t *testing.T) {
+		// The code is bound to the verifier of the login it was issued
+		// for, so it cannot be redeemed with the state of another.
+		cookie, _ := login(t)
+		_, authURL := login(t)
+		code, _ := p.authorize(authURL)
+
+		var req OIDCAuthRequest
+		b, _ := base64.RawURLEncoding.DecodeString(cookie.Value)
+		if err := json.Unmarshal(b, &req); err != nil {
+			t.Fatal(err)
+		}
+		w := callback(cookie, code, req.State)
+		if w.Code == http.StatusFound || findCookie(w.Result(), SessionCookieName) != nil {
+			t.Fatalf("got status %d, want the code exchange to fail", w.Code)
+		}
+	})
+}
+
+func findCookie(resp *http.Response, name string) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	return nil
+}
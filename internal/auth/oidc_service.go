@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+var (
+	// ErrUnknownProvider is returned for an OpenID Connect provider that is
+	// not configured.
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	// ErrEmailNotVerified is returned when a new external identity cannot be
+	// linked, because the provider has not verified its email.
+	ErrEmailNotVerified = errors.New("email is not verified by the identity provider")
+	// ErrNoLinkedUser is returned when no user has the email of a new
+	// external identity. Users are never created on login.
+	ErrNoLinkedUser = errors.New("no user with a matching email")
+)
+
+// OIDCAuthRequest is the state of an authorization code flow in progress. It
+// is kept by the client between the login redirect and the callback.
+type OIDCAuthRequest struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// OIDCService handles business logic for logins with OpenID Connect
+// providers.
+type OIDCService struct {
+	providers  map[string]*OIDCProvider
+	names      []string
+	identities *IdentityRepository
+	sessions   *SessionService
+	audit      *AuditService
+}
+
+// NewOIDCService creates a new OIDCService.
+func NewOIDCService(
+	identities *IdentityRepository,
+	sessions *SessionService,
+	audit *AuditService,
+	providers []OIDCSettings,
+) *OIDCService {
+	svc := &OIDCService{
+		providers:  make(map[string]*OIDCProvider, len(providers)),
+		identities: identities,
+		sessions:   sessions,
+		audit:      audit,
+	}
+	for _, settings := range providers {
+		svc.providers[settings.Name] = NewOIDCProvider(settings)
+		svc.names = append(svc.names, settings.Name)
+	}
+	slices.Sort(svc.names)
+	return svc
+}
+
+// Providers returns the names of the configured providers.
+func (svc *OIDCService) Providers() []string {
+	return svc.names
+}
+
+// randomString returns a URL safe string of n random bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// StartLogin begins an authorization code flow with the given provider, and
+// returns its state along with the URL to redirect the user to.
+func (svc *OIDCService) StartLogin(ctx context.Context, name string) (*OIDCAuthRequest, string, error) {
+	provider, ok := svc.providers[name]
+	if !ok {
+		return nil, "", ErrUnknownProvider
+	}
+
+	req := &OIDCAuthRequest{Provider: name}
+	for _, s := range []*string{&req.State, &req.Nonce, &req.Verifier} {
+		v, err := randomString(32)
+		if err != nil {
+			return nil, "", err
+		}
+		*s = v
+	}
+
+	url, err := provider.AuthCodeURL(ctx, req.State, req.Nonce, req.Verifier)
+	if err != nil {
+		return nil, "", err
+	}
+	return req, url, nil
+}
+
+// FinishLogin exchanges the authorization code, validates the ID token and
+// starts a session for the user linked to the external identity.
+//
+// An identity seen for the first time is linked to the user with the same
+// email, but only if the provider has verified it.
+func (svc *OIDCService) FinishLogin(
+	ctx context.Context, req *OIDCAuthRequest, code string, ip string, userAgent string,
+) (string, *Session, error) {
+	provider, ok := svc.providers[req.Provider]
+	if !ok {
+		return "", nil, ErrUnknownProvider
+	}
+
+	raw, err := provider.Exchange(ctx, code, req.Verifier)
+	if err != nil {
+		return "", nil, err
+	}
+	claims, err := provider.Verify(ctx, raw, req.Nonce)
+	if err != nil {
+		return "", nil, err
+	}
+
+	userID, err := svc.identities.Touch(ctx, req.Provider, claims.Subject, claims.Email)
+	if err != nil {
+		if !errors.Is(err, ErrIdentityNotFound) {
+			return "", nil, err
+		}
+		if claims.Email == "" || !bool(claims.EmailVerified) {
+			return "", nil, ErrEmailNotVerified
+		}
+		userID, err = svc.identities.Link(ctx, req.Provider, claims.Subject, claims.Email)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrNotFound):
+				return "", nil, fmt.Errorf("%w: %s", ErrNoLinkedUser, claims.Email)
+			}
+			return "", nil, err
+		}
+
+		svc.audit.Record(ctx, AuditEntry{
+			Action:     AuditIdentityLink,
+			TargetType: "user",
+			TargetID:   strconv.Itoa(userID),
+			ActorID:    &userID,
+			Changes: map[string]AuditChange{
+				"provider": {Before: nil, After: req.Provider},
+				"subject":  {Before: nil, After: claims.Subject},
+			},
+		})
+	}
+
+	raw, session, err := svc.sessions.StartSession(ctx, userID, ip, userAgent)
+	if err != nil {
+		return "", nil, err
+	}
+
+	logins.WithLabelValues(req.Provider, "success").Inc()
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditLogin,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(userID),
+		ActorID:    &userID,
+		Changes: map[string]AuditChange{
+			"provider": {Before: nil, After: req.Provider},
+		},
+	})
+	return raw, session, nil
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the minimum number of characters in a password.
+const MinPasswordLength = 8
+
+var (
+	// ErrInvalidPassword is returned when a new password does not meet the
+	// password requirements.
+	ErrInvalidPassword = errors.New("invalid password")
+	// ErrIncorrectPassword is returned when a password does not match the
+	// stored password hash.
+	ErrIncorrectPassword = errors.New("incorrect password")
+)
+
+// HashPassword validates a plain text password and returns its bcrypt hash.
+func HashPassword(password string) (string, error) {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return "", fmt.Errorf("%w: must be at least %d characters", ErrInvalidPassword, MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrPasswordTooLong):
+			return "", fmt.Errorf("%w: must be at most 72 bytes", ErrInvalidPassword)
+		}
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword compares a plain text password with a bcrypt hash.
+func CheckPassword(hash string, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return ErrIncorrectPassword
+		}
+		return err
+	}
+	return nil
+}
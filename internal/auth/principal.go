@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/evenlwanvik/smartsplit/internal/common"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+const PrincipalCtxKey common.ContextKey = "principal"
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID int
+	Role   Role
+	// SessionID is set when the caller authenticated with a session cookie.
+	SessionID *int
+	// TokenID is set when the caller authenticated with a personal access
+	// token, in which case access is limited to the token scopes.
+	TokenID *int
+	Scopes  []Scope
+}
+
+// IsAdmin reports whether the principal has the admin role.
+func (p *Principal) IsAdmin() bool {
+	return p.Role == RoleAdmin
+}
+
+// HasScope reports whether the principal is allowed to act within the given
+// scope. Principals that did not authenticate with a token are not limited
+// by scopes.
+func (p *Principal) HasScope(scope Scope) bool {
+	if p.TokenID == nil {
+		return true
+	}
+	return slices.Contains(p.Scopes, scope)
+}
+
+// WithPrincipal embeds a principal in the given context.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, PrincipalCtxKey, principal)
+}
+
+// PrincipalFromContext attempts to extract an embedded principal from the
+// given context. The second return value is false for anonymous requests.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(PrincipalCtxKey).(*Principal)
+	return principal, ok
+}
+
+// authorizeSelf ensures that the caller is authenticated, and only acts on
+// its own user unless it is an admin. It responds and returns false if not.
+func authorizeSelf(w http.ResponseWriter, r *http.Request, userID int) bool {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		rest.UnauthorizedResponse(w, r, fmt.Errorf("acting on user %d requires authentication", userID))
+		return false
+	}
+	if principal.UserID != userID && !principal.IsAdmin() {
+		rest.ForbiddenResponse(w, r, fmt.Errorf("user %d cannot act on behalf of user %d", principal.UserID, userID))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+// SessionHandler defines HTTP handlers for logins, sessions and lockouts.
+type SessionHandler struct {
+	Service  *SessionService
+	Lockouts *LockoutService
+	// SecureCookie marks the session cookie as only to be sent over HTTPS.
+	SecureCookie bool
+}
+
+// RegisterRoutes hooks up endpoints.
+func (h *SessionHandler) RegisterRoutes(ctx context.Context, mux *http.ServeMux) {
+	logger := logging.LoggerFromContext(ctx)
+
+	routeDefinitions := rest.RouteDefinitionList{
+		{
+			Path:    "POST " + LoginPath,
+			Handler: h.loginHandler,
+			Class:   rest.RateClassAuth,
+		},
+		{
+			Path:    "POST /api/v0/auth/logout",
+			Handler: h.logoutHandler,
+		},
+		{
+			Path:    "GET /api/v0/auth/lockouts",
+			Handler: RequireAdmin(h.listLockoutsHandler),
+		},
+		{
+			Path:    "DELETE /api/v0/auth/lockouts/{scope}/{key}",
+			Handler: RequireAdmin(h.unlockHandler),
+		},
+	}
+
+	for _, d := range routeDefinitions {
+		logger.Info("adding route", "route", d.Path)
+		mux.Handle(d.Path, d)
+	}
+}
+
+// setSessionCookie sets the session cookie on the response.
+func setSessionCookie(w http.ResponseWriter, raw string, session *Session, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    raw,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *SessionHandler) loginHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	logger.Info("decoding request body")
+	var input Login
+	if err := rest.DecodeJSONFromRequest(r, &input); err != nil {
+		rest.BadRequestResponse(w, r, rest.UnableToDecodeRequestBody, err)
+		return
+	}
+	logger = logger.With(slog.Group("input", slog.Any("login", input)))
+
+	logger.Info("logging in")
+	user, session, raw, err := h.Service.Login(ctx, &input, rest.ClientIP(r), r.UserAgent())
+	if err != nil {
+		logger.Warn("failed to log in", "error", err)
+
+		// Whether the account exists or not, the response is the same.
+		var locked *LockedError
+		switch {
+		case errors.As(err, &locked):
+			retryAfter := int(math.Ceil(locked.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			rest.ErrorResponse(w, r, http.StatusTooManyRequests, ErrLocked.Error())
+		case errors.Is(err, ErrInvalidCredentials):
+			rest.ErrorResponse(w, r, http.StatusUnauthorized, ErrInvalidCredentials.Error())
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	setSessionCookie(w, raw, session, h.SecureCookie)
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, user)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *SessionHandler) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	cookie, err := r.Cookie(SessionCookieName)
+	if err == nil && cookie.Value != "" {
+		logger.Info("logging out")
+		if err := h.Service.Logout(ctx, cookie.Value); err != nil {
+			logger.Error("failed to log out", "error", err)
+			rest.InternalServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: SessionCookieName, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *SessionHandler) listLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	logger.Info("reading lockouts")
+	lockouts, err := h.Lockouts.ListLockouts(ctx)
+	if err != nil {
+		logger.Error("failed to read lockouts", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, lockouts)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *SessionHandler) unlockHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	scope := LockoutScope(r.PathValue("scope"))
+	key := r.PathValue("key")
+	logger = logger.With(slog.Group(
+		"input",
+		slog.String("scope", string(scope)),
+		slog.String("key", key),
+	))
+
+	logger.Info("unlocking")
+	err := h.Lockouts.Unlock(ctx, scope, key)
+	if err != nil {
+		logger.Error("failed to unlock", "error", err)
+		switch {
+		case errors.Is(err, ErrInvalidLockoutScope):
+			rest.BadRequestResponse(w, r, err.Error(), err)
+		case errors.Is(err, ErrLockoutNotFound):
+			rest.NotFoundResponse(w, r, err)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"time"
+)
+
+// SessionCookieName is the name of the cookie holding the session token.
+const SessionCookieName = "smartsplit_session"
+
+// LoginPath is the endpoint logging in with a password, which creates a
+// session.
+const LoginPath = "/api/v0/auth/login"
+
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Login holds the credentials of a login attempt, where the login is either
+// a username or an email.
+type Login struct {
+	Login    string `json:"login" log:"sensitive"`
+	Password string `json:"password" log:"sensitive"`
+}
+
+// LockoutScope is what failed login attempts are counted against.
+type LockoutScope string
+
+const (
+	LockoutScopeAccount LockoutScope = "account"
+	LockoutScopeIP      LockoutScope = "ip"
+)
+
+type Lockout struct {
+	Scope         LockoutScope `json:"scope"`
+	Key           string       `json:"key"`
+	Failures      int          `json:"failures"`
+	LastFailureAt time.Time    `json:"last_failure_at"`
+	LockedUntil   *time.Time   `json:"locked_until,omitempty"`
+}
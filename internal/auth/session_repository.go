@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+var (
+	// ErrSessionNotFound is returned when a session does not exist or has
+	// expired.
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionRepository provides access to the sessions store.
+type SessionRepository struct {
+	// db runs the queries rewritten to the dialect.
+	db      db.Querier
+	dialect db.Dialect
+}
+
+// NewSessionRepository creates a new SessionRepository.
+func NewSessionRepository(db *sql.DB, dialect db.Dialect) *SessionRepository {
+	return &SessionRepository{db: dialect.Wrap(db), dialect: dialect}
+}
+
+// Create inserts a new session into the auth.sessions table. Only the hash of
+// the session token is stored.
+func (r *SessionRepository) Create(
+	ctx context.Context,
+	userID int,
+	hash string,
+	ip string,
+	userAgent string,
+	expiresAt time.Time,
+) (*Session, error) {
+	defer metrics.ObserveQuery("session", "Create", time.Now())
+	query := `
+	INSERT INTO auth.sessions (user_id, token_hash, ip, user_agent, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, user_id, ip, user_agent, expires_at, last_seen_at, created_at
+	`
+	var s Session
+	err := r.db.QueryRowContext(ctx, query, userID, hash, ip, userAgent, expiresAt).Scan(
+		&s.ID,
+		&s.UserID,
+		&s.IP,
+		&s.UserAgent,
+		&s.ExpiresAt,
+		&s.LastSeenAt,
+		&s.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Use looks up an unexpired session by its token hash, records that it was
+// seen and returns the principal it authenticates.
+func (r *SessionRepository) Use(ctx context.Context, hash string) (*Principal, error) {
+	defer metrics.ObserveQuery("session", "Use", time.Now())
+	query := `
+	WITH used AS (
+		UPDATE auth.sessions
+		SET last_seen_at = now()
+		WHERE token_hash = $1
+		AND expires_at > now()
+		RETURNING id, user_id
+	)
+	SELECT used.id, used.user_id, u.role
+	FROM used
+	JOIN auth.users u ON u.id = used.user_id
+	WHERE u.disabled_at IS NULL
+	`
+	// SQLite cannot update within a WITH clause, so the role is looked up
+	// by the RETURNING clause instead.
+	if r.dialect == db.SQLite {
+		query = `
+		UPDATE auth.sessions
+		SET last_seen_at = now()
+		WHERE token_hash = $1
+		AND expires_at > now()
+		AND user_id IN (SELECT id FROM auth.users WHERE disabled_at IS NULL)
+		RETURNING id, user_id, (SELECT u.role FROM auth.users u WHERE u.id = auth.sessions.user_id)
+		`
+	}
+	var (
+		p         Principal
+		sessionID int
+	)
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(&sessionID, &p.UserID, &p.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	p.SessionID = &sessionID
+	return &p, nil
+}
+
+// Delete removes a session by its token hash.
+func (r *SessionRepository) Delete(ctx context.Context, hash string) error {
+	defer metrics.ObserveQuery("session", "Delete", time.Now())
+	query := `DELETE FROM auth.sessions WHERE token_hash = $1`
+	_, err := r.db.ExecContext(ctx, query, hash)
+	return err
+}
+
+// DeleteExpired removes all expired sessions, and returns the number of
+// removed sessions.
+func (r *SessionRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	defer metrics.ObserveQuery("session", "DeleteExpired", time.Now())
+	query := `DELETE FROM auth.sessions WHERE expires_at <= now()`
+	result, err := r.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials is returned for every failed login, regardless of
+	// whether the account exists, so responses do not reveal which accounts
+	// exist.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// dummyPasswordHash is compared against when a login does not match any
+// user, so the response takes as long as when the password is wrong.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	hash, _ := bcrypt.GenerateFromPassword(b, bcrypt.DefaultCost)
+	return string(hash)
+})
+
+// SessionService handles business logic for logins and sessions.
+type SessionService struct {
+	repo     *SessionRepository
+	users    *UserRepository
+	lockouts *LockoutService
+	audit    *AuditService
+	// ttl is how long a session lasts after login.
+	ttl time.Duration
+}
+
+// NewSessionService creates a new SessionService.
+func NewSessionService(
+	repo *SessionRepository,
+	users *UserRepository,
+	lockouts *LockoutService,
+	audit *AuditService,
+	ttl time.Duration,
+) *SessionService {
+	return &SessionService{repo: repo, users: users, lockouts: lockouts, audit: audit, ttl: ttl}
+}
+
+// Login verifies the credentials and starts a new session. The plain text
+// session token is only returned here and is never stored.
+//
+// Attempts are counted against both the account and the IP before the
+// password is checked, and a LockedError is returned while either is locked
+// out. Since attempts are
+// counted by the submitted login rather than by user, unknown accounts are
+// locked out the same way as existing ones.
+func (svc *SessionService) Login(
+	ctx context.Context, input *Login, ip string, userAgent string,
+) (*User, *Session, string, error) {
+	attempt, err := svc.lockouts.Attempt(ctx, input.Login, ip)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	user, err := svc.verify(ctx, input)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return nil, nil, "", errors.Join(err, svc.lockouts.Release(ctx, attempt))
+		}
+		if err := svc.lockouts.RecordFailure(ctx, attempt); err != nil {
+			return nil, nil, "", err
+		}
+		logins.WithLabelValues("password", "failure").Inc()
+		svc.audit.Record(ctx, AuditEntry{
+			Action:     AuditLoginFailed,
+			TargetType: "login",
+			TargetID:   normalizeAccount(input.Login),
+		})
+		return nil, nil, "", ErrInvalidCredentials
+	}
+
+	if err := svc.lockouts.RecordSuccess(ctx, attempt); err != nil {
+		return nil, nil, "", err
+	}
+
+	raw, session, err := svc.StartSession(ctx, user.ID, ip, userAgent)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	logins.WithLabelValues("password", "success").Inc()
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditLogin,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(user.ID),
+		ActorID:    &user.ID,
+	})
+	return user, session, raw, nil
+}
+
+// StartSession creates a session for a user that has already been
+// authenticated.
+func (svc *SessionService) StartSession(
+	ctx context.Context, userID int, ip string, userAgent string,
+) (string, *Session, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(b)
+
+	session, err := svc.repo.Create(ctx, userID, hashToken(raw), ip, userAgent, time.Now().Add(svc.ttl))
+	if err != nil {
+		return "", nil, err
+	}
+	return raw, session, nil
+}
+
+// verify checks the password of the user matching the login, and returns
+// ErrInvalidCredentials if there is no such user or the password is wrong.
+func (svc *SessionService) verify(ctx context.Context, input *Login) (*User, error) {
+	user, err := svc.users.GetByLogin(ctx, input.Login)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		_ = CheckPassword(dummyPasswordHash(), input.Password)
+		return nil, ErrInvalidCredentials
+	}
+
+	// A malformed hash is treated like a wrong password, as there is no way
+	// to log in with it either way.
+	if err := CheckPassword(user.PasswordHash, input.Password); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// Logout ends the session with the given plain text token.
+func (svc *SessionService) Logout(ctx context.Context, raw string) error {
+	return svc.repo.Delete(ctx, hashToken(raw))
+}
+
+// Authenticate resolves the principal owning the given plain text session
+// token.
+func (svc *SessionService) Authenticate(ctx context.Context, raw string) (*Principal, error) {
+	return svc.repo.Use(ctx, hashToken(raw))
+}
+
+// PruneExpired removes expired sessions.
+func (svc *SessionService) PruneExpired(ctx context.Context) (int64, error) {
+	return svc.repo.DeleteExpired(ctx)
+}
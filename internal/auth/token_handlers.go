@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+// TokenHandler defines HTTP handlers for personal access tokens.
+type TokenHandler struct {
+	Service *TokenService
+}
+
+// RegisterRoutes hooks up endpoints.
+func (h *TokenHandler) RegisterRoutes(ctx context.Context, mux *http.ServeMux) {
+	logger := logging.LoggerFromContext(ctx)
+
+	routeDefinitions := rest.RouteDefinitionList{
+		{
+			Path:    "GET /api/v0/auth/users/{id}/tokens",
+			Handler: h.listTokensHandler,
+		},
+		{
+			Path:    "POST /api/v0/auth/users/{id}/tokens",
+			Handler: h.createTokenHandler,
+			Class:   rest.RateClassAuth,
+		},
+		{
+			Path:    "DELETE /api/v0/auth/users/{id}/tokens/{tokenID}",
+			Handler: h.revokeTokenHandler,
+		},
+	}
+
+	for _, d := range routeDefinitions {
+		logger.Info("adding route", "route", d.Path)
+		mux.Handle(d.Path, d)
+	}
+}
+
+func (h *TokenHandler) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	id, err := rest.GetPathParamInt(r, "id")
+	if err != nil {
+		rest.UnableToGetPathParamFromRequest(w, r, "id", err)
+		return
+	}
+	if !authorizeSelf(w, r, id) {
+		return
+	}
+
+	logger.Info("decoding request body")
+	var input CreatePersonalAccessToken
+	if err := rest.DecodeJSONFromRequest(r, &input); err != nil {
+		rest.BadRequestResponse(w, r, rest.UnableToDecodeRequestBody, err)
+		return
+	}
+	logger = logger.With(slog.Group(
+		"input",
+		slog.Int("id", id),
+		slog.String("name", input.Name),
+		slog.Any("scopes", input.Scopes),
+	))
+
+	// A token must not be able to mint a token with broader access than it
+	// has itself.
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		for _, s := range input.Scopes {
+			if !principal.HasScope(s) {
+				rest.ForbiddenResponse(w, r, fmt.Errorf("token lacks scope %q", s))
+				return
+			}
+		}
+	}
+
+	logger.Info("creating token")
+	token, err := h.Service.CreateToken(ctx, id, &input)
+	if err != nil {
+		logger.Error("failed to create token", "error", err)
+		switch {
+		case errors.Is(err, ErrInvalidTokenName),
+			errors.Is(err, ErrInvalidScope),
+			errors.Is(err, ErrInvalidExpiry):
+			rest.BadRequestResponse(w, r, err.Error(), err)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = rest.WriteJSONResponse(w, http.StatusCreated, token)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *TokenHandler) listTokensHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	id, err := rest.GetPathParamInt(r, "id")
+	if err != nil {
+		rest.UnableToGetPathParamFromRequest(w, r, "id", err)
+		return
+	}
+	if !authorizeSelf(w, r, id) {
+		return
+	}
+	logger = logger.With(slog.Group("input", slog.Int("id", id)))
+
+	logger.Info("reading tokens")
+	tokens, err := h.Service.ListTokens(ctx, id)
+	if err != nil {
+		logger.Error("failed to read tokens", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, tokens)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *TokenHandler) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	id, err := rest.GetPathParamInt(r, "id")
+	if err != nil {
+		rest.UnableToGetPathParamFromRequest(w, r, "id", err)
+		return
+	}
+	tokenID, err := rest.GetPathParamInt(r, "tokenID")
+	if err != nil {
+		rest.UnableToGetPathParamFromRequest(w, r, "tokenID", err)
+		return
+	}
+	if !authorizeSelf(w, r, id) {
+		return
+	}
+	logger = logger.With(slog.Group(
+		"input",
+		slog.Int("id", id),
+		slog.Int("token_id", tokenID),
+	))
+
+	logger.Info("revoking token")
+	token, err := h.Service.RevokeToken(ctx, id, tokenID)
+	if err != nil {
+		logger.Error("failed to revoke token", "error", err)
+		switch {
+		case errors.Is(err, ErrTokenNotFound):
+			rest.NotFoundResponse(w, r, err)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, token)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestTokenHandlersAuthorizeSelf(t *testing.T) {
+	s := newTestServices(t)
+	h := &TokenHandler{Service: s.tokens}
+	owner := s.createUser(t, "owner")
+	other := s.createUser(t, "other")
+
+	token, err := s.tokens.CreateToken(context.Background(), owner, &CreatePersonalAccessToken{
+		Name:   "cli",
+		Scopes: []Scope{ScopeWorkoutRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := fmt.Sprintf("/api/v0/auth/users/%d/tokens", owner)
+	create := func(name string) string {
+		return fmt.Sprintf(`{"name": %q, "scopes": ["workout:read"]}`, name)
+	}
+	revoke := fmt.Sprintf("%s/%d", tokens, token.ID)
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		method    string
+		target    string
+		body      string
+		want      int
+	}{
+		{"list anonymous", anonymous, http.MethodGet, tokens, "", http.StatusUnauthorized},
+		{"list other user", userPrincipal(other), http.MethodGet, tokens, "", http.StatusForbidden},
+		{"list self", userPrincipal(owner), http.MethodGet, tokens, "", http.StatusOK},
+		{"list admin", admin, http.MethodGet, tokens, "", http.StatusOK},
+		{"create anonymous", anonymous, http.MethodPost, tokens, create("anonymous"), http.StatusUnauthorized},
+		{"create other user", userPrincipal(other), http.MethodPost, tokens, create("other"), http.StatusForbidden},
+		{"create self", userPrincipal(owner), http.MethodPost, tokens, create("self"), http.StatusCreated},
+		{"create admin", admin, http.MethodPost, tokens, create("admin"), http.StatusCreated},
+		{"revoke anonymous", anonymous, http.MethodDelete, revoke, "", http.StatusUnauthorized},
+		{"revoke other user", userPrincipal(other), http.MethodDelete, revoke, "", http.StatusForbidden},
+		{"revoke admin", admin, http.MethodDelete, revoke, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, tt.principal, tt.method, tt.target, tt.body)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
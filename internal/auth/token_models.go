@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"time"
+)
+
+// Scope limits what a personal access token is allowed to do. Scopes follow
+// the "<module>:<access>" format, where access is either read or write.
+// ScopeMetricsRead is the exception, and lets admins scrape the metrics.
+type Scope string
+
+const (
+	ScopeAuthRead     Scope = "auth:read"
+	ScopeAuthWrite    Scope = "auth:write"
+	ScopeWorkoutRead  Scope = "workout:read"
+	ScopeWorkoutWrite Scope = "workout:write"
+	ScopeMetricsRead  Scope = "metrics:read"
+)
+
+// Scopes lists every scope a token can be granted.
+var Scopes = []Scope{
+	ScopeAuthRead,
+	ScopeAuthWrite,
+	ScopeWorkoutRead,
+	ScopeWorkoutWrite,
+	ScopeMetricsRead,
+}
+
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []Scope    `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreatedPersonalAccessToken is returned once when a token is created, and is
+// the only time the plain text token is available.
+type CreatedPersonalAccessToken struct {
+	PersonalAccessToken
+	Token string `json:"token" log:"sensitive"`
+}
+
+type CreatePersonalAccessToken struct {
+	Name      string     `json:"name"`
+	Scopes    []Scope    `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
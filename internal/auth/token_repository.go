@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+var (
+	// ErrTokenNotFound is returned when a token does not exist, has been
+	// revoked or has expired.
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+// TokenRepository provides access to the personal access tokens store.
+type TokenRepository struct {
+	// db runs the queries rewritten to the dialect.
+	db      db.Querier
+	dialect db.Dialect
+}
+
+// NewTokenRepository creates a new TokenRepository.
+func NewTokenRepository(db *sql.DB, dialect db.Dialect) *TokenRepository {
+	return &TokenRepository{db: dialect.Wrap(db), dialect: dialect}
+}
+
+// tokenScanner is implemented by both *sql.Row and *sql.Rows.
+type tokenScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanToken(s tokenScanner) (*PersonalAccessToken, error) {
+	var (
+		t      PersonalAccessToken
+		scopes pq.StringArray
+	)
+	err := s.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.Prefix,
+		&scopes,
+		&t.ExpiresAt,
+		&t.LastUsedAt,
+		&t.RevokedAt,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = make([]Scope, len(scopes))
+	for i, s := range scopes {
+		t.Scopes[i] = Scope(s)
+	}
+	return &t, nil
+}
+
+// Create inserts a new token into the auth.personal_access_tokens table. Only
+// the hash of the token is stored.
+func (r *TokenRepository) Create(
+	ctx context.Context,
+	userID int,
+	hash string,
+	prefix string,
+	input *CreatePersonalAccessToken,
+) (*PersonalAccessToken, error) {
+	defer metrics.ObserveQuery("token", "Create", time.Now())
+	query := `
+	INSERT INTO auth.personal_access_tokens (
+		user_id, name, token_hash, token_prefix, scopes, expires_at
+	)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, user_id, name, token_prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+	`
+
+	scopes := make(pq.StringArray, len(input.Scopes))
+	for i, s := range input.Scopes {
+		scopes[i] = string(s)
+	}
+
+	return scanToken(r.db.QueryRowContext(
+		ctx,
+		query,
+		userID,
+		input.Name,
+		hash,
+		prefix,
+		scopes,
+		input.ExpiresAt,
+	))
+}
+
+// ListByUser retrieves all active tokens belonging to a user.
+func (r *TokenRepository) ListByUser(ctx context.Context, userID int) ([]*PersonalAccessToken, error) {
+	defer metrics.ObserveQuery("token", "ListByUser", time.Now())
+	query := `
+	SELECT id, user_id, name, token_prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+	FROM auth.personal_access_tokens
+	WHERE user_id = $1
+	AND revoked_at IS NULL
+	ORDER BY created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Revoke marks a token as revoked, after which it can no longer be used.
+func (r *TokenRepository) Revoke(ctx context.Context, userID int, id int) (*PersonalAccessToken, error) {
+	defer metrics.ObserveQuery("token", "Revoke", time.Now())
+	query := `
+	UPDATE auth.personal_access_tokens
+	SET revoked_at = now()
+	WHERE id = $1
+	AND user_id = $2
+	AND revoked_at IS NULL
+	RETURNING id, user_id, name, token_prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+	`
+	t, err := scanToken(r.db.QueryRowContext(ctx, query, id, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// Use looks up an active token by its hash, records that it was used and
+// returns the principal it authenticates.
+func (r *TokenRepository) Use(ctx context.Context, hash string) (*Principal, error) {
+	defer metrics.ObserveQuery("token", "Use", time.Now())
+	query := `
+	WITH used AS (
+		UPDATE auth.personal_access_tokens
+		SET last_used_at = now()
+		WHERE token_hash = $1
+		AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > now())
+		RETURNING id, user_id, scopes
+	)
+	SELECT used.id, used.user_id, used.scopes, u.role
+	FROM used
+	JOIN auth.users u ON u.id = used.user_id
+	WHERE u.disabled_at IS NULL
+	`
+	// SQLite cannot update within a WITH clause, so the role is looked up
+	// by the RETURNING clause instead.
+	if r.dialect == db.SQLite {
+		query = `
+		UPDATE auth.personal_access_tokens
+		SET last_used_at = now()
+		WHERE token_hash = $1
+		AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > now())
+		AND user_id IN (SELECT id FROM auth.users WHERE disabled_at IS NULL)
+		RETURNING id, user_id, scopes, (SELECT u.role FROM auth.users u WHERE u.id = auth.personal_access_tokens.user_id)
+		`
+	}
+	var (
+		p       Principal
+		tokenID int
+		scopes  pq.StringArray
+	)
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(&tokenID, &p.UserID, &scopes, &p.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+	p.TokenID = &tokenID
+	p.Scopes = make([]Scope, len(scopes))
+	for i, s := range scopes {
+		p.Scopes[i] = Scope(s)
+	}
+	return &p, nil
+}
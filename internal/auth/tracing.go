@@ -0,0 +1,7 @@
+package auth
+
+import (
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/evenlwanvik/smartsplit/internal/auth")
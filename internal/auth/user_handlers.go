@@ -1,9 +1,13 @@
 package auth
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
 
 	"github.com/evenlwanvik/smartsplit/internal/logging"
@@ -21,30 +25,40 @@ func (h *UserHandler) RegisterRoutes(ctx context.Context, mux *http.ServeMux) {
 
 	routeDefinitions := rest.RouteDefinitionList{
 		{
-			"GET /api/v0/auth/users",
-			h.listUsersHandler,
+			Path:    "GET /api/v0/auth/users",
+			Handler: RequireAdmin(h.listUsersHandler),
 		},
 		{
-			"GET /api/v0/auth/users/{id}",
-			h.getUserHandler,
+			Path:    "GET /api/v0/auth/users/{id}",
+			Handler: h.getUserHandler,
 		},
 		{
-			"PUT /api/v0/auth/users/{id}",
-			h.updateUserHandler,
+			Path:    "PATCH /api/v0/auth/users/{id}",
+			Handler: h.updateUserHandler,
 		},
 		{
-			"DELETE /api/v0/auth/users/{id}",
-			h.deleteUserHandler,
+			Path:    "POST /api/v0/auth/users/{id}/password",
+			Handler: h.changePasswordHandler,
+			Class:   rest.RateClassAuth,
 		},
 		{
-			"POST /api/v0/auth/users/register",
-			h.RegisterUserHandler,
+			Path:    "DELETE /api/v0/auth/users/{id}",
+			Handler: h.deleteUserHandler,
+		},
+		{
+			Path:    "POST /api/v0/auth/users/{id}/restore",
+			Handler: h.restoreUserHandler,
+		},
+		{
+			Path:    "POST /api/v0/auth/users/register",
+			Handler: h.RegisterUserHandler,
+			Class:   rest.RateClassAuth,
 		},
 	}
 
 	for _, d := range routeDefinitions {
 		logger.Info("adding route", "route", d.Path)
-		mux.Handle(d.Path, d.Handler)
+		mux.Handle(d.Path, d)
 	}
 }
 
@@ -64,7 +78,14 @@ func (h *UserHandler) RegisterUserHandler(w http.ResponseWriter, r *http.Request
 	createdUser, err := h.Service.CreateUser(ctx, &user)
 	if err != nil {
 		logger.Error("failed to create user", "error", err)
-		rest.InternalServerErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, ErrInvalidPassword):
+			rest.BadRequestResponse(w, r, err.Error(), err)
+		case errors.Is(err, ErrEmailTaken), errors.Is(err, ErrUsernameTaken):
+			rest.ConflictResponse(w, r, err.Error(), err)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -105,6 +126,9 @@ func (h *UserHandler) getUserHandler(w http.ResponseWriter, r *http.Request) {
 		rest.UnableToGetPathParamFromRequest(w, r, "id", err)
 		return
 	}
+	if !authorizeSelf(w, r, id) {
+		return
+	}
 	logger = logger.With(slog.Group("input", slog.Int("id", id)))
 
 	logger.Info("reading user")
@@ -128,6 +152,55 @@ func (h *UserHandler) getUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// MergePatchContentType is the media type of a JSON Merge Patch document, as
+// defined in RFC 7396.
+const MergePatchContentType = "application/merge-patch+json"
+
+// decodeUserMergePatch decodes a JSON Merge Patch document into a partial
+// user update. Fields that are left out are not changed. None of the
+// patchable fields can be removed, so null values are rejected, as are
+// fields that cannot be patched.
+func decodeUserMergePatch(r *http.Request) (*UpdateUser, error) {
+	if r.Body == nil {
+		return nil, errors.New("request body is empty")
+	}
+	defer r.Body.Close()
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return nil, err
+	}
+	if patch == nil {
+		return nil, errors.New("merge patch must be a JSON object")
+	}
+
+	var user UpdateUser
+	fields := map[string]**string{
+		"email":      &user.Email,
+		"first_name": &user.FirstName,
+		"last_name":  &user.LastName,
+		"username":   &user.Username,
+	}
+	for key, value := range patch {
+		field, ok := fields[key]
+		if !ok {
+			if key == "password" || key == "password_hash" {
+				return nil, fmt.Errorf("%s cannot be patched, use the password endpoint instead", key)
+			}
+			return nil, fmt.Errorf("%s cannot be patched", key)
+		}
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			return nil, fmt.Errorf("%s cannot be removed", key)
+		}
+		var v string
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, fmt.Errorf("%s must be a string", key)
+		}
+		*field = &v
+	}
+	return &user, nil
+}
+
 func (h *UserHandler) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	logger := logging.LoggerFromContext(ctx)
@@ -137,11 +210,21 @@ func (h *UserHandler) updateUserHandler(w http.ResponseWriter, r *http.Request)
 		rest.UnableToGetPathParamFromRequest(w, r, "id", err)
 		return
 	}
+	if !authorizeSelf(w, r, id) {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != MergePatchContentType && mediaType != "application/json" {
+		w.Header().Set("Accept-Patch", MergePatchContentType)
+		rest.ErrorResponse(w, r, http.StatusUnsupportedMediaType, "content type must be "+MergePatchContentType)
+		return
+	}
 
 	logger.Info("decoding request body")
-	var user UpdateUser
-	if err := rest.DecodeJSONFromRequest(r, &user); err != nil {
-		rest.BadRequestResponse(w, r, rest.UnableToDecodeRequestBody, err)
+	user, err := decodeUserMergePatch(r)
+	if err != nil {
+		rest.BadRequestResponse(w, r, err.Error(), err)
 		return
 	}
 	logger = logger.With(slog.Group(
@@ -151,12 +234,16 @@ func (h *UserHandler) updateUserHandler(w http.ResponseWriter, r *http.Request)
 	))
 
 	logger.Info("updating user")
-	updatedUser, err := h.Service.UpdateUser(ctx, id, &user)
+	updatedUser, err := h.Service.UpdateUser(ctx, id, user)
 	if err != nil {
 		logger.Error("failed to update user", "error", err)
 		switch {
 		case errors.Is(err, ErrNotFound):
 			rest.NotFoundResponse(w, r, err)
+		case errors.Is(err, ErrInvalidUser):
+			rest.BadRequestResponse(w, r, err.Error(), err)
+		case errors.Is(err, ErrEmailTaken), errors.Is(err, ErrUsernameTaken):
+			rest.ConflictResponse(w, r, err.Error(), err)
 		default:
 			rest.InternalServerErrorResponse(w, r, err)
 		}
@@ -171,6 +258,47 @@ func (h *UserHandler) updateUserHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func (h *UserHandler) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	id, err := rest.GetPathParamInt(r, "id")
+	if err != nil {
+		rest.UnableToGetPathParamFromRequest(w, r, "id", err)
+		return
+	}
+	if !authorizeSelf(w, r, id) {
+		return
+	}
+	logger = logger.With(slog.Group("input", slog.Int("id", id)))
+
+	logger.Info("decoding request body")
+	var input ChangePassword
+	if err := rest.DecodeJSONFromRequest(r, &input); err != nil {
+		rest.BadRequestResponse(w, r, rest.UnableToDecodeRequestBody, err)
+		return
+	}
+
+	logger.Info("changing password")
+	err = h.Service.ChangePassword(ctx, id, &input)
+	if err != nil {
+		logger.Error("failed to change password", "error", err)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			rest.NotFoundResponse(w, r, err)
+		case errors.Is(err, ErrIncorrectPassword):
+			rest.ForbiddenResponse(w, r, err)
+		case errors.Is(err, ErrInvalidPassword):
+			rest.BadRequestResponse(w, r, err.Error(), err)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *UserHandler) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	logger := logging.LoggerFromContext(ctx)
@@ -180,10 +308,13 @@ func (h *UserHandler) deleteUserHandler(w http.ResponseWriter, r *http.Request)
 		rest.UnableToGetPathParamFromRequest(w, r, "id", err)
 		return
 	}
+	if !authorizeSelf(w, r, id) {
+		return
+	}
 	logger = logger.With(slog.Group("input", slog.Int("id", id)))
 
-	logger.Info("deleting user")
-	updatedUser, err := h.Service.DeleteUser(ctx, id)
+	logger.Info("scheduling user deletion")
+	deletedUser, err := h.Service.DeleteUser(ctx, id)
 	if err != nil {
 		logger.Error("failed to delete user", "error", err)
 		switch {
@@ -195,7 +326,44 @@ func (h *UserHandler) deleteUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = rest.WriteJSONResponse(w, http.StatusOK, updatedUser)
+	err = rest.WriteJSONResponse(w, http.StatusOK, deletedUser)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *UserHandler) restoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	id, err := rest.GetPathParamInt(r, "id")
+	if err != nil {
+		rest.UnableToGetPathParamFromRequest(w, r, "id", err)
+		return
+	}
+	if !authorizeSelf(w, r, id) {
+		return
+	}
+	logger = logger.With(slog.Group("input", slog.Int("id", id)))
+
+	logger.Info("restoring user")
+	restoredUser, err := h.Service.RestoreUser(ctx, id)
+	if err != nil {
+		logger.Error("failed to restore user", "error", err)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			rest.NotFoundResponse(w, r, err)
+		case errors.Is(err, ErrDeletionNotScheduled):
+			rest.ConflictResponse(w, r, err.Error(), err)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, restoredUser)
 	if err != nil {
 		logger.Error("failed to write response", "error", err)
 		rest.InternalServerErrorResponse(w, r, err)
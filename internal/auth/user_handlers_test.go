@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterUserHandler(t *testing.T) {
+	s := newTestServices(t)
+	h := &UserHandler{Service: s.users}
+
+	body := `{"email": "new@example.com", "first_name": "New", "last_name": "User",
+		"username": "newuser", "password": "correct horse battery staple"}`
+	w := serve(h, anonymous, http.MethodPost, "/api/v0/auth/users/register", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	var user User
+	if err := json.NewDecoder(w.Body).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "newuser" {
+		t.Errorf("got username %q, want %q", user.Username, "newuser")
+	}
+}
+
+func TestListAndGetUsersAuthorize(t *testing.T) {
+	s := newTestServices(t)
+	h := &UserHandler{Service: s.users}
+	owner := s.createUser(t, "owner")
+	other := s.createUser(t, "other")
+
+	users := "/api/v0/auth/users"
+	user := fmt.Sprintf("%s/%d", users, owner)
+	missing := users + "/999999"
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		target    string
+		want      int
+	}{
+		{"list anonymous", anonymous, users, http.StatusUnauthorized},
+		{"list user", userPrincipal(owner), users, http.StatusForbidden},
+		{"list admin", admin, users, http.StatusOK},
+		{"get anonymous", anonymous, user, http.StatusUnauthorized},
+		{"get other user", userPrincipal(other), user, http.StatusForbidden},
+		// Users cannot tell a missing account from someone else's.
+		{"get missing user", userPrincipal(other), missing, http.StatusForbidden},
+		{"get self", userPrincipal(owner), user, http.StatusOK},
+		{"get admin", admin, user, http.StatusOK},
+		{"get missing admin", admin, missing, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, tt.principal, http.MethodGet, tt.target, "")
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if w.Code != http.StatusOK && strings.Contains(w.Body.String(), "@example.com") {
+				t.Errorf("got %s, want no accounts in the error", w.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateUserAndPasswordAuthorizeSelf(t *testing.T) {
+	s := newTestServices(t)
+	h := &UserHandler{Service: s.users}
+	owner := s.createUser(t, "owner")
+	other := s.createUser(t, "other")
+
+	user := fmt.Sprintf("/api/v0/auth/users/%d", owner)
+	password := user + "/password"
+	patch := `{"first_name": "Patched"}`
+	change := `{"current_password": "correct horse battery staple", "new_password": "correct horse battery staple"}`
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		method    string
+		target    string
+		body      string
+		want      int
+	}{
+		{"update anonymous", anonymous, http.MethodPatch, user, patch, http.StatusUnauthorized},
+		{"update other user", userPrincipal(other), http.MethodPatch, user, patch, http.StatusForbidden},
+		{"update self", userPrincipal(owner), http.MethodPatch, user, patch, http.StatusOK},
+		{"update admin", admin, http.MethodPatch, user, patch, http.StatusOK},
+		{"password anonymous", anonymous, http.MethodPost, password, change, http.StatusUnauthorized},
+		{"password other user", userPrincipal(other), http.MethodPost, password, change, http.StatusForbidden},
+		{"password self", userPrincipal(owner), http.MethodPost, password, change, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, tt.principal, tt.method, tt.target, tt.body)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestDecodeUserMergePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    UpdateUser
+		wantErr string
+	}{
+		{name: "empty", body: `{}`},
+		{
+			name: "fields",
+			body: `{"first_name": "Ada", "username": "ada"}`,
+			want: UpdateUser{FirstName: ptr("Ada"), Username: ptr("ada")},
+		},
+		{name: "null", body: `{"email": null}`, wantErr: "email cannot be removed"},
+		{name: "not a string", body: `{"last_name": 1}`, wantErr: "last_name must be a string"},
+		{name: "unknown field", body: `{"role": "admin"}`, wantErr: "role cannot be patched"},
+		{name: "password", body: `{"password": "secret"}`, wantErr: "use the password endpoint"},
+		{name: "old username", body: `{"user_name": "ada"}`, wantErr: "user_name cannot be patched"},
+		{name: "not an object", body: `null`, wantErr: "must be a JSON object"},
+		{name: "invalid", body: `{`, wantErr: "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(tt.body))
+			got, err := decodeUserMergePatch(r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalUpdate(*got, tt.want) {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string { return &s }
+
+func equalUpdate(a, b UpdateUser) bool {
+	eq := func(x, y *string) bool {
+		return (x == nil) == (y == nil) && (x == nil || *x == *y)
+	}
+	return eq(a.Email, b.Email) && eq(a.FirstName, b.FirstName) &&
+		eq(a.LastName, b.LastName) && eq(a.Username, b.Username)
+}
+
+func TestDeleteAndRestoreUserAuthorizeSelf(t *testing.T) {
+	s := newTestServices(t)
+	h := &UserHandler{Service: s.users}
+	owner := s.createUser(t, "owner")
+	other := s.createUser(t, "other")
+
+	user := fmt.Sprintf("/api/v0/auth/users/%d", owner)
+	restore := user + "/restore"
+
+	// The steps run in order, as restoring needs a scheduled deletion.
+	steps := []struct {
+		name      string
+		principal *Principal
+		method    string
+		target    string
+		want      int
+	}{
+		{"delete anonymous", anonymous, http.MethodDelete, user, http.StatusUnauthorized},
+		{"delete other user", userPrincipal(other), http.MethodDelete, user, http.StatusForbidden},
+		{"delete self", userPrincipal(owner), http.MethodDelete, user, http.StatusOK},
+		{"restore anonymous", anonymous, http.MethodPost, restore, http.StatusUnauthorized},
+		{"restore other user", userPrincipal(other), http.MethodPost, restore, http.StatusForbidden},
+		{"restore admin", admin, http.MethodPost, restore, http.StatusOK},
+		{"delete admin", admin, http.MethodDelete, user, http.StatusOK},
+		{"restore self", userPrincipal(owner), http.MethodPost, restore, http.StatusOK},
+	}
+	for _, step := range steps {
+		w := serve(h, step.principal, step.method, step.target, "")
+		if w.Code != step.want {
+			t.Fatalf("%s: got status %d, want %d: %s", step.name, w.Code, step.want, w.Body)
+		}
+	}
+}
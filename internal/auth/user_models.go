@@ -1,37 +1,79 @@
 package auth
 
 import (
+	"log/slog"
 	"time"
 )
 
+// Role determines what a user is allowed to administer.
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
 type User struct {
-	ID           int       `json:"id,omitempty"`
-	Email        string    `json:"email,omitempty"`
-	FirstName    string    `json:"first_name,omitempty"`
-	LastName     string    `json:"last_name,omitempty"`
-	Username     string    `json:"username,omitempty"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           int        `json:"id,omitempty"`
+	Email        string     `json:"email,omitempty" log:"sensitive"`
+	FirstName    string     `json:"first_name,omitempty"`
+	LastName     string     `json:"last_name,omitempty"`
+	Username     string     `json:"username,omitempty"`
+	PasswordHash string     `json:"-"`
+	Role         Role       `json:"role"`
+	DeleteAfter  *time.Time `json:"delete_after,omitempty"`
+	DisabledAt   *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
 }
 
 type CreateUser struct {
-	Email        string `json:"email"`
+	Email        string `json:"email" log:"sensitive"`
 	FirstName    string `json:"first_name"`
 	LastName     string `json:"last_name"`
-	Username     string `json:"user_name"`
-	PasswordHash string `json:"password_hash"`
+	Username     string `json:"username"`
+	Password     string `json:"password" log:"sensitive"`
+	PasswordHash string `json:"-"`
 }
 
+// UpdateUser is a partial update of a user, where nil fields are left
+// unchanged.
 type UpdateUser struct {
-	Email        *string `json:"email"`
-	FirstName    *string `json:"first_name"`
-	LastName     *string `json:"last_name"`
-	Username     *string `json:"user_name"`
-	PasswordHash *string `json:"password_hash"`
+	Email     *string `json:"email,omitempty" log:"sensitive"`
+	FirstName *string `json:"first_name,omitempty"`
+	LastName  *string `json:"last_name,omitempty"`
+	Username  *string `json:"username,omitempty"`
+}
+
+// IsEmpty reports whether the update does not change any fields.
+func (u *UpdateUser) IsEmpty() bool {
+	return u.Email == nil && u.FirstName == nil && u.LastName == nil && u.Username == nil
+}
+
+// LogValue implements slog.LogValuer, and only includes the supplied fields.
+func (u UpdateUser) LogValue() slog.Value {
+	var attrs []slog.Attr
+	if u.Email != nil {
+		attrs = append(attrs, slog.String("email", *u.Email))
+	}
+	if u.FirstName != nil {
+		attrs = append(attrs, slog.String("first_name", *u.FirstName))
+	}
+	if u.LastName != nil {
+		attrs = append(attrs, slog.String("last_name", *u.LastName))
+	}
+	if u.Username != nil {
+		attrs = append(attrs, slog.String("username", *u.Username))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+type ChangePassword struct {
+	CurrentPassword string `json:"current_password" log:"sensitive"`
+	NewPassword     string `json:"new_password" log:"sensitive"`
 }
 
 type RegisterUser struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" log:"sensitive"`
+	Password string `json:"password" log:"sensitive"`
 }
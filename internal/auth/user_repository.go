@@ -4,36 +4,105 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/events"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+	"github.com/evenlwanvik/smartsplit/internal/tracing"
 )
 
 var (
 	// ErrNotFound is returned when a user cannot be found in the database.
 	ErrNotFound = errors.New("user not found")
+	// ErrEmailTaken is returned when an email is already used by another user.
+	ErrEmailTaken = errors.New("email is already in use")
+	// ErrUsernameTaken is returned when a username is already used by another
+	// user.
+	ErrUsernameTaken = errors.New("username is already in use")
+	// ErrDeletionNotScheduled is returned when restoring a user that is not
+	// scheduled for deletion.
+	ErrDeletionNotScheduled = errors.New("user is not scheduled for deletion")
 )
 
 // UserRepository provides access to the users store.
 type UserRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect db.Dialect
+	// q runs the queries rewritten to the dialect.
+	q db.Querier
 }
 
 // NewUserRepository creates a new UserRepository.
-func NewUserRepository(db *sql.DB) *UserRepository {
-	return &UserRepository{db: db}
+func NewUserRepository(db *sql.DB, dialect db.Dialect) *UserRepository {
+	return &UserRepository{db: db, dialect: dialect, q: dialect.Wrap(db)}
+}
+
+// userScanner is implemented by both *sql.Row and *sql.Rows.
+type userScanner interface {
+	Scan(dest ...any) error
 }
 
-// Create inserts a new user into the auth.user table.
+func scanUser(s userScanner) (*User, error) {
+	var u User
+	err := s.Scan(
+		&u.ID,
+		&u.Email,
+		&u.FirstName,
+		&u.LastName,
+		&u.Username,
+		&u.PasswordHash,
+		&u.Role,
+		&u.DeleteAfter,
+		&u.DisabledAt,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// uniqueViolation maps unique constraint violations on the users table to
+// their domain errors.
+func uniqueViolation(err error) error {
+	constraint, ok := db.UniqueViolation(err)
+	if !ok {
+		return err
+	}
+	switch constraint {
+	case "users_email_key", "auth_users.email":
+		return ErrEmailTaken
+	case "users_username_key", "auth_users.username":
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+// Create inserts a new user into the auth.users table.
 func (r *UserRepository) Create(ctx context.Context, user *CreateUser) (*User, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.Create")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "Create", time.Now())
 	query := `
-	INSERT INTO auth.user (
+	INSERT INTO auth.users (
 		email, first_name, last_name, username, password_hash
 	)
 	VALUES ($1, $2, $3, $4, $5)
-	RETURNING id, email, first_name, last_name, username, password_hash, created_at, updated_at
+	RETURNING id, email, first_name, last_name, username, password_hash, role, delete_after, disabled_at, created_at, updated_at
 	`
 
-	var u User
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	q := r.dialect.Wrap(tx)
 
-	err := r.db.QueryRowContext(
+	u, err := scanUser(q.QueryRowContext(
 		ctx,
 		query,
 		user.Email,
@@ -41,42 +110,57 @@ func (r *UserRepository) Create(ctx context.Context, user *CreateUser) (*User, e
 		user.LastName,
 		user.Username,
 		user.PasswordHash,
-	).Scan(
-		&u.ID,
-		&u.Email,
-		&u.FirstName,
-		&u.LastName,
-		&u.Username,
-		&u.PasswordHash,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-	)
+	))
 	if err != nil {
-		return nil, err
+		return nil, uniqueViolation(err)
 	}
 
-	return &u, err
+	err = events.Append(ctx, q, events.UserRegistered{UserID: u.ID, Username: u.Username})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return u, nil
 }
 
 // GetByID fetches a user by ID.
 func (r *UserRepository) GetByID(ctx context.Context, id int) (*User, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.GetByID")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "GetByID", time.Now())
 	query := `
-	SELECT id, email, first_name, last_name, username, password_hash, created_at, updated_at
-	FROM auth.user
+	SELECT id, email, first_name, last_name, username, password_hash, role, delete_after, disabled_at, created_at, updated_at
+	FROM auth.users
 	WHERE id = $1
 	`
-	var u User
-	err := r.db.QueryRowContext(ctx, query, id).
-		Scan(
-			&u.ID,
-			&u.Email,
-			&u.FirstName,
-			&u.LastName,
-			&u.Username,
-			&u.PasswordHash,
-			&u.CreatedAt,
-			&u.UpdatedAt,
-		)
+	u, err := scanUser(r.q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// GetByLogin fetches a user by username or email. Emails are compared case
+// insensitively.
+func (r *UserRepository) GetByLogin(ctx context.Context, login string) (*User, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.GetByLogin")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "GetByLogin", time.Now())
+	query := `
+	SELECT id, email, first_name, last_name, username, password_hash, role, delete_after, disabled_at, created_at, updated_at
+	FROM auth.users
+	WHERE (username = $1 OR lower(email) = lower($1))
+	AND disabled_at IS NULL
+	ORDER BY username = $1 DESC
+	LIMIT 1
+	`
+	u, err := scanUser(r.q.QueryRowContext(ctx, query, login))
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -84,17 +168,20 @@ func (r *UserRepository) GetByID(ctx context.Context, id int) (*User, error) {
 		}
 		return nil, err
 	}
-	return &u, err
+	return u, nil
 }
 
-// List retrieves all users from the auth.user table.
+// List retrieves all users from the auth.users table.
 func (r *UserRepository) List(ctx context.Context) ([]*User, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.List")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "List", time.Now())
 	query := `
-	SELECT id, email, first_name, last_name, username, password_hash, created_at, updated_at
-	FROM auth.user
+	SELECT id, email, first_name, last_name, username, password_hash, role, delete_after, disabled_at, created_at, updated_at
+	FROM auth.users
 	ORDER BY created_at DESC
 	`
-	rows, err := r.db.QueryContext(ctx, query)
+	rows, err := r.q.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -102,21 +189,11 @@ func (r *UserRepository) List(ctx context.Context) ([]*User, error) {
 
 	var users []*User
 	for rows.Next() {
-		var u User
-		err := rows.Scan(
-			&u.ID,
-			&u.Email,
-			&u.FirstName,
-			&u.LastName,
-			&u.Username,
-			&u.PasswordHash,
-			&u.CreatedAt,
-			&u.UpdatedAt,
-		)
+		u, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
-		users = append(users, &u)
+		users = append(users, u)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -124,90 +201,302 @@ func (r *UserRepository) List(ctx context.Context) ([]*User, error) {
 	return users, nil
 }
 
-// Update modifies an existing user's details.
+// Update modifies the supplied fields of an existing user, leaving the other
+// fields unchanged.
 func (r *UserRepository) Update(ctx context.Context, id int, user *UpdateUser) (*User, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.Update")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "Update", time.Now())
+	if user.IsEmpty() {
+		return r.GetByID(ctx, id)
+	}
+
+	args := []any{id}
+	var set []string
+	column := func(name string, value any) {
+		args = append(args, value)
+		set = append(set, fmt.Sprintf("%s = $%d", name, len(args)))
+	}
+	if user.Email != nil {
+		column("email", *user.Email)
+	}
+	if user.FirstName != nil {
+		column("first_name", *user.FirstName)
+	}
+	if user.LastName != nil {
+		column("last_name", *user.LastName)
+	}
+	if user.Username != nil {
+		column("username", *user.Username)
+	}
+
 	query := `
-	UPDATE auth.user
-	SET
-		email = $2,
-		first_name = $3,
-		last_name = $4,
-		username = $5,
-		password_hash = $6,
-		updated_at = NOW()
+	UPDATE auth.users
+	SET ` + strings.Join(set, ", ") + `, updated_at = NOW()
 	WHERE id = $1
-	RETURNING id, email, first_name, last_name, username, password_hash, created_at, updated_at
+	RETURNING id, email, first_name, last_name, username, password_hash, role, delete_after, disabled_at, created_at, updated_at
 	`
 
-	var u User
-
-	err := r.db.QueryRowContext(
-		ctx,
-		query,
-		id,
-		user.Email,
-		user.FirstName,
-		user.LastName,
-		user.Username,
-		user.PasswordHash,
-	).Scan(
-		&u.ID,
-		&u.Email,
-		&u.FirstName,
-		&u.LastName,
-		&u.Username,
-		&u.PasswordHash,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-	)
+	u, err := scanUser(r.q.QueryRowContext(ctx, query, args...))
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrNotFound
 		}
-		return nil, err
+		return nil, uniqueViolation(err)
 	}
 
-	return &u, nil
+	return u, nil
 }
 
-// Delete removes a user and all associated workout data.
-func (r *UserRepository) Delete(ctx context.Context, id int) (*User, error) {
+// UpdatePasswordHash replaces the password hash of a user and ends all of
+// its sessions but keepSession, if it is set. Ending the sessions in the same
+// transaction makes sure no session outlives the old password.
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, id int, hash string, keepSession *int) error {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.UpdatePasswordHash")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "UpdatePasswordHash", time.Now())
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer tx.Rollback()
+	q := r.dialect.Wrap(tx)
 
-	// TODO: also remove associated workout records in workout schema
+	query := `
+	UPDATE auth.users
+	SET password_hash = $2, updated_at = NOW()
+	WHERE id = $1
+	`
+	result, err := q.ExecContext(ctx, query, id, hash)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
 
-	deleteQuery := `
-	DELETE FROM auth.user WHERE id = $1
-	RETURNING id, email, first_name, last_name, username, password_hash, created_at, updated_at
+	sessionsQuery := `DELETE FROM auth.sessions WHERE user_id = $1 AND ($2 IS NULL OR id <> $2)`
+	if _, err := q.ExecContext(ctx, sessionsQuery, id, keepSession); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Disable disables a user and ends all of its sessions. Disabling a user
+// that is already disabled keeps the original time.
+func (r *UserRepository) Disable(ctx context.Context, id int) (*User, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.Disable")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "Disable", time.Now())
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	q := r.dialect.Wrap(tx)
+
+	query := `
+	UPDATE auth.users
+	SET disabled_at = COALESCE(disabled_at, now()), updated_at = now()
+	WHERE id = $1
+	RETURNING id, email, first_name, last_name, username, password_hash, role, delete_after, disabled_at, created_at, updated_at
 	`
+	u, err := scanUser(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
 
-	var u User
+	sessionsQuery := `DELETE FROM auth.sessions WHERE user_id = $1`
+	if _, err := q.ExecContext(ctx, sessionsQuery, id); err != nil {
+		return nil, err
+	}
+	return u, tx.Commit()
+}
 
-	err = tx.QueryRowContext(ctx, deleteQuery, id).Scan(
-		&u.ID,
-		&u.Email,
-		&u.FirstName,
-		&u.LastName,
-		&u.Username,
-		&u.PasswordHash,
-		&u.CreatedAt,
-		&u.UpdatedAt,
+// ExistsByEmail reports whether a user other than the given one uses the
+// email. Emails are compared case insensitively.
+func (r *UserRepository) ExistsByEmail(ctx context.Context, email string, exceptID int) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.ExistsByEmail")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "ExistsByEmail", time.Now())
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM auth.users WHERE lower(email) = lower($1) AND id <> $2
 	)
+	`
+	var exists bool
+	err := r.q.QueryRowContext(ctx, query, email, exceptID).Scan(&exists)
+	return exists, err
+}
+
+// ExistsByUsername reports whether a user other than the given one uses the
+// username.
+func (r *UserRepository) ExistsByUsername(ctx context.Context, username string, exceptID int) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.ExistsByUsername")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "ExistsByUsername", time.Now())
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM auth.users WHERE username = $1 AND id <> $2
+	)
+	`
+	var exists bool
+	err := r.q.QueryRowContext(ctx, query, username, exceptID).Scan(&exists)
+	return exists, err
+}
+
+// ScheduleDelete marks a user for deletion once the given time has passed. A
+// user that is already scheduled keeps its original deletion time.
+func (r *UserRepository) ScheduleDelete(ctx context.Context, id int, after time.Time) (*User, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.ScheduleDelete")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "ScheduleDelete", time.Now())
+	query := `
+	UPDATE auth.users
+	SET delete_after = COALESCE(delete_after, $2)
+	WHERE id = $1
+	RETURNING id, email, first_name, last_name, username, password_hash, role, delete_after, disabled_at, created_at, updated_at
+	`
+	u, err := scanUser(r.q.QueryRowContext(ctx, query, id, after))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// CancelDelete removes a scheduled deletion from a user.
+func (r *UserRepository) CancelDelete(ctx context.Context, id int) (*User, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.CancelDelete")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "CancelDelete", time.Now())
+	query := `
+	UPDATE auth.users
+	SET delete_after = NULL
+	WHERE id = $1
+	AND delete_after IS NOT NULL
+	RETURNING id, email, first_name, last_name, username, password_hash, role, delete_after, disabled_at, created_at, updated_at
+	`
+	u, err := scanUser(r.q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			if _, err := r.GetByID(ctx, id); err != nil {
+				return nil, err
+			}
+			return nil, ErrDeletionNotScheduled
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// ListDueForDeletion returns the IDs of users whose deletion grace period has
+// passed.
+func (r *UserRepository) ListDueForDeletion(ctx context.Context) ([]int, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.ListDueForDeletion")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "ListDueForDeletion", time.Now())
+	query := `
+	SELECT id
+	FROM auth.users
+	WHERE delete_after <= now()
+	ORDER BY delete_after
+	`
+	rows, err := r.q.QueryContext(ctx, query)
 	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Delete removes a user and everything it owns. The cleanup hooks run in the
+// same transaction as the delete itself, so either all data owned by the
+// user is removed or nothing is.
+func (r *UserRepository) Delete(ctx context.Context, id int, hooks []UserCleanupHook) (*User, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "auth.UserRepository.Delete")
+	defer span.End()
+	defer metrics.ObserveQuery("user", "Delete", time.Now())
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	q := r.dialect.Wrap(tx)
+
+	// Lock the user row first, so a concurrent restore cannot interleave
+	// with the cleanup.
+	lockQuery := `SELECT id FROM auth.users WHERE id = $1 FOR UPDATE`
+	if err := q.QueryRowContext(ctx, lockQuery, id).Scan(&id); err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
+
+	for _, hook := range hooks {
+		if err := hook.Fn(ctx, tx, id); err != nil {
+			return nil, &CleanupError{Hook: hook.Name, Err: err}
+		}
+	}
+
+	tokensQuery := `DELETE FROM auth.personal_access_tokens WHERE user_id = $1`
+	if _, err := q.ExecContext(ctx, tokensQuery, id); err != nil {
+		return nil, err
+	}
+
+	sessionsQuery := `DELETE FROM auth.sessions WHERE user_id = $1`
+	if _, err := q.ExecContext(ctx, sessionsQuery, id); err != nil {
+		return nil, err
+	}
+
+	identitiesQuery := `DELETE FROM auth.identities WHERE user_id = $1`
+	if _, err := q.ExecContext(ctx, identitiesQuery, id); err != nil {
+		return nil, err
+	}
+
+	deleteQuery := `
+	DELETE FROM auth.users WHERE id = $1
+	RETURNING id, email, first_name, last_name, username, password_hash, role, delete_after, disabled_at, created_at, updated_at
+	`
+
+	u, err := scanUser(q.QueryRowContext(ctx, deleteQuery, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := events.Append(ctx, q, events.UserDeleted{UserID: u.ID}); err != nil {
+		return nil, err
+	}
 	err = tx.Commit()
 	if err != nil {
-		return &u, err
+		return u, err
 	}
-	return &u, nil
+	return u, nil
 }
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/db/dbtest"
+)
+
+func TestUserRepositoryRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := dbtest.NewSQLite(t)
+	repo := NewUserRepository(sqlDB, db.SQLite)
+
+	created, err := repo.Create(ctx, &CreateUser{
+		Email:        "Ada@example.com",
+		FirstName:    "Ada",
+		LastName:     "Lovelace",
+		Username:     "ada",
+		PasswordHash: "hash",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == 0 || created.Role != RoleUser || created.CreatedAt.IsZero() {
+		t.Fatalf("got %+v, want a user with an ID, the user role and a creation time", created)
+	}
+
+	t.Run("get", func(t *testing.T) {
+		got, err := repo.GetByID(ctx, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Email != created.Email || got.Username != "ada" || got.PasswordHash != "hash" {
+			t.Errorf("got %+v, want %+v", got, created)
+		}
+		if !got.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("got created at %s, want %s", got.CreatedAt, created.CreatedAt)
+		}
+		if _, err := repo.GetByID(ctx, 999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error %v, want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("get by login", func(t *testing.T) {
+		for _, login := range []string{"ada", "ada@EXAMPLE.com"} {
+			got, err := repo.GetByLogin(ctx, login)
+			if err != nil || got.ID != created.ID {
+				t.Errorf("%s: got %v, %v, want user %d", login, got, err, created.ID)
+			}
+		}
+		if _, err := repo.GetByLogin(ctx, "Ada"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error %v, want usernames to be case sensitive", err)
+		}
+	})
+
+	t.Run("unique", func(t *testing.T) {
+		_, err := repo.Create(ctx, &CreateUser{Email: "other@example.com", Username: "ada", PasswordHash: "hash"})
+		if !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("got error %v, want %v", err, ErrUsernameTaken)
+		}
+		_, err = repo.Create(ctx, &CreateUser{Email: "Ada@example.com", Username: "other", PasswordHash: "hash"})
+		if !errors.Is(err, ErrEmailTaken) {
+			t.Errorf("got error %v, want %v", err, ErrEmailTaken)
+		}
+		if taken, err := repo.ExistsByEmail(ctx, "ADA@example.com", 0); err != nil || !taken {
+			t.Errorf("got %t, %v, want the email to be taken", taken, err)
+		}
+		if taken, err := repo.ExistsByEmail(ctx, "ada@example.com", created.ID); err != nil || taken {
+			t.Errorf("got %t, %v, want the email not to be taken by the user itself", taken, err)
+		}
+		if taken, err := repo.ExistsByUsername(ctx, "ada", created.ID); err != nil || taken {
+			t.Errorf("got %t, %v, want the username not to be taken by the user itself", taken, err)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		name := "Augusta"
+		got, err := repo.Update(ctx, created.ID, &UpdateUser{FirstName: &name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.FirstName != name || got.LastName != "Lovelace" {
+			t.Errorf("got %q %q, want only the first name changed", got.FirstName, got.LastName)
+		}
+		if err := repo.UpdatePasswordHash(ctx, created.ID, "new hash", nil); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := repo.GetByID(ctx, created.ID); got.PasswordHash != "new hash" {
+			t.Errorf("got password hash %q, want %q", got.PasswordHash, "new hash")
+		}
+	})
+
+	t.Run("scheduled deletion", func(t *testing.T) {
+		after := time.Now().Add(-time.Minute)
+		got, err := repo.ScheduleDelete(ctx, created.ID, after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.DeleteAfter == nil || got.DeleteAfter.Sub(after).Abs() > time.Millisecond {
+			t.Errorf("got delete after %v, want %s", got.DeleteAfter, after)
+		}
+		due, err := repo.ListDueForDeletion(ctx)
+		if err != nil || !slices.Contains(due, created.ID) {
+			t.Errorf("got %v, %v, want user %d to be due", due, err, created.ID)
+		}
+
+		if _, err := repo.CancelDelete(ctx, created.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.CancelDelete(ctx, created.ID); !errors.Is(err, ErrDeletionNotScheduled) {
+			t.Errorf("got error %v, want %v", err, ErrDeletionNotScheduled)
+		}
+		if due, _ := repo.ListDueForDeletion(ctx); slices.Contains(due, created.ID) {
+			t.Errorf("got %v, want the deletion to be cancelled", due)
+		}
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		got, err := repo.Disable(ctx, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.DisabledAt == nil {
+			t.Fatal("got no disabled time")
+		}
+		if _, err := repo.GetByLogin(ctx, "ada"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error %v, want disabled users not to log in", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		var cleaned []int
+		hooks := []UserCleanupHook{{
+			Name: "test",
+			Fn: func(ctx context.Context, tx *sql.Tx, userID int) error {
+				cleaned = append(cleaned, userID)
+				return nil
+			},
+		}}
+		if _, err := repo.Delete(ctx, created.ID, hooks); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(cleaned, []int{created.ID}) {
+			t.Errorf("got cleanup of %v, want %d", cleaned, created.ID)
+		}
+		if _, err := repo.GetByID(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error %v, want the user to be deleted", err)
+		}
+	})
+}
@@ -2,25 +2,118 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+)
+
+var (
+	// ErrInvalidUser is returned when user input fails validation.
+	ErrInvalidUser = errors.New("invalid user")
 )
 
 type UserClient interface {
 	ReadUser(ctx context.Context, id int) (*User, error)
 }
 
+// Client is the API the auth module exposes to other modules.
+type Client interface {
+	Authenticator
+	// Audit embeds the request details recorded in the audit log in the
+	// request context.
+	Audit(next http.Handler) http.Handler
+	// RegisterUserCleanup registers a hook that removes or anonymises data
+	// owned by a user when the user is deleted.
+	RegisterUserCleanup(name string, fn UserCleanupFunc)
+	// RecordAudit records an action of another module in the audit log,
+	// along with the fields that differ between before and after.
+	RecordAudit(ctx context.Context, action string, targetType string, targetID string, before any, after any)
+
+	CreateUser(ctx context.Context, user *CreateUser) (*User, error)
+	ListUsers(ctx context.Context) ([]*User, error)
+	DisableUser(ctx context.Context, id int) (*User, error)
+	ResetPassword(ctx context.Context, id int, password string) error
+}
+
+// UserCleanupFunc removes or anonymises data owned by a user. It runs in the
+// same transaction as the deletion of the user, and returning an error aborts
+// the deletion.
+type UserCleanupFunc func(ctx context.Context, tx *sql.Tx, userID int) error
+
+// UserCleanupHook is a named UserCleanupFunc.
+type UserCleanupHook struct {
+	Name string
+	Fn   UserCleanupFunc
+}
+
+// CleanupError is returned when a cleanup hook fails while deleting a user.
+type CleanupError struct {
+	Hook string
+	Err  error
+}
+
+func (e *CleanupError) Error() string {
+	return fmt.Sprintf("user cleanup hook %q failed: %v", e.Hook, e.Err)
+}
+
+func (e *CleanupError) Unwrap() error { return e.Err }
+
 // UserService handles business logic for usersvc.
 type UserService struct {
-	repo *UserRepository
+	repo  *UserRepository
+	audit *AuditService
+	// gracePeriod is how long a deleted user can be restored before its
+	// data is purged. Users are purged immediately if it is zero.
+	gracePeriod time.Duration
+
+	mu    sync.RWMutex
+	hooks []UserCleanupHook
 }
 
 // NewUserService creates a new UserService.
-func NewUserService(repo *UserRepository) *UserService {
-	return &UserService{repo: repo}
+func NewUserService(repo *UserRepository, audit *AuditService, gracePeriod time.Duration) *UserService {
+	return &UserService{repo: repo, audit: audit, gracePeriod: gracePeriod}
+}
+
+// RegisterUserCleanup registers a hook that runs when a user is purged.
+func (svc *UserService) RegisterUserCleanup(name string, fn UserCleanupFunc) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.hooks = append(svc.hooks, UserCleanupHook{Name: name, Fn: fn})
 }
 
 // CreateUser registers a new user.
 func (svc *UserService) CreateUser(ctx context.Context, user *CreateUser) (*User, error) {
-	return svc.repo.Create(ctx, user)
+	hash, err := HashPassword(user.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.PasswordHash = hash
+
+	created, err := svc.repo.Create(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	usersRegistered.Inc()
+	// A user registering itself is the actor of its own registration.
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditUserRegister,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(created.ID),
+		ActorID:    &created.ID,
+		Changes:    AuditDiff(nil, created),
+	})
+	return created, nil
 }
 
 // ReadUser fetches by ID.
@@ -33,12 +126,245 @@ func (svc *UserService) ListUsers(ctx context.Context) ([]*User, error) {
 	return svc.repo.List(ctx)
 }
 
-// UpdateUser modifies user data.
+// UpdateUser modifies the supplied fields of a user. Email and username
+// changes are checked against the other users before being applied.
 func (svc *UserService) UpdateUser(ctx context.Context, id int, user *UpdateUser) (*User, error) {
-	return svc.repo.Update(ctx, id, user)
+	if err := validateUpdateUser(user); err != nil {
+		return nil, err
+	}
+
+	if user.Email != nil {
+		taken, err := svc.repo.ExistsByEmail(ctx, *user.Email, id)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrEmailTaken
+		}
+	}
+	if user.Username != nil {
+		taken, err := svc.repo.ExistsByUsername(ctx, *user.Username, id)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrUsernameTaken
+		}
+	}
+
+	before, err := svc.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The unique constraints still guard against concurrent updates racing
+	// past the checks above.
+	after, err := svc.repo.Update(ctx, id, user)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditUserUpdate,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(id),
+		Changes:    AuditDiff(before, after),
+	})
+	return after, nil
 }
 
-// DeleteUser removes a user.
+func validateUpdateUser(user *UpdateUser) error {
+	if user.Email != nil {
+		*user.Email = strings.TrimSpace(*user.Email)
+		if _, err := mail.ParseAddress(*user.Email); err != nil {
+			return fmt.Errorf("%w: email is not a valid address", ErrInvalidUser)
+		}
+	}
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"first_name", user.FirstName},
+		{"last_name", user.LastName},
+		{"username", user.Username},
+	}
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		*f.value = strings.TrimSpace(*f.value)
+		if *f.value == "" {
+			return fmt.Errorf("%w: %s must not be empty", ErrInvalidUser, f.name)
+		}
+	}
+	return nil
+}
+
+// ChangePassword replaces the password of a user, provided that the current
+// password is correct. Every other session of the user is ended, so changing
+// the password after a compromise logs the attacker out, while the caller
+// keeps the session it changed the password from.
+func (svc *UserService) ChangePassword(ctx context.Context, id int, input *ChangePassword) error {
+	user, err := svc.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := CheckPassword(user.PasswordHash, input.CurrentPassword); err != nil {
+		return err
+	}
+	hash, err := HashPassword(input.NewPassword)
+	if err != nil {
+		return err
+	}
+	var keepSession *int
+	if principal, ok := PrincipalFromContext(ctx); ok && principal.UserID == id {
+		keepSession = principal.SessionID
+	}
+	if err := svc.repo.UpdatePasswordHash(ctx, id, hash, keepSession); err != nil {
+		return err
+	}
+
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditUserPasswordChange,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(id),
+	})
+	return nil
+}
+
+// ResetPassword replaces the password of a user without the current
+// password, and ends all of its sessions. It is meant for administrators.
+func (svc *UserService) ResetPassword(ctx context.Context, id int, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := svc.repo.UpdatePasswordHash(ctx, id, hash, nil); err != nil {
+		return err
+	}
+
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditUserPasswordReset,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(id),
+	})
+	return nil
+}
+
+// DisableUser prevents a user from logging in or using its sessions and
+// tokens, without deleting any of its data.
+func (svc *UserService) DisableUser(ctx context.Context, id int) (*User, error) {
+	before, err := svc.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	after, err := svc.repo.Disable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditUserDisable,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(id),
+		Changes:    AuditDiff(before, after),
+	})
+	return after, nil
+}
+
+// DeleteUser schedules a user for deletion after the grace period, during
+// which the deletion can be undone with RestoreUser.
 func (svc *UserService) DeleteUser(ctx context.Context, id int) (*User, error) {
-	return svc.repo.Delete(ctx, id)
+	if svc.gracePeriod <= 0 {
+		return svc.PurgeUser(ctx, id)
+	}
+
+	before, err := svc.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	after, err := svc.repo.ScheduleDelete(ctx, id, time.Now().Add(svc.gracePeriod))
+	if err != nil {
+		return nil, err
+	}
+
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditUserDelete,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(id),
+		Changes:    AuditDiff(before, after),
+	})
+	return after, nil
+}
+
+// RestoreUser cancels a scheduled deletion.
+func (svc *UserService) RestoreUser(ctx context.Context, id int) (*User, error) {
+	before, err := svc.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	after, err := svc.repo.CancelDelete(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditUserRestore,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(id),
+		Changes:    AuditDiff(before, after),
+	})
+	return after, nil
+}
+
+// PurgeUser immediately removes a user and all data owned by it across
+// modules.
+func (svc *UserService) PurgeUser(ctx context.Context, id int) (*User, error) {
+	svc.mu.RLock()
+	hooks := make([]UserCleanupHook, len(svc.hooks))
+	copy(hooks, svc.hooks)
+	svc.mu.RUnlock()
+
+	user, err := svc.repo.Delete(ctx, id, hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.audit.Record(ctx, AuditEntry{
+		Action:     AuditUserPurge,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(id),
+		Changes:    AuditDiff(user, nil),
+	})
+	return user, nil
+}
+
+// PurgeScheduledUsers purges every user whose grace period has passed, and
+// returns the number of purged users. A failure to purge one user does not
+// stop the others from being purged.
+func (svc *UserService) PurgeScheduledUsers(ctx context.Context) (int, error) {
+	logger := logging.LoggerFromContext(ctx)
+
+	ids, err := svc.repo.ListDueForDeletion(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		n    int
+		errs []error
+	)
+	for _, id := range ids {
+		if _, err := svc.PurgeUser(ctx, id); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			logger.Error("failed to purge user", slog.Int("user_id", id), slog.Any("error", err))
+			errs = append(errs, err)
+			continue
+		}
+		logger.Info("purged user", slog.Int("user_id", id))
+		n++
+	}
+	return n, errors.Join(errs...)
 }
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// loginSession logs the user in, and returns its session cookie.
+func (s *testServices) loginSession(t *testing.T, username, password string) *http.Cookie {
+	t.Helper()
+	_, _, raw, err := s.sessions.Login(context.Background(), &Login{Login: username, Password: password}, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: SessionCookieName, Value: raw}
+}
+
+func TestChangePasswordEndsOtherSessions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	m := &Middleware{Tokens: s.tokens, Sessions: s.sessions}
+	userID := s.createUser(t, "ada")
+	current := s.loginSession(t, "ada", "correct horse battery staple")
+	other := s.loginSession(t, "ada", "correct horse battery staple")
+
+	_, principal := authenticate(m, http.MethodGet, "/dashboard", "", current)
+	if principal == nil || principal.SessionID == nil {
+		t.Fatalf("got principal %+v, want a session", principal)
+	}
+	err := s.users.ChangePassword(WithPrincipal(ctx, principal), userID, &ChangePassword{
+		CurrentPassword: "correct horse battery staple",
+		NewPassword:     "a brand new passphrase",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, p := authenticate(m, http.MethodGet, "/dashboard", "", current); p == nil || *p.SessionID != *principal.SessionID {
+		t.Errorf("got principal %+v, want the current session kept", p)
+	}
+	if _, p := authenticate(m, http.MethodGet, "/dashboard", "", other); p != nil {
+		t.Errorf("got principal %+v, want the other session ended", p)
+	}
+}
+
+func TestResetPasswordEndsSessions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	m := &Middleware{Tokens: s.tokens, Sessions: s.sessions}
+	userID := s.createUser(t, "ada")
+	first := s.loginSession(t, "ada", "correct horse battery staple")
+	second := s.loginSession(t, "ada", "correct horse battery staple")
+
+	if err := s.users.ResetPassword(ctx, userID, "a brand new passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range []*http.Cookie{first, second} {
+		if _, principal := authenticate(m, http.MethodGet, "/dashboard", "", cookie); principal != nil {
+			t.Errorf("got principal %+v, want the session ended", principal)
+		}
+	}
+
+	// Only the new password logs in.
+	if _, _, _, err := s.sessions.Login(ctx, &Login{Login: "ada", Password: "correct horse battery staple"}, "10.0.0.1", "test"); err == nil {
+		t.Error("got a login with the old password")
+	}
+	cookie := s.loginSession(t, "ada", "a brand new passphrase")
+	if _, principal := authenticate(m, http.MethodGet, "/dashboard", "", cookie); principal == nil || principal.UserID != userID {
+		t.Errorf("got principal %+v, want user %d", principal, userID)
+	}
+}
+
+func TestDisableUser(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServices(t)
+	m := &Middleware{Tokens: s.tokens, Sessions: s.sessions}
+	userID := s.createUser(t, "ada")
+	cookie := s.loginSession(t, "ada", "correct horse battery staple")
+	created, err := s.tokens.CreateToken(ctx, userID, &CreatePersonalAccessToken{
+		Name:   "ci",
+		Scopes: []Scope{ScopeWorkoutRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer := "Bearer " + created.Token
+
+	if _, principal := authenticate(m, http.MethodGet, "/api/v0/workout/plans", bearer); principal == nil {
+		t.Fatal("got no principal from the token before disabling")
+	}
+
+	disabled, err := s.users.DisableUser(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disabled.DisabledAt == nil {
+		t.Fatal("got no disabled time")
+	}
+
+	if _, principal := authenticate(m, http.MethodGet, "/dashboard", "", cookie); principal != nil {
+		t.Errorf("got principal %+v from the session, want it refused", principal)
+	}
+	w, principal := authenticate(m, http.MethodGet, "/api/v0/workout/plans", bearer)
+	if w.Code != http.StatusUnauthorized || principal != nil {
+		t.Errorf("got status %d and principal %+v from the token, want %d", w.Code, principal, http.StatusUnauthorized)
+	}
+	if _, _, _, err := s.sessions.Login(ctx, &Login{Login: "ada", Password: "correct horse battery staple"}, "10.0.0.1", "test"); err == nil {
+		t.Error("got a login for a disabled user")
+	}
+}
@@ -1,6 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
@@ -14,22 +21,357 @@ const (
 )
 
 type Config struct {
-	App *AppConfig `json:"app"`
+	App      *AppConfig      `json:"app"`
+	Database *DatabaseConfig `json:"database"`
+	Auth     *AuthConfig     `json:"auth"`
+	// Flags are the feature flags, by name. They can be overridden at
+	// runtime by admins.
+	Flags map[string]*FlagConfig `json:"flags"`
+}
+
+// FlagConfig is the default rule of a feature flag. A flag is on for a user
+// if it is enabled, or if the user is targeted by ID, role or rollout
+// percentage.
+type FlagConfig struct {
+	Description string `json:"description"`
+	// Enabled turns the flag on for everyone, including anonymous users.
+	Enabled bool `json:"enabled"`
+	// Percentage turns the flag on for a stable share of the logged in
+	// users, from 0 to 100.
+	Percentage int      `json:"percentage"`
+	Users      []int    `json:"users"`
+	Roles      []string `json:"roles"`
 }
 
 type AppConfig struct {
 	Env     Environment    `json:"env"`
 	Port    int            `json:"port"`
 	Limiter *LimiterConfig `json:"limiter"`
+	// ShutdownTimeout limits how long in-flight requests are given to
+	// complete, and then how long the modules are given to stop.
+	ShutdownTimeout time.Duration `json:"shutdown_timeout" mapstructure:"shutdown_timeout"`
+	// DrainDelay is how long the server keeps serving after the readiness
+	// probe starts failing on shutdown. It should be longer than the probe
+	// interval of the load balancer.
+	DrainDelay time.Duration    `json:"drain_delay" mapstructure:"drain_delay"`
+	Metrics    *MetricsConfig   `json:"metrics"`
+	Tracing    *TracingConfig   `json:"tracing"`
+	AccessLog  *AccessLogConfig `json:"access_log" mapstructure:"access_log"`
+	Debug      *DebugConfig     `json:"debug"`
+	Log        *LogConfig       `json:"log"`
+	Timeouts   *TimeoutsConfig  `json:"timeouts"`
+	TLS        *TLSConfig       `json:"tls"`
+	// HTTP2 serves HTTP/2 along with HTTP/1.1, negotiated over TLS, or as
+	// cleartext HTTP/2 (h2c) when TLS is disabled, e.g. behind a proxy
+	// speaking h2c.
+	HTTP2    bool            `json:"http2"`
+	Security *SecurityConfig `json:"security"`
+}
+
+// SecurityConfig controls the security headers, the CORS policy and the
+// CSRF protection. Empty headers are not sent.
+type SecurityConfig struct {
+	// ContentSecurityPolicy is sent as the Content-Security-Policy header.
+	ContentSecurityPolicy string `json:"content_security_policy" mapstructure:"content_security_policy"`
+	// HSTSMaxAge is sent in the Strict-Transport-Security header of HTTPS
+	// responses. It is not sent if zero.
+	HSTSMaxAge time.Duration `json:"hsts_max_age" mapstructure:"hsts_max_age"`
+	// FrameOptions is sent as the X-Frame-Options header, DENY or
+	// SAMEORIGIN.
+	FrameOptions   string      `json:"frame_options" mapstructure:"frame_options"`
+	ReferrerPolicy string      `json:"referrer_policy" mapstructure:"referrer_policy"`
+	CORS           *CORSConfig `json:"cors"`
+	CSRF           *CSRFConfig `json:"csrf"`
+}
+
+// CORSConfig lets API clients on other origins call the application. They
+// authenticate with bearer tokens, as credentials such as the session cookie
+// are not allowed cross-origin.
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed, e.g. https://example.com,
+	// or * for any. CORS is disabled if empty.
+	AllowedOrigins []string `json:"allowed_origins" mapstructure:"allowed_origins"`
+	AllowedMethods []string `json:"allowed_methods" mapstructure:"allowed_methods"`
+	AllowedHeaders []string `json:"allowed_headers" mapstructure:"allowed_headers"`
+	// MaxAge is how long browsers may cache the result of a preflight.
+	MaxAge time.Duration `json:"max_age" mapstructure:"max_age"`
+}
+
+// CSRFConfig protects the forms of the web pages from cross-site request
+// forgery with a double-submit cookie.
+type CSRFConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// TimeoutsConfig limits how long the server waits on clients. Zero values
+// use the defaults of 5s, 10s and 1m.
+type TimeoutsConfig struct {
+	// Read limits reading a request, including the body.
+	Read time.Duration `json:"read"`
+	// Write limits writing a response, from the end of reading the
+	// request headers.
+	Write time.Duration `json:"write"`
+	// Idle limits how long a keep-alive connection waits for the next
+	// request.
+	Idle time.Duration `json:"idle"`
+}
+
+// TLSConfig serves the application over HTTPS on app.port.
+type TLSConfig struct {
+	Enabled bool `json:"enabled"`
+	// CertFile and KeyFile are PEM files, reloaded when they change. The
+	// certificate file may hold the full chain.
+	CertFile string `json:"cert_file" mapstructure:"cert_file"`
+	KeyFile  string `json:"key_file" mapstructure:"key_file"`
+	// RedirectPort serves a plain HTTP listener redirecting every request
+	// to HTTPS, e.g. on port 80. It is disabled if zero.
+	RedirectPort int `json:"redirect_port" mapstructure:"redirect_port"`
+}
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// LogConfig controls the application logs.
+type LogConfig struct {
+	// Level is the initial minimum level, one of debug, info, warn or
+	// error. Admins can change it while running.
+	Level string `json:"level"`
+	// Format is json or text.
+	Format string `json:"format"`
+	// Output is stdout, stderr, or the path of a file to append to.
+	// Administrative commands log to stderr instead of stdout, so their
+	// output can be piped.
+	Output   string             `json:"output"`
+	Sampling *LogSamplingConfig `json:"sampling"`
+	// Redact lists attribute keys to mask in addition to the built-in
+	// ones, such as password, token and email.
+	Redact []string `json:"redact"`
+}
+
+// LogSamplingConfig limits the number of repeated records logged below the
+// warn level. Within every tick, the first Initial records with the same
+// level and message are logged, and then every Thereafter-th record.
+type LogSamplingConfig struct {
+	Enabled    bool          `json:"enabled"`
+	Initial    int           `json:"initial"`
+	Thereafter int           `json:"thereafter"`
+	Tick       time.Duration `json:"tick"`
 }
 
+// DebugConfig controls the profiling and runtime debug endpoints.
+type DebugConfig struct {
+	// Environments lists the environments the endpoints are enabled in.
+	Environments []Environment `json:"environments"`
+	// Port serves the endpoints on a separate listener bound to localhost,
+	// reachable through e.g. port forwarding. If zero, they are served on
+	// the port of the application to admins only.
+	Port int `json:"port"`
+}
+
+// Enabled reports whether the debug endpoints are enabled in env.
+func (c *DebugConfig) Enabled(env Environment) bool {
+	return c != nil && slices.Contains(c.Environments, env)
+}
+
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+)
+
+// AccessLogConfig controls the access log, which is written in addition to
+// the structured request logs, for tools expecting the format of web
+// servers.
+type AccessLogConfig struct {
+	Enabled bool `json:"enabled"`
+	// Format is common or combined, which adds the referer and user agent.
+	Format string `json:"format"`
+	// Output is stdout, stderr, or the path of a file to append to.
+	Output string `json:"output"`
+}
+
+// MetricsConfig controls the Prometheus metrics endpoint.
+type MetricsConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`
+	// Port serves the metrics on a separate port, so they can be kept from
+	// the public. They are served on the port of the application if zero.
+	Port int `json:"port"`
+}
+
+// LimiterConfig controls the request rate limit, which applies per client IP
+// for anonymous requests, and per user or token for authenticated requests.
 type LimiterConfig struct {
 	RPS     float64 `json:"rps"`
 	Burst   int     `json:"burst"`
 	Enabled bool    `json:"enabled"`
+	// Classes overrides the rate limit of routes declaring a class.
+	Classes map[string]LimiterRate `json:"classes"`
+	// IP limits every IP before authentication, across all classes, so
+	// floods of requests with bad credentials are rejected before they are
+	// looked up. Users behind a shared IP share it, so it should be well
+	// above the default rate. It is not limited by IP if nil.
+	IP *LimiterRate `json:"ip"`
+	// IdleTimeout is how long a client is remembered after its last
+	// request.
+	IdleTimeout time.Duration `json:"idle_timeout" mapstructure:"idle_timeout"`
+}
+
+type LimiterRate struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
 }
 
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterFile   = "file"
+	TracingExporterOTLP   = "otlp"
+)
+
+// TracingConfig controls where OpenTelemetry spans are exported to.
+type TracingConfig struct {
+	// Exporter is one of none, stdout, file or otlp.
+	Exporter string `json:"exporter"`
+	// File is the file spans are appended to with the file exporter.
+	File string `json:"file"`
+	// Endpoint is the host and port of the OTLP/HTTP collector.
+	Endpoint string `json:"endpoint"`
+	// Insecure sends spans to the collector without TLS.
+	Insecure bool `json:"insecure"`
+	// SampleRatio is the share of traces started here that are recorded.
+	// Traces started upstream follow the decision of the caller.
+	SampleRatio float64 `json:"sample_ratio" mapstructure:"sample_ratio"`
+}
+
+// DatabaseConfig holds the connection settings of the database.
+type DatabaseConfig struct {
+	// Driver is "postgres", or "sqlite" to store everything in a single
+	// file. The connection settings below only apply to Postgres.
+	Driver string `json:"driver"`
+	// Path is the database file used by the sqlite driver.
+	Path string `json:"path"`
+
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"-"`
+	Name     string `json:"name"`
+	// SSLMode is one of disable, require, verify-ca or verify-full.
+	SSLMode     string `json:"ssl_mode" mapstructure:"ssl_mode"`
+	SSLRootCert string `json:"ssl_root_cert" mapstructure:"ssl_root_cert"`
+	SSLCert     string `json:"ssl_cert" mapstructure:"ssl_cert"`
+	SSLKey      string `json:"ssl_key" mapstructure:"ssl_key"`
+
+	MaxOpenConns    int           `json:"max_open_conns" mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `json:"max_idle_conns" mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime" mapstructure:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `json:"conn_max_idle_time" mapstructure:"conn_max_idle_time"`
+	// ConnectTimeout limits how long opening a connection may take.
+	ConnectTimeout time.Duration `json:"connect_timeout" mapstructure:"connect_timeout"`
+	// StatementTimeout aborts statements that run for longer. Statements
+	// are not limited if it is zero.
+	StatementTimeout time.Duration `json:"statement_timeout" mapstructure:"statement_timeout"`
+	// Migrations is what the server does on startup when the schema is not
+	// at the version it expects: "check" refuses to start, and "auto"
+	// migrates up, which is only allowed in development.
+	Migrations string `json:"migrations"`
+}
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+const (
+	MigrationsCheck = "check"
+	MigrationsAuto  = "auto"
+)
+
+// LogValue implements slog.LogValuer, and leaves out the password.
+func (c DatabaseConfig) LogValue() slog.Value {
+	if c.Driver == DriverSQLite {
+		return slog.GroupValue(
+			slog.String("driver", c.Driver),
+			slog.String("path", c.Path),
+		)
+	}
+	return slog.GroupValue(
+		slog.String("driver", c.Driver),
+		slog.String("host", c.Host),
+		slog.Int("port", c.Port),
+		slog.String("user", c.User),
+		slog.String("name", c.Name),
+		slog.String("ssl_mode", c.SSLMode),
+	)
+}
+
+type AuthConfig struct {
+	Deletion *DeletionConfig `json:"deletion"`
+	Session  *SessionConfig  `json:"session"`
+	Lockout  *LockoutConfig  `json:"lockout"`
+	OIDC     *OIDCConfig     `json:"oidc"`
+	Audit    *AuditConfig    `json:"audit"`
+}
+
+// AuditConfig controls how long the audit log is kept.
+type AuditConfig struct {
+	// Retention is how long audit records are kept before they are purged.
+	// Records are kept forever if it is zero.
+	Retention time.Duration `json:"retention"`
+}
+
+// OIDCConfig lists the OpenID Connect providers users can log in with.
+type OIDCConfig struct {
+	// RedirectAfterLogin is where the user is sent after a successful login.
+	RedirectAfterLogin string               `json:"redirect_after_login" mapstructure:"redirect_after_login"`
+	Providers          []OIDCProviderConfig `json:"providers"`
+}
+
+type OIDCProviderConfig struct {
+	// Name identifies the provider in the login and callback routes.
+	Name         string `json:"name"`
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id" mapstructure:"client_id"`
+	ClientSecret string `json:"-" mapstructure:"client_secret"`
+	// RedirectURL must point at /api/v0/auth/oidc/{name}/callback.
+	RedirectURL string   `json:"redirect_url" mapstructure:"redirect_url"`
+	Scopes      []string `json:"scopes"`
+}
+
+type SessionConfig struct {
+	// TTL is how long a session lasts after login.
+	TTL time.Duration `json:"ttl"`
+	// SecureCookie marks the session cookie as only to be sent over HTTPS.
+	SecureCookie bool `json:"secure_cookie" mapstructure:"secure_cookie"`
+}
+
+// LockoutConfig controls how failed login attempts lead to lockouts.
+type LockoutConfig struct {
+	AccountThreshold int           `json:"account_threshold" mapstructure:"account_threshold"`
+	IPThreshold      int           `json:"ip_threshold" mapstructure:"ip_threshold"`
+	BaseDelay        time.Duration `json:"base_delay" mapstructure:"base_delay"`
+	MaxDelay         time.Duration `json:"max_delay" mapstructure:"max_delay"`
+	ResetAfter       time.Duration `json:"reset_after" mapstructure:"reset_after"`
+}
+
+// DeletionConfig controls how deleted user accounts are purged.
+type DeletionConfig struct {
+	// GracePeriod is how long a deleted user can be restored before all of
+	// its data is purged. Users are purged immediately if it is zero.
+	GracePeriod time.Duration `json:"grace_period" mapstructure:"grace_period"`
+	// PurgeInterval is how often users past their grace period are purged.
+	PurgeInterval time.Duration `json:"purge_interval" mapstructure:"purge_interval"`
+}
+
+// New loads the config from config.yaml, with overrides from environment
+// variables named after the keys, e.g. DATABASE_HOST for database.host.
+//
+// Secrets can be loaded from files instead, by pointing the variable with a
+// _FILE suffix at the file, e.g. DATABASE_PASSWORD_FILE.
 func New() (*Config, error) {
+	viper.SetEnvKeyReplacer(envKeyReplacer)
 	viper.AutomaticEnv()
 	viper.AllowEmptyEnv(false)
 	viper.SetConfigName("config")
@@ -42,11 +384,41 @@ func New() (*Config, error) {
 		return nil, err
 	}
 
+	err = loadSecretFiles()
+	if err != nil {
+		return nil, err
+	}
+
 	var config Config
 	err = viper.Unmarshal(&config)
 	if err != nil {
 		return nil, err
 	}
 
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
+
+var envKeyReplacer = strings.NewReplacer(".", "_")
+
+// loadSecretFiles overrides every key that has a matching _FILE environment
+// variable with the contents of that file.
+func loadSecretFiles() error {
+	for _, key := range viper.AllKeys() {
+		env := strings.ToUpper(envKeyReplacer.Replace(key)) + "_FILE"
+		path, ok := os.LookupEnv(env)
+		if !ok || path == "" {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s from %s: %w", key, env, err)
+		}
+		viper.Set(key, strings.TrimRight(string(b), "\r\n"))
+	}
+	return nil
+}
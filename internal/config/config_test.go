@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// newConfig loads the config from config.yaml in this directory, and resets
+// the global viper state afterwards.
+func newConfig(t *testing.T) (*Config, error) {
+	t.Helper()
+	t.Cleanup(viper.Reset)
+	return New()
+}
+
+func TestNewDefaults(t *testing.T) {
+	c, err := newConfig(t)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if c.App.Env != DevelopmentEnvironment {
+		t.Errorf("got env %q, want %q", c.App.Env, DevelopmentEnvironment)
+	}
+	if c.Database.Host != "localhost" {
+		t.Errorf("got database host %q, want localhost", c.Database.Host)
+	}
+}
+
+func TestNewEnvOverrides(t *testing.T) {
+	t.Setenv("DATABASE_HOST", "db.internal")
+	t.Setenv("APP_PORT", "8080")
+
+	c, err := newConfig(t)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if c.Database.Host != "db.internal" {
+		t.Errorf("got database host %q, want db.internal", c.Database.Host)
+	}
+	if c.App.Port != 8080 {
+		t.Errorf("got port %d, want 8080", c.App.Port)
+	}
+}
+
+func TestNewSecretFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DATABASE_PASSWORD", "from-env")
+	t.Setenv("DATABASE_PASSWORD_FILE", path)
+
+	c, err := newConfig(t)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if c.Database.Password != "s3cret" {
+		t.Errorf("got password %q, want s3cret", c.Database.Password)
+	}
+}
+
+func TestNewMissingSecretFile(t *testing.T) {
+	t.Setenv("DATABASE_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	_, err := newConfig(t)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got error %v, want %v", err, os.ErrNotExist)
+	}
+	if !strings.Contains(err.Error(), "DATABASE_PASSWORD_FILE") {
+		t.Errorf("got error %q, want it to name DATABASE_PASSWORD_FILE", err)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	t.Setenv("DATABASE_PORT", "0")
+	t.Setenv("DATABASE_SSL_MODE", "sometimes")
+
+	_, err := newConfig(t)
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidConfig)
+	}
+	for _, want := range []string{"database.port", "database.ssl_mode"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %q, want it to mention %s", err, want)
+		}
+	}
+}
+
+func TestDatabaseConfigValidate(t *testing.T) {
+	valid := func() *DatabaseConfig {
+		return &DatabaseConfig{
+			Driver:     DriverPostgres,
+			Host:       "localhost",
+			Port:       5432,
+			User:       "smartsplit",
+			Name:       "smartsplit",
+			SSLMode:    "disable",
+			Migrations: MigrationsCheck,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(c *DatabaseConfig)
+		wantErr string
+	}{
+		{name: "valid", change: func(c *DatabaseConfig) {}},
+		{name: "sqlite", change: func(c *DatabaseConfig) {
+			*c = DatabaseConfig{Driver: DriverSQLite, Path: "x.db", Migrations: MigrationsAuto}
+		}},
+		{name: "sqlite without path", change: func(c *DatabaseConfig) { c.Driver = DriverSQLite }, wantErr: "database.path"},
+		{name: "unknown driver", change: func(c *DatabaseConfig) { c.Driver = "mysql" }, wantErr: "database.driver"},
+		{name: "missing host", change: func(c *DatabaseConfig) { c.Host = "" }, wantErr: "database.host"},
+		{name: "port out of range", change: func(c *DatabaseConfig) { c.Port = 70000 }, wantErr: "database.port"},
+		{name: "bad ssl mode", change: func(c *DatabaseConfig) { c.SSLMode = "on" }, wantErr: "database.ssl_mode"},
+		{
+			name:    "more idle than open conns",
+			change:  func(c *DatabaseConfig) { c.MaxOpenConns, c.MaxIdleConns = 2, 5 },
+			wantErr: "database.max_idle_conns",
+		},
+		{name: "bad migrations", change: func(c *DatabaseConfig) { c.Migrations = "never" }, wantErr: "database.migrations"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(c)
+			errs := c.validate()
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Fatalf("got errors %v, want none", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Fatalf("got errors %v, want one about %s", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDatabaseConfigLogValue(t *testing.T) {
+	c := DatabaseConfig{
+		Driver:   DriverPostgres,
+		Host:     "localhost",
+		Port:     5432,
+		User:     "smartsplit",
+		Password: "s3cret",
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("config", "database", c)
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("got %q, want the password left out", buf.String())
+	}
+	if !strings.Contains(buf.String(), "database.host=localhost") {
+		t.Errorf("got %q, want the host logged", buf.String())
+	}
+}
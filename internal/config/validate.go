@@ -0,0 +1,241 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidConfig is returned when the loaded config has bad or missing
+// values.
+var ErrInvalidConfig = errors.New("invalid config")
+
+// flagNameRe matches the names of feature flags. Dots are not allowed, as
+// they separate the keys of the config.
+var flagNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// Validate checks the config for bad or missing values, and reports all of
+// them at once.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.App == nil {
+		errs = append(errs, errors.New("app is missing"))
+	} else {
+		if l := c.App.Limiter; l != nil && l.Enabled {
+			errs = append(errs, l.validate()...)
+		}
+		if m := c.App.Metrics; m != nil && m.Enabled {
+			errs = append(errs, m.validate(c.App.Port)...)
+		}
+		if t := c.App.Tracing; t != nil {
+			errs = append(errs, t.validate()...)
+		}
+		if d := c.App.Debug; d != nil && (d.Port < 0 || d.Port > 65535 || (d.Port != 0 && d.Port == c.App.Port)) {
+			errs = append(errs, fmt.Errorf("app.debug.port must be 0, or a port other than app.port, got %d", d.Port))
+		}
+		if t := c.App.TLS; t != nil && t.Enabled {
+			errs = append(errs, t.validate(c.App.Port)...)
+		}
+		if t := c.App.Timeouts; t != nil && (t.Read < 0 || t.Write < 0 || t.Idle < 0) {
+			errs = append(errs, errors.New("app.timeouts must not be negative"))
+		}
+		if l := c.App.Log; l != nil {
+			errs = append(errs, l.validate()...)
+		}
+		if a := c.App.AccessLog; a != nil && a.Enabled && a.Format != AccessLogCommon && a.Format != AccessLogCombined {
+			errs = append(errs, fmt.Errorf("app.access_log.format must be common or combined, got %q", a.Format))
+		}
+		if s := c.App.Security; s != nil {
+			errs = append(errs, s.validate()...)
+		}
+	}
+	for name, f := range c.Flags {
+		if !flagNameRe.MatchString(name) {
+			errs = append(errs, fmt.Errorf("flags.%s: name must be lowercase letters, digits and underscores", name))
+		}
+		if f != nil && (f.Percentage < 0 || f.Percentage > 100) {
+			errs = append(errs, fmt.Errorf("flags.%s.percentage must be between 0 and 100, got %d", name, f.Percentage))
+		}
+	}
+	if c.Auth == nil {
+		errs = append(errs, errors.New("auth is missing"))
+	}
+	if c.Database == nil {
+		errs = append(errs, errors.New("database is missing"))
+	} else {
+		errs = append(errs, c.Database.validate()...)
+		if c.Database.Migrations == MigrationsAuto && c.App != nil && c.App.Env != DevelopmentEnvironment {
+			errs = append(errs, fmt.Errorf("database.migrations can only be %q in development", MigrationsAuto))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (c *LimiterConfig) validate() []error {
+	var errs []error
+	if c.RPS <= 0 || c.Burst < 1 {
+		errs = append(errs, errors.New("app.limiter.rps and app.limiter.burst must be positive"))
+	}
+	for name, class := range c.Classes {
+		if class.RPS <= 0 || class.Burst < 1 {
+			errs = append(errs, fmt.Errorf("app.limiter.classes.%s.rps and burst must be positive", name))
+		}
+	}
+	if c.IP != nil && (c.IP.RPS <= 0 || c.IP.Burst < 1) {
+		errs = append(errs, errors.New("app.limiter.ip.rps and app.limiter.ip.burst must be positive"))
+	}
+	return errs
+}
+
+func (c *MetricsConfig) validate(appPort int) []error {
+	var errs []error
+	if !strings.HasPrefix(c.Path, "/") {
+		errs = append(errs, fmt.Errorf("app.metrics.path must start with /, got %q", c.Path))
+	}
+	if c.Port < 0 || c.Port > 65535 || (c.Port != 0 && c.Port == appPort) {
+		errs = append(errs, fmt.Errorf("app.metrics.port must be 0, or a port other than app.port, got %d", c.Port))
+	}
+	return errs
+}
+
+func (c *TracingConfig) validate() []error {
+	var errs []error
+	switch c.Exporter {
+	case TracingExporterNone, TracingExporterStdout:
+	case TracingExporterFile:
+		if c.File == "" {
+			errs = append(errs, errors.New("app.tracing.file is required with the file exporter"))
+		}
+	case TracingExporterOTLP:
+		if c.Endpoint == "" {
+			errs = append(errs, errors.New("app.tracing.endpoint is required with the otlp exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("app.tracing.exporter must be one of none, stdout, file or otlp, got %q", c.Exporter))
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("app.tracing.sample_ratio must be between 0 and 1, got %v", c.SampleRatio))
+	}
+	return errs
+}
+
+func (c *DatabaseConfig) validate() []error {
+	var errs []error
+	switch c.Driver {
+	case DriverPostgres:
+		errs = append(errs, c.validatePostgres()...)
+	case DriverSQLite:
+		if c.Path == "" {
+			errs = append(errs, errors.New("database.path is required for the sqlite driver"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("database.driver must be %q or %q, got %q", DriverPostgres, DriverSQLite, c.Driver))
+	}
+	if c.MaxOpenConns < 0 {
+		errs = append(errs, errors.New("database.max_open_conns must not be negative"))
+	}
+	if c.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database.max_idle_conns must not be negative"))
+	}
+	if c.MaxOpenConns > 0 && c.MaxIdleConns > c.MaxOpenConns {
+		errs = append(errs, fmt.Errorf(
+			"database.max_idle_conns (%d) must not exceed database.max_open_conns (%d)",
+			c.MaxIdleConns, c.MaxOpenConns,
+		))
+	}
+	if c.ConnMaxLifetime < 0 || c.ConnMaxIdleTime < 0 {
+		errs = append(errs, errors.New("database connection lifetimes must not be negative"))
+	}
+	if c.Migrations != MigrationsCheck && c.Migrations != MigrationsAuto {
+		errs = append(errs, fmt.Errorf("database.migrations must be %q or %q, got %q", MigrationsCheck, MigrationsAuto, c.Migrations))
+	}
+	return errs
+}
+
+func (c *DatabaseConfig) validatePostgres() []error {
+	var errs []error
+	if c.Host == "" {
+		errs = append(errs, errors.New("database.host is required"))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("database.port must be between 1 and 65535, got %d", c.Port))
+	}
+	if c.User == "" {
+		errs = append(errs, errors.New("database.user is required"))
+	}
+	if c.Name == "" {
+		errs = append(errs, errors.New("database.name is required"))
+	}
+	if !slices.Contains(sslModes, c.SSLMode) {
+		errs = append(errs, fmt.Errorf("database.ssl_mode must be one of %v, got %q", sslModes, c.SSLMode))
+	}
+	if c.ConnectTimeout < 0 {
+		errs = append(errs, errors.New("database.connect_timeout must not be negative"))
+	}
+	if c.StatementTimeout < 0 {
+		errs = append(errs, errors.New("database.statement_timeout must not be negative"))
+	}
+	return errs
+}
+
+func (c *LogConfig) validate() []error {
+	var errs []error
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); c.Level != "" && err != nil {
+		errs = append(errs, fmt.Errorf("app.log.level must be one of debug, info, warn or error, got %q", c.Level))
+	}
+	if c.Format != "" && c.Format != LogFormatJSON && c.Format != LogFormatText {
+		errs = append(errs, fmt.Errorf("app.log.format must be json or text, got %q", c.Format))
+	}
+	if s := c.Sampling; s != nil && s.Enabled && (s.Initial < 1 || s.Thereafter < 0 || s.Tick <= 0) {
+		errs = append(errs, errors.New("app.log.sampling.initial and tick must be positive, and thereafter not negative"))
+	}
+	return errs
+}
+
+func (c *TLSConfig) validate(appPort int) []error {
+	var errs []error
+	if c.CertFile == "" || c.KeyFile == "" {
+		errs = append(errs, errors.New("app.tls.cert_file and app.tls.key_file are required when TLS is enabled"))
+	}
+	if c.RedirectPort < 0 || c.RedirectPort > 65535 || (c.RedirectPort != 0 && c.RedirectPort == appPort) {
+		errs = append(errs, fmt.Errorf("app.tls.redirect_port must be 0, or a port other than app.port, got %d", c.RedirectPort))
+	}
+	return errs
+}
+
+func (c *SecurityConfig) validate() []error {
+	var errs []error
+	if c.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("app.security.hsts_max_age must not be negative"))
+	}
+	switch c.FrameOptions {
+	case "", "DENY", "SAMEORIGIN":
+	default:
+		errs = append(errs, fmt.Errorf("app.security.frame_options must be DENY or SAMEORIGIN, got %q", c.FrameOptions))
+	}
+	if cors := c.CORS; cors != nil {
+		for _, origin := range cors.AllowedOrigins {
+			if origin == "*" {
+				continue
+			}
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+				errs = append(errs, fmt.Errorf("app.security.cors.allowed_origins must be * or a scheme and host, got %q", origin))
+			}
+		}
+		if cors.MaxAge < 0 {
+			errs = append(errs, errors.New("app.security.cors.max_age must not be negative"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimiterConfigValidate(t *testing.T) {
+	valid := func() *LimiterConfig {
+		return &LimiterConfig{
+			RPS:     10,
+			Burst:   20,
+			Enabled: true,
+			Classes: map[string]LimiterRate{"auth": {RPS: 0.2, Burst: 10}},
+			IP:      &LimiterRate{RPS: 30, Burst: 60},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(c *LimiterConfig)
+		wantErr string
+	}{
+		{name: "valid", change: func(c *LimiterConfig) {}},
+		{name: "without classes or ip", change: func(c *LimiterConfig) { c.Classes, c.IP = nil, nil }},
+		{name: "zero rps", change: func(c *LimiterConfig) { c.RPS = 0 }, wantErr: "app.limiter.rps"},
+		{name: "negative rps", change: func(c *LimiterConfig) { c.RPS = -1 }, wantErr: "app.limiter.rps"},
+		{name: "zero burst", change: func(c *LimiterConfig) { c.Burst = 0 }, wantErr: "app.limiter.rps"},
+		{
+			name:    "zero class rps",
+			change:  func(c *LimiterConfig) { c.Classes["auth"] = LimiterRate{Burst: 10} },
+			wantErr: "app.limiter.classes.auth",
+		},
+		{
+			name:    "zero class burst",
+			change:  func(c *LimiterConfig) { c.Classes["auth"] = LimiterRate{RPS: 1} },
+			wantErr: "app.limiter.classes.auth",
+		},
+		{name: "zero ip rps", change: func(c *LimiterConfig) { c.IP.RPS = 0 }, wantErr: "app.limiter.ip"},
+		{name: "zero ip burst", change: func(c *LimiterConfig) { c.IP.Burst = 0 }, wantErr: "app.limiter.ip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(c)
+			errs := c.validate()
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Fatalf("got errors %v, want none", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Fatalf("got errors %v, want one about %s", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateChecksEnabledLimiter(t *testing.T) {
+	c := &Config{App: &AppConfig{Limiter: &LimiterConfig{Enabled: true}}}
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "app.limiter.rps") {
+		t.Fatalf("got error %v, want the limiter rates to be checked", err)
+	}
+}
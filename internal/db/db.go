@@ -1,39 +1,85 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
 
 	_ "github.com/lib/pq"
-)
 
-type PostgresDB struct {
-	Host     string `json:"host,omitempty"`
-	Port     int    `json:"port,omitempty"`
-	User     string `json:"user,omitempty"`
-	Password string `json:"-"`
-	Database string `json:"database,omitempty"`
-	SSLMode  string `json:"ssl_mode,omitempty"`
-}
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
 
 var (
 	ErrUnableToCreateDB = errors.New("unable to create db connection")
 	ErrDBUnreachable    = errors.New("database is unreachable")
 )
 
-func NewDB(cred PostgresDB) (*sql.DB, error) {
-	var err error
-	dsn := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cred.Host, cred.Port, cred.User, cred.Password, cred.Database, cred.SSLMode,
-	)
-	db, err := sql.Open("postgres", dsn)
+// dsn builds the connection string for the Postgres driver. It contains the
+// password, so it must never be logged.
+func dsn(cfg *config.DatabaseConfig) string {
+	params := url.Values{}
+	params.Set("sslmode", cfg.SSLMode)
+	if cfg.SSLRootCert != "" {
+		params.Set("sslrootcert", cfg.SSLRootCert)
+	}
+	if cfg.SSLCert != "" {
+		params.Set("sslcert", cfg.SSLCert)
+	}
+	if cfg.SSLKey != "" {
+		params.Set("sslkey", cfg.SSLKey)
+	}
+	if cfg.ConnectTimeout > 0 {
+		// The driver only accepts whole seconds.
+		secs := int(math.Ceil(cfg.ConnectTimeout.Seconds()))
+		params.Set("connect_timeout", strconv.Itoa(secs))
+	}
+	if cfg.StatementTimeout > 0 {
+		params.Set("statement_timeout", strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10))
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Path:     "/" + cfg.Name,
+		RawQuery: params.Encode(),
+	}
+	return u.String()
+}
+
+// NewDB opens a connection pool to the database and checks that it can be
+// reached within the connect timeout.
+func NewDB(cfg *config.DatabaseConfig) (*sql.DB, error) {
+	if Dialect(cfg.Driver) == SQLite {
+		return newSQLite(cfg)
+	}
+
+	db, err := sql.Open("postgres", dsn(cfg))
 	if err != nil {
-		return nil, ErrUnableToCreateDB
+		return nil, fmt.Errorf("%w: %v", ErrUnableToCreateDB, err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	timeout := cfg.ConnectTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
 	}
-	err = db.Ping()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = db.PingContext(ctx)
 	if err != nil {
+		db.Close()
 		return nil, fmt.Errorf("%w: %v", ErrDBUnreachable, err)
 	}
 	return db, nil
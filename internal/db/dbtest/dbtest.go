@@ -0,0 +1,40 @@
+// Package dbtest provides databases for tests.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/db/migrations"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/db"
+)
+
+// NewSQLite returns a migrated SQLite database in a temporary directory,
+// which is closed when the test ends. The migrations seed an admin user with
+// ID 1 and the muscle catalog.
+func NewSQLite(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	sqlDB, err := db.NewDB(&config.DatabaseConfig{
+		Driver:       config.DriverSQLite,
+		Path:         filepath.Join(tb.TempDir(), "test.db"),
+		MaxOpenConns: 4,
+		MaxIdleConns: 4,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { sqlDB.Close() })
+
+	migrator, err := db.NewMigrator(sqlDB, db.SQLite, migrations.SQLite)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		tb.Fatal(err)
+	}
+	return sqlDB
+}
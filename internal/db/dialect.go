@@ -0,0 +1,225 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect is the SQL dialect of a database. Queries are written for
+// Postgres, and rewritten by Rebind for the other dialects. Queries that
+// cannot be rewritten are written for each dialect by the repositories.
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// Querier is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// schemas are the Postgres schemas of the application. SQLite has no
+// schemas, so their tables are prefixed with the schema name instead, e.g.
+// auth.users is auth_users.
+var schemas = []string{"auth", "events", "flags", "scheduler", "workout"}
+
+var (
+	limitRe       = regexp.MustCompile(`LIMIT \$(\d+)`)
+	anyRe         = regexp.MustCompile(`= ANY\(\$(\d+)\)`)
+	placeholderRe = regexp.MustCompile(`\$(\d+)`)
+	schemaRe      = regexp.MustCompile(`\b(` + strings.Join(schemas, "|") + `)\.(\w+)`)
+	castRe        = regexp.MustCompile(`::(double precision|\w+)`)
+	lockingRe     = regexp.MustCompile(`\s+FOR UPDATE( SKIP LOCKED)?`)
+)
+
+// rebound caches the rewritten queries, as they are constants.
+var rebound sync.Map
+
+// Rebind rewrites a query written for Postgres to the dialect. For SQLite,
+// numbered placeholders are kept in place, schemas are folded into the table
+// names, and casts and row locks are dropped, as SQLite is typeless and
+// locks the whole database. A NULL limit means no limit on Postgres, but is
+// an error on SQLite, where it is -1. Arrays bound with Array are read with
+// json_each. String literals, quoted identifiers and comments are left
+// alone.
+func (d Dialect) Rebind(query string) string {
+	if d != SQLite {
+		return query
+	}
+	if q, ok := rebound.Load(query); ok {
+		return q.(string)
+	}
+
+	var b strings.Builder
+	for i, part := range splitQuoted(query) {
+		if i%2 == 1 {
+			b.WriteString(part)
+			continue
+		}
+		part = limitRe.ReplaceAllString(part, "LIMIT coalesce($$$1, -1)")
+		part = anyRe.ReplaceAllString(part, "IN (SELECT value FROM json_each($$$1))")
+		part = placeholderRe.ReplaceAllString(part, "?$1")
+		part = schemaRe.ReplaceAllString(part, "${1}_$2")
+		part = castRe.ReplaceAllString(part, "")
+		part = lockingRe.ReplaceAllString(part, "")
+		b.WriteString(part)
+	}
+	rebound.Store(query, b.String())
+	return b.String()
+}
+
+// dollarTagRe matches the opening tag of a dollar quoted string, which
+// cannot be confused with a placeholder, as tags do not start with a digit.
+var dollarTagRe = regexp.MustCompile(`^\$([A-Za-z_]\w*)?\$`)
+
+// splitQuoted splits a query into SQL at the even indices, and the quoted
+// parts in between at the odd ones: string literals, where quotes are
+// escaped by doubling them, or with a backslash in escape strings such as
+// E'\n', dollar quoted strings, quoted identifiers and comments. A part
+// that is not closed runs to the end.
+func splitQuoted(query string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(query); {
+		end := -1
+		switch c := query[i]; {
+		case c == '\'':
+			escapes := i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') && (i == 1 || !isWordByte(query[i-2]))
+			end = quotedEnd(query, i, '\'', escapes)
+		case c == '"':
+			end = quotedEnd(query, i, '"', false)
+		case strings.HasPrefix(query[i:], "--"):
+			end = len(query)
+			if n := strings.IndexByte(query[i:], '\n'); n >= 0 {
+				end = i + n + 1
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			end = len(query)
+			if n := strings.Index(query[i+2:], "*/"); n >= 0 {
+				end = i + 2 + n + 2
+			}
+		case c == '$' && (i == 0 || !isWordByte(query[i-1])):
+			if tag := dollarTagRe.FindString(query[i:]); tag != "" {
+				end = len(query)
+				if n := strings.Index(query[i+len(tag):], tag); n >= 0 {
+					end = i + len(tag) + n + len(tag)
+				}
+			}
+		}
+		if end < 0 {
+			i++
+			continue
+		}
+		parts = append(parts, query[start:i], query[i:end])
+		i, start = end, end
+	}
+	return append(parts, query[start:])
+}
+
+// quotedEnd returns the index after the quote closing the part opened at
+// start. A doubled quote is an escaped one, as is a quote after a backslash
+// if escapes is set.
+func quotedEnd(query string, start int, quote byte, escapes bool) int {
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// Array binds a slice to a parameter compared with = ANY($N). SQLite has no
+// arrays, so the slice is bound as JSON there.
+func Array[T ~int | ~int64 | ~string](d Dialect, v []T) any {
+	if d != SQLite {
+		return pq.Array(v)
+	}
+	// Slices of numbers and strings always marshal.
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Wrap returns a querier rewriting the queries it runs to the dialect.
+func (d Dialect) Wrap(q Querier) Querier {
+	if d != SQLite {
+		return q
+	}
+	return &rebinder{q: q, dialect: d}
+}
+
+type rebinder struct {
+	q       Querier
+	dialect Dialect
+}
+
+func (r *rebinder) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.q.ExecContext(ctx, r.dialect.Rebind(query), utcArgs(args)...)
+}
+
+func (r *rebinder) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return r.q.QueryContext(ctx, r.dialect.Rebind(query), utcArgs(args)...)
+}
+
+func (r *rebinder) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return r.q.QueryRowContext(ctx, r.dialect.Rebind(query), utcArgs(args)...)
+}
+
+// utcArgs converts times to UTC. SQLite stores times as text in the zone
+// they are given in, so they only compare correctly in the same zone.
+func utcArgs(args []any) []any {
+	out := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			out[i] = v.UTC()
+		case *time.Time:
+			if v != nil {
+				out[i] = v.UTC()
+			}
+		default:
+			out[i] = arg
+		}
+	}
+	return out
+}
+
+// UniqueViolation reports whether err is a unique constraint violation, and
+// returns what was violated: the constraint name on Postgres, and the
+// table and columns on SQLite, e.g. "auth_users.email".
+func UniqueViolation(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return pqErr.Constraint, true
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return strings.TrimPrefix(sqliteErr.Error(), "UNIQUE constraint failed: "), true
+	}
+	return "", false
+}
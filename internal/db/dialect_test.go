@@ -0,0 +1,102 @@
+package db
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "placeholders",
+			query: "SELECT id FROM plans WHERE user_id = $1 AND id = $2",
+			want:  "SELECT id FROM plans WHERE user_id = ?1 AND id = ?2",
+		},
+		{
+			name:  "schemas",
+			query: "SELECT u.id FROM auth.users u JOIN workout.plans p ON p.user_id = u.id",
+			want:  "SELECT u.id FROM auth_users u JOIN workout_plans p ON p.user_id = u.id",
+		},
+		{
+			name:  "limit",
+			query: "SELECT id FROM workout.plans LIMIT $1 OFFSET $2",
+			want:  "SELECT id FROM workout_plans LIMIT coalesce(?1, -1) OFFSET ?2",
+		},
+		{
+			name:  "any",
+			query: "SELECT id FROM workout.muscles WHERE id = ANY($1)",
+			want:  "SELECT id FROM workout_muscles WHERE id IN (SELECT value FROM json_each(?1))",
+		},
+		{
+			name:  "casts",
+			query: "SELECT $1::int, avg(sets)::double precision FROM workout.plan_entries",
+			want:  "SELECT ?1, avg(sets) FROM workout_plan_entries",
+		},
+		{
+			name:  "row locks",
+			query: "SELECT id FROM events.outbox WHERE published_at IS NULL FOR UPDATE SKIP LOCKED",
+			want:  "SELECT id FROM events_outbox WHERE published_at IS NULL",
+		},
+		{
+			name:  "string literal",
+			query: "SELECT 'auth.users $1::text' FROM auth.users WHERE id = $1",
+			want:  "SELECT 'auth.users $1::text' FROM auth_users WHERE id = ?1",
+		},
+		{
+			name:  "doubled quote",
+			query: "SELECT 'it''s $1' FROM auth.users WHERE id = $1",
+			want:  "SELECT 'it''s $1' FROM auth_users WHERE id = ?1",
+		},
+		{
+			name:  "escape string",
+			query: `SELECT E'it\'s $1', e'\\' FROM auth.users WHERE id = $1`,
+			want:  `SELECT E'it\'s $1', e'\\' FROM auth_users WHERE id = ?1`,
+		},
+		{
+			name:  "backslash in standard string",
+			query: `SELECT 'C:\' FROM auth.users WHERE id = $1`,
+			want:  `SELECT 'C:\' FROM auth_users WHERE id = ?1`,
+		},
+		{
+			name:  "dollar quoted",
+			query: "SELECT $$it's $1$$, $fn$ auth.users $fn$ FROM auth.users WHERE id = $1",
+			want:  "SELECT $$it's $1$$, $fn$ auth.users $fn$ FROM auth_users WHERE id = ?1",
+		},
+		{
+			name:  "quoted identifier",
+			query: `SELECT id AS "user's $1" FROM auth.users WHERE id = $1`,
+			want:  `SELECT id AS "user's $1" FROM auth_users WHERE id = ?1`,
+		},
+		{
+			name:  "line comment",
+			query: "SELECT id -- the user's $1\nFROM auth.users WHERE id = $1",
+			want:  "SELECT id -- the user's $1\nFROM auth_users WHERE id = ?1",
+		},
+		{
+			name:  "block comment",
+			query: "SELECT id /* don't $1 */ FROM auth.users WHERE id = $1",
+			want:  "SELECT id /* don't $1 */ FROM auth_users WHERE id = ?1",
+		},
+		{
+			name:  "unterminated literal",
+			query: "SELECT id FROM auth.users WHERE name = 'a $1",
+			want:  "SELECT id FROM auth_users WHERE name = 'a $1",
+		},
+		{
+			name:  "word ending in e",
+			query: "SELECT id FROM workout.muscles WHERE name = '\\' AND id = $1",
+			want:  "SELECT id FROM workout_muscles WHERE name = '\\' AND id = ?1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SQLite.Rebind(tt.query); got != tt.want {
+				t.Errorf("got\n\t%s\nwant\n\t%s", got, tt.want)
+			}
+			if got := Postgres.Rebind(tt.query); got != tt.query {
+				t.Errorf("Postgres query was rewritten to %s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
+
+// listenerPingInterval is how often an idle listener checks its connection,
+// as a dead connection is otherwise only noticed when sending on it.
+const listenerPingInterval = time.Minute
+
+// Listen calls fn on every notification on a Postgres channel until ctx is
+// done. It listens on a dedicated connection, reconnecting if it is lost.
+// Notifications sent while disconnected are lost, so fn is also called after
+// reconnecting.
+func Listen(ctx context.Context, cfg *config.DatabaseConfig, channel string, logger *slog.Logger, fn func()) error {
+	listener := pq.NewListener(dsn(cfg), time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+			logger.Warn("database listener disconnected", "channel", channel, "error", err)
+		case pq.ListenerEventReconnected:
+			logger.Info("database listener reconnected", "channel", channel)
+		}
+	})
+	defer listener.Close()
+	// Listen waits for the first connection, and returns once the listener
+	// is closed.
+	defer context.AfterFunc(ctx, func() { listener.Close() })()
+
+	if err := listener.Listen(channel); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-listener.Notify:
+			// A nil notification is sent after reconnecting.
+			fn()
+		case <-ticker.C:
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+var (
+	// ErrDirtyDatabase is returned when a previous migration failed halfway,
+	// and the schema must be fixed by hand before migrating again.
+	ErrDirtyDatabase = errors.New("database is dirty")
+	// ErrSchemaMismatch is returned when the schema version differs from the
+	// version the application expects.
+	ErrSchemaMismatch = errors.New("schema version mismatch")
+	// ErrUnknownVersion is returned when migrating to a version that has no
+	// migration.
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+// migrationLockID is the key of the advisory lock held while migrating, so
+// only one instance migrates at a time.
+const migrationLockID int64 = 0x736d7370_6c6974 // "smsplit"
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a pair of up and down scripts for a schema version.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus describes the state of the schema.
+type MigrationStatus struct {
+	// Version is the applied version, or zero if nothing is applied.
+	Version uint64
+	Dirty   bool
+	// Latest is the version of the newest migration.
+	Latest  uint64
+	Pending []*Migration
+}
+
+// Migrator applies migrations. The applied version is tracked in the same
+// schema_migrations table as golang-migrate uses, so databases migrated with
+// its CLI can be taken over.
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []*Migration
+}
+
+// NewMigrator reads the migrations from fsys, which must be written for the
+// dialect.
+func NewMigrator(db *sql.DB, dialect Dialect, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint64]*Migration{}
+	for _, e := range entries {
+		match := migrationFileRe.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", e.Name())
+		}
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has mismatched names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	slices.SortFunc(migrations, func(a, b *Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Latest returns the version of the newest migration.
+func (m *Migrator) Latest() uint64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status returns the applied version and the pending migrations.
+func (m *Migrator) Status(ctx context.Context) (*MigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := ensureVersionTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	version, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MigrationStatus{Version: version, Dirty: dirty, Latest: m.Latest()}
+	for _, mig := range m.migrations {
+		if mig.Version > version {
+			status.Pending = append(status.Pending, mig)
+		}
+	}
+	return status, nil
+}
+
+// Check returns ErrSchemaMismatch unless the schema is at the latest
+// version.
+func (m *Migrator) Check(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Dirty {
+		return fmt.Errorf("%w at version %d", ErrDirtyDatabase, status.Version)
+	}
+	if status.Version != status.Latest {
+		return fmt.Errorf("%w: database is at version %d, expected %d", ErrSchemaMismatch, status.Version, status.Latest)
+	}
+	return nil
+}
+
+// Ready returns ErrSchemaMismatch if the schema is behind the latest
+// version. Unlike Check, it accepts a newer schema, as a rolling deploy
+// migrates up while the older instances are still serving.
+func (m *Migrator) Ready(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Dirty {
+		return fmt.Errorf("%w at version %d", ErrDirtyDatabase, status.Version)
+	}
+	if status.Version < status.Latest {
+		return fmt.Errorf("%w: database is at version %d, expected at least %d", ErrSchemaMismatch, status.Version, status.Latest)
+	}
+	return nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down rolls back the given number of migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	idx := m.index(status.Version)
+	target := uint64(0)
+	if idx-steps >= 0 {
+		target = m.migrations[idx-steps].Version
+	}
+	return m.Goto(ctx, target)
+}
+
+// index returns the position of a version among the migrations, or -1 for
+// version zero.
+func (m *Migrator) index(version uint64) int {
+	return slices.IndexFunc(m.migrations, func(mig *Migration) bool {
+		return mig.Version == version
+	})
+}
+
+// Goto migrates up or down to the given version. Zero rolls back every
+// migration.
+func (m *Migrator) Goto(ctx context.Context, target uint64) error {
+	if target != 0 && m.index(target) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	// Advisory locks belong to a session, so everything runs on a single
+	// connection.
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// SQLite has no advisory locks, but is only used by a single instance.
+	if m.dialect == Postgres {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+			return fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	}
+
+	if err := ensureVersionTable(ctx, conn); err != nil {
+		return err
+	}
+	version, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w at version %d, fix the schema by hand first", ErrDirtyDatabase, version)
+	}
+	if version != 0 && m.index(version) < 0 {
+		return fmt.Errorf("%w: database is at version %d", ErrUnknownVersion, version)
+	}
+
+	for version < target {
+		next := m.migrations[m.index(version)+1]
+		if err := m.apply(ctx, conn, next.Up, next.Version); err != nil {
+			return fmt.Errorf("migrating up to %d_%s: %w", next.Version, next.Name, err)
+		}
+		version = next.Version
+	}
+	for version > target {
+		idx := m.index(version)
+		prev := uint64(0)
+		if idx > 0 {
+			prev = m.migrations[idx-1].Version
+		}
+		if err := m.apply(ctx, conn, m.migrations[idx].Down, prev); err != nil {
+			return fmt.Errorf("migrating down from %d_%s: %w", version, m.migrations[idx].Name, err)
+		}
+		version = prev
+	}
+	return nil
+}
+
+// apply runs a migration script and records the resulting version in one
+// transaction, so a failed migration leaves no trace.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script string, version uint64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if version > 0 {
+		query := `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`
+		if _, err := tx.ExecContext(ctx, m.dialect.Rebind(query), int64(version)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func ensureVersionTable(ctx context.Context, conn *sql.Conn) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations
+	(
+		version BIGINT  NOT NULL PRIMARY KEY,
+		dirty   BOOLEAN NOT NULL
+	)
+	`
+	_, err := conn.ExecContext(ctx, query)
+	return err
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (uint64, bool, error) {
+	query := `SELECT version, dirty FROM schema_migrations LIMIT 1`
+	var (
+		version int64
+		dirty   bool
+	)
+	err := conn.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return uint64(version), dirty, nil
+}
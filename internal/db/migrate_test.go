@@ -0,0 +1,281 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
+
+// testMigrations create two tables, the second of them in two steps.
+var testMigrations = fstest.MapFS{
+	"1_create_items.up.sql":    {Data: []byte(`CREATE TABLE migrate_items (id INTEGER PRIMARY KEY);`)},
+	"1_create_items.down.sql":  {Data: []byte(`DROP TABLE migrate_items;`)},
+	"2_create_tags.up.sql":     {Data: []byte(`CREATE TABLE migrate_tags (id INTEGER PRIMARY KEY);`)},
+	"2_create_tags.down.sql":   {Data: []byte(`DROP TABLE migrate_tags;`)},
+	"10_add_tag_name.up.sql":   {Data: []byte(`ALTER TABLE migrate_tags ADD COLUMN name TEXT;`)},
+	"10_add_tag_name.down.sql": {Data: []byte(`ALTER TABLE migrate_tags DROP COLUMN name;`)},
+	"README.md":                {Data: []byte(`not a migration`)},
+}
+
+func newTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	sqlDB, err := NewDB(&config.DatabaseConfig{
+		Driver:       config.DriverSQLite,
+		Path:         filepath.Join(t.TempDir(), "test.db"),
+		MaxOpenConns: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return sqlDB
+}
+
+// tableExists reports whether a SQLite table exists.
+func tableExists(t *testing.T, sqlDB *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	err := sqlDB.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func TestNewMigrator(t *testing.T) {
+	m, err := NewMigrator(nil, SQLite, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Latest() != 10 || len(m.migrations) != 3 || m.migrations[1].Name != "create_tags" {
+		t.Errorf("got latest %d and %d migrations, want them ordered by version", m.Latest(), len(m.migrations))
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"no up script":     {"1_a.down.sql": {}},
+		"version zero":     {"0_a.up.sql": {}},
+		"mismatched names": {"1_a.up.sql": {Data: []byte("SELECT 1")}, "1_b.down.sql": {}},
+	}
+	for name, fsys := range invalid {
+		if _, err := NewMigrator(nil, SQLite, fsys); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := newTestSQLite(t)
+	m, err := NewMigrator(sqlDB, SQLite, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Check(ctx); !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("got %v checking an empty database, want %v", err, ErrSchemaMismatch)
+	}
+	if err := m.Goto(ctx, 3); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("got %v, want %v", err, ErrUnknownVersion)
+	}
+
+	if err := m.Goto(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 2 || status.Dirty || len(status.Pending) != 1 || status.Pending[0].Version != 10 {
+		t.Fatalf("got %+v, want version 2 with 10 pending", status)
+	}
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("got %v after migrating up", err)
+	}
+
+	if err := m.Down(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := m.Status(ctx); status.Version != 1 || !tableExists(t, sqlDB, "migrate_items") || tableExists(t, sqlDB, "migrate_tags") {
+		t.Fatalf("got version %d, want 1 after rolling back two migrations", status.Version)
+	}
+	if err := m.Down(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := m.Status(ctx); status.Version != 0 || tableExists(t, sqlDB, "migrate_items") {
+		t.Fatalf("got version %d, want every migration rolled back", status.Version)
+	}
+}
+
+func TestMigratorFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := newTestSQLite(t)
+
+	fsys := fstest.MapFS{
+		"1_create_items.up.sql": testMigrations["1_create_items.up.sql"],
+		"2_broken.up.sql":       {Data: []byte(`CREATE TABLE migrate_broken (id INTEGER); SELECT * FROM missing;`)},
+	}
+	m, err := NewMigrator(sqlDB, SQLite, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The migrations before the failed one stay applied, and the failed
+	// one leaves no trace.
+	if err := m.Up(ctx); err == nil {
+		t.Fatal("got no error from a broken migration")
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 1 || status.Dirty || tableExists(t, sqlDB, "migrate_broken") {
+		t.Errorf("got %+v, want version 1 without the broken table", status)
+	}
+}
+
+func TestMigratorDirty(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := newTestSQLite(t)
+	m, err := NewMigrator(sqlDB, SQLite, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Goto(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// golang-migrate marks the version dirty while a migration runs.
+	if _, err := sqlDB.Exec(`UPDATE schema_migrations SET dirty = true`); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(ctx); !errors.Is(err, ErrDirtyDatabase) {
+		t.Errorf("got %v checking, want %v", err, ErrDirtyDatabase)
+	}
+	if err := m.Up(ctx); !errors.Is(err, ErrDirtyDatabase) {
+		t.Errorf("got %v migrating, want %v", err, ErrDirtyDatabase)
+	}
+	if tableExists(t, sqlDB, "migrate_tags") {
+		t.Error("got migrations applied to a dirty database")
+	}
+
+	// A version without a migration is not migrated from.
+	if _, err := sqlDB.Exec(`UPDATE schema_migrations SET version = 5, dirty = false`); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("got %v, want %v", err, ErrUnknownVersion)
+	}
+}
+
+func TestMigratorReady(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := newTestSQLite(t)
+	newer, err := NewMigrator(sqlDB, SQLite, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The previous release, without the last migration.
+	older, err := NewMigrator(sqlDB, SQLite, fstest.MapFS{
+		"1_create_items.up.sql": testMigrations["1_create_items.up.sql"],
+		"2_create_tags.up.sql":  testMigrations["2_create_tags.up.sql"],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := older.Goto(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := older.Ready(ctx); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("got %v with the schema behind, want %v", err, ErrSchemaMismatch)
+	}
+
+	// The newer release migrates up while the older one is running.
+	if err := newer.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := older.Ready(ctx); err != nil {
+		t.Errorf("got %v with the schema one version ahead, want ready", err)
+	}
+	if err := older.Check(ctx); !errors.Is(err, ErrSchemaMismatch) {
+		t.Errorf("got %v checking on startup, want %v", err, ErrSchemaMismatch)
+	}
+	if err := newer.Ready(ctx); err != nil {
+		t.Errorf("got %v at the latest version, want ready", err)
+	}
+
+	if _, err := sqlDB.Exec(`UPDATE schema_migrations SET dirty = true`); err != nil {
+		t.Fatal(err)
+	}
+	if err := older.Ready(ctx); !errors.Is(err, ErrDirtyDatabase) {
+		t.Errorf("got %v with a dirty schema, want %v", err, ErrDirtyDatabase)
+	}
+}
+
+// TestMigratorLock needs a Postgres database that can be written to, given
+// in SMARTSPLIT_TEST_POSTGRES_URL.
+func TestMigratorLock(t *testing.T) {
+	url := os.Getenv("SMARTSPLIT_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("SMARTSPLIT_TEST_POSTGRES_URL is not set")
+	}
+	ctx := context.Background()
+	sqlDB, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	m, err := NewMigrator(sqlDB, Postgres, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Goto(context.Background(), 0)
+		sqlDB.Exec(`DROP TABLE IF EXISTS schema_migrations`)
+	})
+
+	// Another instance holds the lock.
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- m.Up(ctx) }()
+	select {
+	case err := <-done:
+		t.Fatalf("got %v, want the migration to wait for the lock", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("migration did not run once the lock was released")
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Errorf("got %v after migrating", err)
+	}
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
+
+// sqliteDriver is the name the SQLite driver is registered under, with the
+// functions the Postgres queries rely on.
+const sqliteDriver = "sqlite3_smartsplit"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("now", sqliteNow, false)
+		},
+	})
+}
+
+// sqliteNow returns the current time in the format the driver stores times
+// in, so it compares correctly with them.
+func sqliteNow() string {
+	return time.Now().UTC().Format(sqlite3.SQLiteTimestampFormats[0])
+}
+
+// sqliteDSN enables foreign keys, which SQLite leaves off by default, and
+// the write-ahead log, so reads do not wait for writes. Transactions take
+// the write lock when they begin, as upgrading a read lock fails instead of
+// waiting when another connection is writing.
+func sqliteDSN(cfg *config.DatabaseConfig) string {
+	params := url.Values{}
+	params.Set("_foreign_keys", "on")
+	params.Set("_journal_mode", "WAL")
+	params.Set("_txlock", "immediate")
+	params.Set("_busy_timeout", "5000")
+	return "file:" + cfg.Path + "?" + params.Encode()
+}
+
+// newSQLite opens the database file, creating it if it does not exist.
+func newSQLite(cfg *config.DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open(sqliteDriver, sqliteDSN(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnableToCreateDB, err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if err := db.PingContext(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%w: %v", ErrDBUnreachable, err)
+	}
+	return db, nil
+}
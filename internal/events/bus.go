@@ -0,0 +1,393 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+const (
+	// pollInterval is how often the outbox is checked for pending events.
+	pollInterval = time.Second
+	// batchSize is the number of events claimed at a time.
+	batchSize = 10
+	// handlerTimeout limits a single delivery to a subscriber.
+	handlerTimeout = 30 * time.Second
+	// lease is how long claimed events are reserved for an instance. It
+	// outlasts a batch of events with two subscribers timing out each, after
+	// which another instance may deliver the events left.
+	lease = 2 * batchSize * handlerTimeout
+	// maxBackoff caps the delay between delivery attempts of an event.
+	maxBackoff = time.Hour
+	// Retention is how long published events are kept for replays.
+	Retention = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidSubscription is returned when subscribing without a
+	// subscriber name, event name or handler.
+	ErrInvalidSubscription = errors.New("invalid subscription")
+	// ErrDuplicateSubscription is returned when a subscriber subscribes to
+	// the same event twice.
+	ErrDuplicateSubscription = errors.New("already subscribed")
+	// ErrBusStarted is returned when subscribing after the bus has started.
+	ErrBusStarted = errors.New("event bus already started")
+)
+
+var (
+	tracer = otel.Tracer("github.com/evenlwanvik/smartsplit/internal/events")
+
+	deliveries = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "events",
+		Name:      "deliveries_total",
+		Help:      "Number of event deliveries by event, subscriber and result.",
+	}, []string{"event", "subscriber", "result"})
+)
+
+// Handler handles the raw payload of an event.
+type Handler func(ctx context.Context, meta Metadata, payload json.RawMessage) error
+
+type subscription struct {
+	subscriber string
+	event      string
+	handle     Handler
+}
+
+// ReplayFilter selects the events to replay. Nil fields match everything.
+type ReplayFilter struct {
+	// FromID is the ID of the first event to replay.
+	FromID int64
+	ToID   *int64
+	Name   *string
+	// Subscriber limits the replay to a single subscriber.
+	Subscriber *string
+}
+
+// Bus delivers the events stored in the outbox to their subscribers. Events
+// are delivered at least once: a subscriber is called again until it
+// succeeds, and may be called again after succeeding if recording the
+// delivery fails, so handlers must be idempotent.
+//
+// Every instance runs a dispatcher, and the events are spread between them
+// by leases.
+type Bus struct {
+	db      *sql.DB
+	dialect db.Dialect
+	repo    *Repository
+	logger  *slog.Logger
+
+	mu            sync.Mutex
+	subscriptions []*subscription
+	started       bool
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// NewBus creates an event bus.
+func NewBus(db *sql.DB, dialect db.Dialect, logger *slog.Logger) *Bus {
+	return &Bus{
+		db:      db,
+		dialect: dialect,
+		repo:    NewRepository(db, dialect),
+		logger:  logger.With(slog.Group("module", slog.String("name", "events"))),
+	}
+}
+
+// Subscribe registers a handler for the events with the given name. The
+// subscriber name identifies the handler in the delivery history, and must
+// not change once events have been delivered. Subscriptions must be made
+// before the bus starts, i.e. during the setup of the modules.
+func (b *Bus) Subscribe(subscriber string, event string, handle Handler) error {
+	if subscriber == "" || event == "" || handle == nil {
+		return fmt.Errorf("%w: subscriber, event and handler are required", ErrInvalidSubscription)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		return fmt.Errorf("%w: cannot subscribe %s to %s", ErrBusStarted, subscriber, event)
+	}
+	for _, s := range b.subscriptions {
+		if s.subscriber == subscriber && s.event == event {
+			return fmt.Errorf("%w: %s to %s", ErrDuplicateSubscription, subscriber, event)
+		}
+	}
+
+	b.logger.Info("subscribing to event", "subscriber", subscriber, "event", event)
+	b.subscriptions = append(b.subscriptions, &subscription{
+		subscriber: subscriber,
+		event:      event,
+		handle:     handle,
+	})
+	return nil
+}
+
+// Subscribe registers a handler for events of type E, decoding their
+// payloads.
+func Subscribe[E Event](b *Bus, subscriber string, fn func(ctx context.Context, meta Metadata, ev E) error) error {
+	var zero E
+	return b.Subscribe(subscriber, zero.EventName(), func(ctx context.Context, meta Metadata, payload json.RawMessage) error {
+		var ev E
+		if err := json.Unmarshal(payload, &ev); err != nil {
+			return fmt.Errorf("decoding %s: %w", zero.EventName(), err)
+		}
+		return fn(ctx, meta, ev)
+	})
+}
+
+// subscribers returns the subscriptions to an event.
+func (b *Bus) subscribers(event string) []*subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var subs []*subscription
+	for _, s := range b.subscriptions {
+		if s.event == event {
+			subs = append(subs, s)
+		}
+	}
+	return subs
+}
+
+// Start starts delivering pending events.
+func (b *Bus) Start(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		return
+	}
+	b.started = true
+
+	ctx, stop := context.WithCancel(logging.WithLogger(context.WithoutCancel(ctx), b.logger))
+	b.stop = stop
+	b.done = make(chan struct{})
+
+	b.logger.Info("starting event bus", "subscriptions", len(b.subscriptions))
+	go func() {
+		defer close(b.done)
+		b.dispatch(ctx)
+	}()
+}
+
+// Stop stops delivering events, and waits for the delivery in progress. The
+// rest of the batch is released to be delivered again.
+func (b *Bus) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	started := b.started
+	b.mu.Unlock()
+	if !started || b.stop == nil {
+		return nil
+	}
+
+	b.logger.Info("stopping event bus")
+	b.stop()
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("draining event bus: %w", ctx.Err())
+	}
+}
+
+// dispatch delivers pending events until the context is cancelled.
+func (b *Bus) dispatch(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while full batches are claimed, so a backlog is
+		// worked off without waiting for the ticker.
+		for {
+			n, err := b.deliverBatch(ctx)
+			if err != nil && ctx.Err() == nil {
+				b.logger.Error("failed to deliver events", "error", err)
+			}
+			if err != nil || n < batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverBatch claims a batch of pending events and delivers each to the
+// subscribers that have not handled it yet. Events with a failed delivery
+// are retried with an exponential backoff. It returns the number of events
+// claimed.
+//
+// Every delivery is recorded as soon as it is made, so no transaction is
+// held open while subscribers run, and SQLite, with its single writer, is
+// free for the subscribers to write to.
+func (b *Bus) deliverBatch(ctx context.Context) (int, error) {
+	envelopes, err := b.repo.ClaimPending(ctx, batchSize, lease)
+	if err != nil {
+		return 0, err
+	}
+	if len(envelopes) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, len(envelopes))
+	for i, env := range envelopes {
+		ids[i] = env.ID
+	}
+	delivered, err := b.repo.Delivered(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	// Deliveries made after the context is cancelled are still recorded,
+	// so they are not repeated.
+	record := context.WithoutCancel(ctx)
+	release := func(ids []int64) (int, error) {
+		if err := b.repo.Release(record, ids); err != nil {
+			return 0, fmt.Errorf("releasing events: %w", err)
+		}
+		return len(envelopes), nil
+	}
+	for i, env := range envelopes {
+		if ctx.Err() != nil {
+			return release(ids[i:])
+		}
+
+		var errs []error
+		for _, s := range b.subscribers(env.Name) {
+			if delivered[env.ID][s.subscriber] {
+				continue
+			}
+			if err := b.deliver(ctx, s, env); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.subscriber, err))
+				continue
+			}
+			if err := b.repo.MarkDelivered(record, env.ID, s.subscriber); err != nil {
+				return 0, err
+			}
+		}
+
+		if err := errors.Join(errs...); err != nil {
+			if ctx.Err() != nil {
+				// The bus is stopping, which is not the subscriber's
+				// failure.
+				return release(ids[i:])
+			}
+			retryAfter := backoff(env.Attempts)
+			b.logger.Warn("event delivery failed, retrying",
+				"event", env.Name, "event_id", env.ID, "attempt", env.Attempts+1, "retry_after", retryAfter, "error", err)
+			if err := b.repo.MarkFailed(record, env.ID, retryAfter, err.Error()); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := b.repo.MarkPublished(record, env.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(envelopes), nil
+}
+
+// deliver calls a subscriber with its timeout, turning panics into errors.
+func (b *Bus) deliver(ctx context.Context, s *subscription, env *Envelope) (err error) {
+	logger := b.logger.With(
+		slog.String("event", env.Name),
+		slog.Int64("event_id", env.ID),
+		slog.String("subscriber", s.subscriber),
+	)
+	ctx = logging.WithLogger(ctx, logger)
+
+	ctx, span := tracer.Start(ctx, "events.deliver "+env.Name,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("event.name", env.Name),
+			attribute.Int64("event.id", env.ID),
+			attribute.String("event.subscriber", s.subscriber),
+		),
+	)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, handlerTimeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panicked: %v", r)
+		}
+		result := "success"
+		if err != nil {
+			result = "failure"
+			span.SetStatus(codes.Error, err.Error())
+		}
+		deliveries.WithLabelValues(env.Name, s.subscriber, result).Inc()
+	}()
+
+	meta := Metadata{ID: env.ID, OccurredAt: env.OccurredAt, Attempts: env.Attempts}
+	return s.handle(ctx, meta, env.Payload)
+}
+
+// backoff returns the delay before the next delivery attempt of an event
+// that has failed the given number of times before.
+func backoff(attempts int) time.Duration {
+	d := time.Second << min(attempts, 12)
+	return min(d, maxBackoff)
+}
+
+// Replay delivers stored events matching the filter again, in order,
+// whether or not they have been delivered before. It stops at the first
+// failed delivery, so the replay can be resumed from the failed event, and
+// returns the number of deliveries made.
+func (b *Bus) Replay(ctx context.Context, filter ReplayFilter) (int, error) {
+	ctx = logging.WithLogger(ctx, b.logger)
+
+	var count int
+	for {
+		envelopes, err := b.repo.List(ctx, filter, batchSize)
+		if err != nil {
+			return count, err
+		}
+
+		for _, env := range envelopes {
+			for _, s := range b.subscribers(env.Name) {
+				if filter.Subscriber != nil && s.subscriber != *filter.Subscriber {
+					continue
+				}
+				if err := b.deliver(ctx, s, env); err != nil {
+					return count, fmt.Errorf("replaying event %d to %s: %w", env.ID, s.subscriber, err)
+				}
+				count++
+			}
+		}
+
+		if len(envelopes) < batchSize {
+			return count, nil
+		}
+		filter.FromID = envelopes[len(envelopes)-1].ID + 1
+	}
+}
+
+// Prune deletes published events older than the retention period.
+func (b *Bus) Prune(ctx context.Context) (int64, error) {
+	return b.repo.Prune(ctx, time.Now().Add(-Retention))
+}
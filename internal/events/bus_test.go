@@ -0,0 +1,207 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/db/dbtest"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	os.Exit(m.Run())
+}
+
+// outboxRow is the delivery state of a stored event.
+type outboxRow struct {
+	published bool
+	attempts  int
+	leased    bool
+	lastError string
+}
+
+func readOutbox(t *testing.T, sqlDB *sql.DB, id int64) outboxRow {
+	t.Helper()
+	var (
+		row         outboxRow
+		publishedAt sql.NullString
+		lockedUntil sql.NullString
+	)
+	err := sqlDB.QueryRow(`
+	SELECT published_at, attempts, locked_until, last_error
+	FROM events_outbox WHERE id = ?`, id,
+	).Scan(&publishedAt, &row.attempts, &lockedUntil, &row.lastError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row.published, row.leased = publishedAt.Valid, lockedUntil.Valid
+	return row
+}
+
+func appendEvents(t *testing.T, sqlDB *sql.DB, evs ...Event) {
+	t.Helper()
+	for _, ev := range evs {
+		if err := Append(context.Background(), db.SQLite.Wrap(sqlDB), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBusDeliverBatch(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := dbtest.NewSQLite(t)
+	bus := NewBus(sqlDB, db.SQLite, slog.Default())
+
+	var (
+		audited []int
+		failing = true
+		calls   = map[string]int{}
+	)
+	err := Subscribe(bus, "audit", func(ctx context.Context, meta Metadata, ev PlanCreated) error {
+		calls["audit"]++
+		audited = append(audited, ev.PlanID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bus.Subscribe("flaky", PlanCreated{}.EventName(), func(ctx context.Context, meta Metadata, payload json.RawMessage) error {
+		calls["flaky"]++
+		if failing {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Subscribe("flaky", PlanCreated{}.EventName(), func(context.Context, Metadata, json.RawMessage) error { return nil }); !errors.Is(err, ErrDuplicateSubscription) {
+		t.Errorf("got %v subscribing twice, want %v", err, ErrDuplicateSubscription)
+	}
+
+	appendEvents(t, sqlDB, PlanCreated{PlanID: 1}, UserDeleted{UserID: 2})
+
+	n, err := bus.deliverBatch(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("got %d, %v, want 2 events claimed", n, err)
+	}
+	if got := readOutbox(t, sqlDB, 1); got.published || got.attempts != 1 || got.leased || got.lastError == "" {
+		t.Errorf("got %+v, want the event retried with its lease ended", got)
+	}
+	if got := readOutbox(t, sqlDB, 2); !got.published || got.leased {
+		t.Errorf("got %+v, want the event without subscribers published", got)
+	}
+
+	// The retry waits for the backoff.
+	if n, err := bus.deliverBatch(ctx); err != nil || n != 0 {
+		t.Fatalf("got %d, %v, want no events due", n, err)
+	}
+
+	// Only the subscriber that failed is called again.
+	failing = false
+	if _, err := sqlDB.Exec(`UPDATE events_outbox SET next_attempt_at = '2000-01-01 00:00:00+00:00'`); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := bus.deliverBatch(ctx); err != nil || n != 1 {
+		t.Fatalf("got %d, %v, want the failed event claimed again", n, err)
+	}
+	if calls["audit"] != 1 || calls["flaky"] != 2 || len(audited) != 1 || audited[0] != 1 {
+		t.Errorf("got calls %v and audited %v, want audit called once", calls, audited)
+	}
+	if got := readOutbox(t, sqlDB, 1); !got.published || got.attempts != 1 || got.lastError != "" {
+		t.Errorf("got %+v, want the event published", got)
+	}
+}
+
+func TestRepositoryLeases(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := dbtest.NewSQLite(t)
+	repo := NewRepository(sqlDB, db.SQLite)
+
+	appendEvents(t, sqlDB, UserDeleted{UserID: 1}, UserDeleted{UserID: 2}, UserDeleted{UserID: 3})
+
+	claimed, err := repo.ClaimPending(ctx, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 || claimed[0].ID != 1 || claimed[1].ID != 2 {
+		t.Fatalf("got %v, want the two oldest events", claimed)
+	}
+
+	// Leased events are skipped by other claims.
+	claimed, err = repo.ClaimPending(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != 3 {
+		t.Fatalf("got %v, want only the event not leased", claimed)
+	}
+
+	// Released and expired leases can be claimed again.
+	if err := repo.Release(ctx, []int64{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqlDB.Exec(`UPDATE events_outbox SET locked_until = '2000-01-01 00:00:00+00:00' WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = repo.ClaimPending(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 || claimed[0].ID != 1 || claimed[1].ID != 2 {
+		t.Fatalf("got %v, want the released and expired events", claimed)
+	}
+}
+
+func TestBusStopReleasesBatch(t *testing.T) {
+	sqlDB := dbtest.NewSQLite(t)
+	bus := NewBus(sqlDB, db.SQLite, slog.Default())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := Subscribe(bus, "slow", func(ctx context.Context, meta Metadata, ev UserDeleted) error {
+		// The bus stops during the first delivery.
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(t, sqlDB, UserDeleted{UserID: 1}, UserDeleted{UserID: 2})
+
+	if _, err := bus.deliverBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for id := int64(1); id <= 2; id++ {
+		if got := readOutbox(t, sqlDB, id); got.published || got.attempts != 0 || got.leased {
+			t.Errorf("event %d: got %+v, want it released without an attempt", id, got)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{5, 32 * time.Second},
+		{11, 2048 * time.Second},
+		{12, maxBackoff},
+		{100, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
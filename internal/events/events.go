@@ -0,0 +1,72 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is a domain event. Its name identifies the type of the payload, and
+// must not change once events have been stored.
+type Event interface {
+	EventName() string
+}
+
+// Envelope is a stored event with its undecoded payload.
+type Envelope struct {
+	ID         int64           `json:"id"`
+	Name       string          `json:"name"`
+	Payload    json.RawMessage `json:"payload"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Attempts   int             `json:"attempts"`
+}
+
+// Metadata describes the stored event a subscriber is handling.
+type Metadata struct {
+	ID         int64
+	OccurredAt time.Time
+	// Attempts is the number of earlier attempts at delivering the event.
+	Attempts int
+}
+
+// PlanCreated is published when a workout plan is created with its entries.
+type PlanCreated struct {
+	PlanID    int   `json:"plan_id"`
+	UserID    int   `json:"user_id"`
+	MuscleIDs []int `json:"muscle_ids"`
+}
+
+func (PlanCreated) EventName() string { return "workout.plan.created" }
+
+// PlanDeleted is published when a workout plan and its entries are deleted.
+type PlanDeleted struct {
+	PlanID int `json:"plan_id"`
+	UserID int `json:"user_id"`
+}
+
+func (PlanDeleted) EventName() string { return "workout.plan.deleted" }
+
+// EntryUpdated is published when the sets of a plan entry change.
+type EntryUpdated struct {
+	EntryID  int `json:"entry_id"`
+	PlanID   int `json:"plan_id"`
+	MuscleID int `json:"muscle_id"`
+	Sets     int `json:"sets"`
+}
+
+func (EntryUpdated) EventName() string { return "workout.entry.updated" }
+
+// UserRegistered is published when a user is created.
+type UserRegistered struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+func (UserRegistered) EventName() string { return "auth.user.registered" }
+
+// UserDeleted is published when a user is purged, after the deletion grace
+// period.
+type UserDeleted struct {
+	UserID int `json:"user_id"`
+}
+
+func (UserDeleted) EventName() string { return "auth.user.deleted" }
@@ -0,0 +1,240 @@
+package events
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+// Execer is implemented by *sql.Tx, and by *sql.DB.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Append stores an event in the outbox. It must be given the transaction of
+// the change the event describes, so the event is stored if and only if
+// the change is committed.
+func Append(ctx context.Context, tx Execer, ev Event) error {
+	defer metrics.ObserveQuery("events", "Append", time.Now())
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO events.outbox (name, payload)
+	VALUES ($1, $2)
+	`
+	_, err = tx.ExecContext(ctx, query, ev.EventName(), payload)
+	return err
+}
+
+type Repository struct {
+	dialect db.Dialect
+	// db runs the queries rewritten to the dialect.
+	db db.Querier
+}
+
+func NewRepository(db *sql.DB, dialect db.Dialect) *Repository {
+	return &Repository{dialect: dialect, db: dialect.Wrap(db)}
+}
+
+// ClaimPending leases a batch of events due for delivery to the caller
+// until the lease ends. Events leased by another instance are skipped, so
+// instances can deliver concurrently without delivering the same event at
+// the same time. The claim commits at once, so no rows stay locked while
+// the events are delivered.
+func (r *Repository) ClaimPending(ctx context.Context, limit int, lease time.Duration) ([]*Envelope, error) {
+	defer metrics.ObserveQuery("events", "ClaimPending", time.Now())
+	query := `
+	UPDATE events.outbox
+	SET locked_until = now() + make_interval(secs => $2::double precision)
+	WHERE id IN (
+		SELECT id
+		FROM events.outbox
+		WHERE published_at IS NULL AND next_attempt_at <= now()
+		AND (locked_until IS NULL OR locked_until <= now())
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, name, payload, occurred_at, attempts
+	`
+	if r.dialect == db.SQLite {
+		query = `
+		UPDATE events.outbox
+		SET locked_until = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now', $2 || ' seconds')
+		WHERE id IN (
+			SELECT id
+			FROM events.outbox
+			WHERE published_at IS NULL AND next_attempt_at <= now()
+			AND (locked_until IS NULL OR locked_until <= now())
+			ORDER BY id
+			LIMIT $1
+		)
+		RETURNING id, name, payload, occurred_at, attempts
+		`
+	}
+	rows, err := r.db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	envelopes, err := scanEnvelopes(rows)
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING does not keep the order of the subquery.
+	slices.SortFunc(envelopes, func(a, b *Envelope) int { return cmp.Compare(a.ID, b.ID) })
+	return envelopes, nil
+}
+
+// Release ends the leases of events that were claimed but not delivered,
+// so they can be claimed again without waiting for the leases to end.
+func (r *Repository) Release(ctx context.Context, ids []int64) error {
+	defer metrics.ObserveQuery("events", "Release", time.Now())
+	const query = `
+	UPDATE events.outbox
+	SET locked_until = NULL
+	WHERE id = ANY($1)
+	`
+	_, err := r.db.ExecContext(ctx, query, db.Array(r.dialect, ids))
+	return err
+}
+
+// Delivered returns the subscribers that have handled each of the events.
+func (r *Repository) Delivered(ctx context.Context, ids []int64) (map[int64]map[string]bool, error) {
+	defer metrics.ObserveQuery("events", "Delivered", time.Now())
+	const query = `
+	SELECT event_id, subscriber
+	FROM events.deliveries
+	WHERE event_id = ANY($1)
+	`
+	rows, err := r.db.QueryContext(ctx, query, db.Array(r.dialect, ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivered := make(map[int64]map[string]bool)
+	for rows.Next() {
+		var (
+			id         int64
+			subscriber string
+		)
+		if err := rows.Scan(&id, &subscriber); err != nil {
+			return nil, err
+		}
+		if delivered[id] == nil {
+			delivered[id] = make(map[string]bool)
+		}
+		delivered[id][subscriber] = true
+	}
+	return delivered, rows.Err()
+}
+
+// MarkDelivered records that a subscriber has handled an event.
+func (r *Repository) MarkDelivered(ctx context.Context, id int64, subscriber string) error {
+	defer metrics.ObserveQuery("events", "MarkDelivered", time.Now())
+	query := `
+	INSERT INTO events.deliveries (event_id, subscriber)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, query, id, subscriber)
+	return err
+}
+
+// MarkPublished records that every subscriber has handled an event, and
+// ends its lease.
+func (r *Repository) MarkPublished(ctx context.Context, id int64) error {
+	defer metrics.ObserveQuery("events", "MarkPublished", time.Now())
+	query := `
+	UPDATE events.outbox
+	SET published_at = now(), last_error = '', locked_until = NULL
+	WHERE id = $1
+	`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// MarkFailed postpones the next delivery attempt of an event, and ends its
+// lease.
+func (r *Repository) MarkFailed(ctx context.Context, id int64, retryAfter time.Duration, lastError string) error {
+	defer metrics.ObserveQuery("events", "MarkFailed", time.Now())
+	query := `
+	UPDATE events.outbox
+	SET attempts = attempts + 1,
+		next_attempt_at = now() + make_interval(secs => $2::double precision),
+		last_error = $3,
+		locked_until = NULL
+	WHERE id = $1
+	`
+	if r.dialect == db.SQLite {
+		query = `
+		UPDATE events.outbox
+		SET attempts = attempts + 1,
+			next_attempt_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now', $2 || ' seconds'),
+			last_error = $3,
+			locked_until = NULL
+		WHERE id = $1
+		`
+	}
+	_, err := r.db.ExecContext(ctx, query, id, retryAfter.Seconds(), lastError)
+	return err
+}
+
+// List returns stored events matching the filter, oldest first, whether or
+// not they have been delivered.
+func (r *Repository) List(ctx context.Context, filter ReplayFilter, limit int) ([]*Envelope, error) {
+	defer metrics.ObserveQuery("events", "List", time.Now())
+	query := `
+	SELECT id, name, payload, occurred_at, attempts
+	FROM events.outbox
+	WHERE id >= $1
+	AND ($2::bigint IS NULL OR id <= $2)
+	AND ($3::text IS NULL OR name = $3)
+	ORDER BY id
+	LIMIT $4
+	`
+	rows, err := r.db.QueryContext(ctx, query, filter.FromID, filter.ToID, filter.Name, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEnvelopes(rows)
+}
+
+// Prune deletes published events that occurred before the cutoff, along
+// with their deliveries.
+func (r *Repository) Prune(ctx context.Context, before time.Time) (int64, error) {
+	defer metrics.ObserveQuery("events", "Prune", time.Now())
+	query := `
+	DELETE FROM events.outbox
+	WHERE published_at IS NOT NULL AND occurred_at < $1
+	`
+	res, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanEnvelopes(rows *sql.Rows) ([]*Envelope, error) {
+	defer rows.Close()
+
+	var envelopes []*Envelope
+	for rows.Next() {
+		var env Envelope
+		err := rows.Scan(&env.ID, &env.Name, &env.Payload, &env.OccurredAt, &env.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, &env)
+	}
+	return envelopes, rows.Err()
+}
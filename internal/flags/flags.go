@@ -0,0 +1,260 @@
+package flags
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+// refreshInterval is how often the overrides are reloaded, and so how long
+// other instances take to pick up a change.
+const refreshInterval = 10 * time.Second
+
+var (
+	// ErrUnknownFlag is returned when overriding a flag that is not
+	// defined in the config.
+	ErrUnknownFlag = errors.New("unknown feature flag")
+	// ErrInvalidRule is returned when overriding a flag with a bad rule.
+	ErrInvalidRule = errors.New("invalid flag rule")
+)
+
+var evaluations = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "flags",
+	Name:      "evaluations_total",
+	Help:      "Number of feature flag checks by flag and result.",
+}, []string{"flag", "result"})
+
+// Flags evaluates the feature flags. The flags and their default rules are
+// defined in the config, and admins can override the rules at runtime.
+// Overrides are stored in Postgres and reloaded periodically, so every
+// instance picks them up.
+type Flags struct {
+	repo     *Repository
+	logger   *slog.Logger
+	defaults map[string]*Flag
+
+	mu        sync.RWMutex
+	overrides map[string]*Override
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// New creates the feature flags defined in the config. Until the overrides
+// are loaded, the flags follow their default rules.
+func New(db *sql.DB, dialect db.Dialect, logger *slog.Logger, cfg map[string]*config.FlagConfig) *Flags {
+	defaults := make(map[string]*Flag, len(cfg))
+	for name, c := range cfg {
+		f := &Flag{Name: name, Source: SourceConfig}
+		if c != nil {
+			f.Description = c.Description
+			f.Rule = Rule{
+				Enabled:    c.Enabled,
+				Percentage: c.Percentage,
+				Users:      c.Users,
+				Roles:      c.Roles,
+			}
+		}
+		defaults[name] = f
+	}
+	return &Flags{
+		repo:      NewRepository(db, dialect),
+		logger:    logger.With(slog.Group("module", slog.String("name", "flags"))),
+		defaults:  defaults,
+		overrides: map[string]*Override{},
+	}
+}
+
+// Enabled reports whether a flag is on for the principal of the request,
+// or for anonymous users outside of requests. Unknown flags are off.
+func (f *Flags) Enabled(ctx context.Context, name string) bool {
+	var s Subject
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		s = Subject{UserID: principal.UserID, Role: string(principal.Role)}
+	}
+	return f.EnabledFor(name, s)
+}
+
+// EnabledFor reports whether a flag is on for the subject. Unknown flags are
+// off.
+func (f *Flags) EnabledFor(name string, s Subject) bool {
+	rule, ok := f.rule(name)
+	enabled := ok && rule.matches(name, s)
+	evaluations.WithLabelValues(name, strconv.FormatBool(enabled)).Inc()
+	return enabled
+}
+
+// rule returns the current rule of a flag.
+func (f *Flags) rule(name string) (Rule, bool) {
+	def, ok := f.defaults[name]
+	if !ok {
+		return Rule{}, false
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if o, ok := f.overrides[name]; ok {
+		return o.Rule, true
+	}
+	return def.Rule, true
+}
+
+// List returns the flags with their current rules, sorted by name.
+func (f *Flags) List() []*Flag {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	flags := make([]*Flag, 0, len(f.defaults))
+	for name := range f.defaults {
+		flags = append(flags, f.flag(name))
+	}
+	slices.SortFunc(flags, func(a, b *Flag) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return flags
+}
+
+// flag returns a flag with its current rule. The caller must hold the lock.
+func (f *Flags) flag(name string) *Flag {
+	flag := *f.defaults[name]
+	if o, ok := f.overrides[name]; ok {
+		flag.Rule = o.Rule
+		flag.Source = SourceOverride
+		flag.UpdatedBy = o.UpdatedBy
+		flag.UpdatedAt = &o.UpdatedAt
+	}
+	return &flag
+}
+
+// Get returns a flag with its current rule.
+func (f *Flags) Get(name string) (*Flag, error) {
+	if _, ok := f.defaults[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.flag(name), nil
+}
+
+// Override replaces the rule of a flag until the override is reset. It
+// takes effect here at once, and on other instances within the refresh
+// interval.
+func (f *Flags) Override(ctx context.Context, name string, rule Rule, updatedBy *int) (*Flag, error) {
+	if _, ok := f.defaults[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+	}
+	if rule.Percentage < 0 || rule.Percentage > 100 {
+		return nil, fmt.Errorf("%w: percentage must be between 0 and 100", ErrInvalidRule)
+	}
+
+	o := &Override{Name: name, Rule: rule, UpdatedBy: updatedBy}
+	if err := f.repo.Upsert(ctx, o); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.overrides[name] = o
+	return f.flag(name), nil
+}
+
+// Reset deletes the override of a flag, returning it to its default rule.
+func (f *Flags) Reset(ctx context.Context, name string) (*Flag, error) {
+	if _, ok := f.defaults[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+	}
+	if _, err := f.repo.Delete(ctx, name); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.overrides, name)
+	return f.flag(name), nil
+}
+
+// Load reloads the overrides. Overrides of flags that are no longer defined
+// in the config are ignored.
+func (f *Flags) Load(ctx context.Context) error {
+	overrides, err := f.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]*Override, len(overrides))
+	for _, o := range overrides {
+		if _, ok := f.defaults[o.Name]; ok {
+			byName[o.Name] = o
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.overrides = byName
+	return nil
+}
+
+// Start loads the overrides, and keeps reloading them until stopped. If
+// loading fails, the flags keep their last known rules.
+func (f *Flags) Start(ctx context.Context) {
+	if f.stop != nil {
+		return
+	}
+	if err := f.Load(ctx); err != nil {
+		f.logger.Warn("failed to load feature flag overrides", "error", err)
+	}
+
+	ctx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	f.stop = stop
+	f.done = make(chan struct{})
+	go func() {
+		defer close(f.done)
+		f.refresh(ctx)
+	}()
+}
+
+// Stop stops reloading the overrides.
+func (f *Flags) Stop(ctx context.Context) error {
+	if f.stop == nil {
+		return nil
+	}
+	f.stop()
+	select {
+	case <-f.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *Flags) refresh(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.Load(ctx); err != nil && ctx.Err() == nil {
+				f.logger.Warn("failed to reload feature flag overrides", "error", err)
+			}
+		}
+	}
+}
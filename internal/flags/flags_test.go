@@ -0,0 +1,191 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/db/dbtest"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	os.Exit(m.Run())
+}
+
+func TestRuleMatches(t *testing.T) {
+	anonymous := Subject{}
+	user := Subject{UserID: 7, Role: "user"}
+	admin := Subject{UserID: 1, Role: "admin"}
+
+	tests := []struct {
+		name    string
+		rule    Rule
+		subject Subject
+		want    bool
+	}{
+		{name: "off", rule: Rule{}, subject: user, want: false},
+		{name: "enabled for users", rule: Rule{Enabled: true}, subject: user, want: true},
+		{name: "enabled for anonymous users", rule: Rule{Enabled: true}, subject: anonymous, want: true},
+		{name: "listed user", rule: Rule{Users: []int{3, 7}}, subject: user, want: true},
+		{name: "other user", rule: Rule{Users: []int{3}}, subject: user, want: false},
+		{name: "listed role", rule: Rule{Roles: []string{"admin"}}, subject: admin, want: true},
+		{name: "other role", rule: Rule{Roles: []string{"admin"}}, subject: user, want: false},
+		{name: "role without user", rule: Rule{Roles: []string{""}}, subject: Subject{UserID: 7}, want: false},
+		{name: "all users", rule: Rule{Percentage: 100}, subject: user, want: true},
+		{name: "percentage excludes anonymous users", rule: Rule{Percentage: 100}, subject: anonymous, want: false},
+		{name: "users exclude anonymous users", rule: Rule{Users: []int{0}}, subject: anonymous, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.matches("flag", tt.subject); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentageRollout(t *testing.T) {
+	const users = 10000
+	inRollout := func(name string, percentage int) map[int]bool {
+		rule := Rule{Percentage: percentage}
+		in := map[int]bool{}
+		for id := 1; id <= users; id++ {
+			if rule.matches(name, Subject{UserID: id}) {
+				in[id] = true
+			}
+		}
+		return in
+	}
+
+	ten, twenty := inRollout("new_dashboard", 10), inRollout("new_dashboard", 20)
+	// The share is close to the percentage.
+	if n := len(ten); n < 900 || n > 1100 {
+		t.Errorf("got %d of %d users at 10%%", n, users)
+	}
+	if n := len(twenty); n < 1800 || n > 2200 {
+		t.Errorf("got %d of %d users at 20%%", n, users)
+	}
+	// Users stay in the rollout as it grows.
+	for id := range ten {
+		if !twenty[id] {
+			t.Fatalf("user %d left the rollout when it grew", id)
+		}
+	}
+	// Flags roll out to different users.
+	other := inRollout("bulk_import", 10)
+	same := 0
+	for id := range ten {
+		if other[id] {
+			same++
+		}
+	}
+	if same > len(ten)/2 {
+		t.Errorf("got %d of %d users in the rollout of both flags", same, len(ten))
+	}
+	// Buckets are stable.
+	if bucket("new_dashboard", 42) != bucket("new_dashboard", 42) {
+		t.Error("got different buckets for the same user")
+	}
+}
+
+func newTestFlags(t *testing.T) (*Flags, *Flags) {
+	t.Helper()
+	sqlDB := dbtest.NewSQLite(t)
+	cfg := map[string]*config.FlagConfig{
+		"new_dashboard": {Description: "The redesigned dashboard", Roles: []string{"admin"}},
+		"bulk_import":   {Enabled: true},
+		"bare":          nil,
+	}
+	return New(sqlDB, db.SQLite, slog.Default(), cfg), New(sqlDB, db.SQLite, slog.Default(), cfg)
+}
+
+func TestFlagsOverrides(t *testing.T) {
+	ctx := context.Background()
+	flags, other := newTestFlags(t)
+	user := Subject{UserID: 7, Role: "user"}
+	adminID := 1
+
+	if !flags.EnabledFor("new_dashboard", Subject{UserID: 1, Role: "admin"}) || flags.EnabledFor("new_dashboard", user) {
+		t.Fatal("got the default rule not followed")
+	}
+	if flags.EnabledFor("unknown", user) || flags.EnabledFor("bare", user) {
+		t.Fatal("got unknown or bare flags on")
+	}
+	adminCtx := auth.WithPrincipal(ctx, &auth.Principal{UserID: 1, Role: auth.RoleAdmin})
+	if !flags.Enabled(adminCtx, "new_dashboard") || flags.Enabled(ctx, "new_dashboard") {
+		t.Error("got the principal of the context not used")
+	}
+
+	flag, err := flags.Override(ctx, "new_dashboard", Rule{Users: []int{7}}, &adminID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flag.Source != SourceOverride || *flag.UpdatedBy != adminID || flag.Description != "The redesigned dashboard" {
+		t.Errorf("got %+v, want the override", flag)
+	}
+	if !flags.EnabledFor("new_dashboard", user) {
+		t.Error("got the override not applied at once")
+	}
+
+	// Other instances pick the override up when they reload.
+	if other.EnabledFor("new_dashboard", user) {
+		t.Fatal("got the override applied before reloading")
+	}
+	if err := other.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !other.EnabledFor("new_dashboard", user) {
+		t.Error("got the override not loaded")
+	}
+
+	list := other.List()
+	if len(list) != 3 || list[0].Name != "bare" || list[2].Name != "new_dashboard" || list[2].Source != SourceOverride || list[1].Source != SourceConfig {
+		t.Errorf("got %+v, want the flags sorted by name with their sources", list)
+	}
+
+	flag, err = flags.Reset(ctx, "new_dashboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flag.Source != SourceConfig || flags.EnabledFor("new_dashboard", user) {
+		t.Errorf("got %+v, want the default rule back", flag)
+	}
+	if err := other.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if other.EnabledFor("new_dashboard", user) {
+		t.Error("got the reset not loaded")
+	}
+
+	if _, err := flags.Override(ctx, "unknown", Rule{}, nil); !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("got %v overriding an unknown flag, want %v", err, ErrUnknownFlag)
+	}
+	if _, err := flags.Override(ctx, "bulk_import", Rule{Percentage: 101}, nil); !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("got %v, want %v", err, ErrInvalidRule)
+	}
+	if _, err := flags.Get("unknown"); !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("got %v, want %v", err, ErrUnknownFlag)
+	}
+}
+
+func TestFlagsIgnoreUndefinedOverrides(t *testing.T) {
+	ctx := context.Background()
+	flags, _ := newTestFlags(t)
+
+	// An override stored by an instance that still defines the flag.
+	if err := flags.repo.Upsert(ctx, &Override{Name: "removed", Rule: Rule{Enabled: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if flags.EnabledFor("removed", Subject{UserID: 7}) || len(flags.List()) != 3 {
+		t.Error("got an override of a flag not in the config")
+	}
+}
@@ -0,0 +1,78 @@
+package flags
+
+import (
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Source tells where the rule of a flag comes from.
+type Source string
+
+const (
+	SourceConfig   Source = "config"
+	SourceOverride Source = "override"
+)
+
+// Rule decides who a flag is on for.
+type Rule struct {
+	// Enabled turns the flag on for everyone, including anonymous users.
+	Enabled bool `json:"enabled"`
+	// Percentage turns the flag on for a stable share of the logged in
+	// users, from 0 to 100.
+	Percentage int      `json:"percentage"`
+	Users      []int    `json:"users"`
+	Roles      []string `json:"roles"`
+}
+
+// Subject is the user a flag is checked for. The zero value is an anonymous
+// user.
+type Subject struct {
+	UserID int
+	Role   string
+}
+
+// matches reports whether the rule of the named flag turns it on for the
+// subject.
+func (r *Rule) matches(name string, s Subject) bool {
+	switch {
+	case r.Enabled:
+		return true
+	case s.UserID == 0:
+		return false
+	case slices.Contains(r.Users, s.UserID):
+		return true
+	case s.Role != "" && slices.Contains(r.Roles, s.Role):
+		return true
+	}
+	return r.Percentage > 0 && bucket(name, s.UserID) < r.Percentage
+}
+
+// bucket places a user in one of 100 buckets. The flag name is part of the
+// hash, so different flags roll out to different users, and a user stays
+// in the rollout as the percentage grows.
+func bucket(name string, userID int) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + strconv.Itoa(userID)))
+	return int(h.Sum32() % 100)
+}
+
+// Flag is a feature flag with its current rule.
+type Flag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Rule
+	Source Source `json:"source"`
+	// UpdatedBy and UpdatedAt are set for overridden flags.
+	UpdatedBy *int       `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Override is a runtime override of the rule of a flag.
+type Override struct {
+	Name string
+	Rule
+	UpdatedBy *int
+	UpdatedAt time.Time
+}
@@ -0,0 +1,111 @@
+package flags
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+type Repository struct {
+	// db runs the queries rewritten to the dialect.
+	db db.Querier
+}
+
+func NewRepository(db *sql.DB, dialect db.Dialect) *Repository {
+	return &Repository{db: dialect.Wrap(db)}
+}
+
+// List returns all overrides.
+func (r *Repository) List(ctx context.Context) ([]*Override, error) {
+	defer metrics.ObserveQuery("flags", "List", time.Now())
+	query := `
+	SELECT name, enabled, percentage, users, roles, updated_by, updated_at
+	FROM flags.overrides
+	`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []*Override
+	for rows.Next() {
+		var (
+			o     Override
+			users pq.Int64Array
+		)
+		err := rows.Scan(
+			&o.Name,
+			&o.Enabled,
+			&o.Percentage,
+			&users,
+			pq.Array(&o.Roles),
+			&o.UpdatedBy,
+			&o.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range users {
+			o.Users = append(o.Users, int(id))
+		}
+		overrides = append(overrides, &o)
+	}
+	return overrides, rows.Err()
+}
+
+// Upsert creates or replaces the override of a flag.
+func (r *Repository) Upsert(ctx context.Context, o *Override) error {
+	defer metrics.ObserveQuery("flags", "Upsert", time.Now())
+	query := `
+	INSERT INTO flags.overrides (name, enabled, percentage, users, roles, updated_by)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (name) DO UPDATE
+	SET enabled = EXCLUDED.enabled,
+		percentage = EXCLUDED.percentage,
+		users = EXCLUDED.users,
+		roles = EXCLUDED.roles,
+		updated_by = EXCLUDED.updated_by,
+		updated_at = now()
+	RETURNING updated_at
+	`
+	users := make(pq.Int64Array, len(o.Users))
+	for i, id := range o.Users {
+		users[i] = int64(id)
+	}
+	roles := o.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		o.Name,
+		o.Enabled,
+		o.Percentage,
+		users,
+		pq.Array(roles),
+		o.UpdatedBy,
+	).Scan(&o.UpdatedAt)
+}
+
+// Delete removes the override of a flag. It reports whether there was one.
+func (r *Repository) Delete(ctx context.Context, name string) (bool, error) {
+	defer metrics.ObserveQuery("flags", "Delete", time.Now())
+	query := `DELETE FROM flags.overrides WHERE name = $1 RETURNING name`
+	err := r.db.QueryRowContext(ctx, query, name).Scan(&name)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
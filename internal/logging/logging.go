@@ -9,6 +9,10 @@ import (
 
 const LoggerCtxKey common.ContextKey = "logger"
 
+// Level is the minimum level logged by the application loggers. It can be
+// changed while running, e.g. to debug a problem in production.
+var Level = new(slog.LevelVar)
+
 // WithLogger embeds a logger in the given context.
 func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
 	return context.WithValue(ctx, LoggerCtxKey, logger)
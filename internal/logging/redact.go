@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the values of sensitive attributes.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are the attribute keys that are always masked. Keys ending
+// in one of them after an underscore, e.g. new_password, are masked too.
+var sensitiveKeys = []string{
+	"password",
+	"password_hash",
+	"token",
+	"secret",
+	"authorization",
+	"cookie",
+	"email",
+	"dsn",
+	"api_key",
+}
+
+// RedactHandler masks sensitive attributes before passing records on to
+// another handler. An attribute is sensitive if its key is, or if it is a
+// field of a logged struct tagged with `log:"sensitive"`. Groups and
+// structs are masked recursively, using the JSON names of struct fields.
+type RedactHandler struct {
+	next slog.Handler
+	keys map[string]bool
+}
+
+// NewRedactHandler creates a handler masking the built-in sensitive keys
+// and the given ones.
+func NewRedactHandler(next slog.Handler, keys ...string) *RedactHandler {
+	h := &RedactHandler{next: next, keys: make(map[string]bool)}
+	for _, k := range append(sensitiveKeys, keys...) {
+		h.keys[strings.ToLower(k)] = true
+	}
+	return h
+}
+
+func (h *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.redact(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redact(a)
+	}
+	return &RedactHandler{next: h.next.WithAttrs(redacted), keys: h.keys}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{next: h.next.WithGroup(name), keys: h.keys}
+}
+
+// sensitive reports whether the values of a key must be masked.
+func (h *RedactHandler) sensitive(key string) bool {
+	key = strings.ToLower(key)
+	if h.keys[key] {
+		return true
+	}
+	if strings.Contains(key, "_") {
+		for k := range h.keys {
+			if strings.HasSuffix(key, "_"+k) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *RedactHandler) redact(a slog.Attr) slog.Attr {
+	if h.sensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			redacted[i] = h.redact(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindAny:
+		if g, ok := h.redactStruct(v.Any()); ok {
+			return slog.Attr{Key: a.Key, Value: g}
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// redactStruct turns a struct with sensitive fields into a group with the
+// fields masked. Other values are left to the next handler.
+func (h *RedactHandler) redactStruct(v any) (slog.Value, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return slog.Value{}, false
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || !h.hasSensitiveFields(rv.Type()) {
+		return slog.Value{}, false
+	}
+
+	var attrs []slog.Attr
+	for _, f := range structFields(rv.Type()) {
+		if f.sensitive {
+			attrs = append(attrs, slog.String(f.name, Redacted))
+			continue
+		}
+		attrs = append(attrs, h.redact(slog.Any(f.name, rv.Field(f.index).Interface())))
+	}
+	return slog.GroupValue(attrs...), true
+}
+
+// hasSensitiveFields reports whether a struct type has a field that is
+// tagged as sensitive, or named after a sensitive key.
+func (h *RedactHandler) hasSensitiveFields(t reflect.Type) bool {
+	for _, f := range structFields(t) {
+		if f.sensitive || h.sensitive(f.name) {
+			return true
+		}
+	}
+	return false
+}
+
+type structField struct {
+	index     int
+	name      string
+	sensitive bool
+}
+
+// fieldCache holds the logged fields of struct types.
+var fieldCache sync.Map
+
+// structFields returns the exported fields of a struct type that are not
+// hidden from JSON, named as in JSON.
+func structFields(t reflect.Type) []structField {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]structField)
+	}
+
+	var fields []structField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fields = append(fields, structField{
+			index:     i,
+			name:      name,
+			sensitive: f.Tag.Get("log") == "sensitive",
+		})
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"contact" log:"sensitive"`
+	Hidden   string `json:"-"`
+	Profile  *profile
+}
+
+type profile struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+}
+
+type plain struct {
+	Name string `json:"name"`
+}
+
+// newRedactLogger returns a logger masking the given keys, and a function
+// decoding the record it logged last.
+func newRedactLogger(t *testing.T, keys ...string) (*slog.Logger, func() map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	logger := slog.New(NewRedactHandler(slog.NewJSONHandler(&buf, nil), keys...))
+	return logger, func() map[string]any {
+		t.Helper()
+		var record map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatalf("decoding %q: %v", buf.String(), err)
+		}
+		buf.Reset()
+		return record
+	}
+}
+
+func TestRedactHandler(t *testing.T) {
+	logger, last := newRedactLogger(t, "ssn")
+
+	tests := []struct {
+		name string
+		log  func()
+		want string
+	}{
+		{
+			name: "sensitive keys",
+			log: func() {
+				logger.Info("msg", "password", "hunter2", "Authorization", "Bearer x", "ssn", "123", "user", "ada")
+			},
+			want: `{"password":"[REDACTED]","Authorization":"[REDACTED]","ssn":"[REDACTED]","user":"ada"}`,
+		},
+		{
+			name: "key suffixes",
+			log: func() {
+				logger.Info("msg", "new_password", "hunter2", "refresh_token", "x", "tokens", 3, "passwordless", true)
+			},
+			want: `{"new_password":"[REDACTED]","refresh_token":"[REDACTED]","tokens":3,"passwordless":true}`,
+		},
+		{
+			name: "nested groups",
+			log: func() {
+				logger.Info("msg", slog.Group("input", slog.Group("user", "email", "ada@example.com", "id", 1)))
+			},
+			want: `{"input":{"user":{"email":"[REDACTED]","id":1}}}`,
+		},
+		{
+			name: "struct fields",
+			log: func() {
+				logger.Info("msg", "credentials", &credentials{
+					Username: "ada",
+					Password: "hunter2",
+					Email:    "ada@example.com",
+					Hidden:   "hidden",
+					Profile:  &profile{Name: "Ada", Secret: "s"},
+				})
+			},
+			want: `{"credentials":{"username":"ada","password":"[REDACTED]","contact":"[REDACTED]","Profile":{"name":"Ada","secret":"[REDACTED]"}}}`,
+		},
+		{
+			name: "structs without sensitive fields",
+			log:  func() { logger.Info("msg", "value", plain{Name: "Ada"}) },
+			want: `{"value":{"name":"Ada"}}`,
+		},
+		{
+			name: "with attrs",
+			log: func() {
+				logger.With("api_key", "x", slog.Group("db", "dsn", "postgres://")).WithGroup("req").Info("msg", "cookie", "c", "path", "/")
+			},
+			want: `{"api_key":"[REDACTED]","db":{"dsn":"[REDACTED]"},"req":{"cookie":"[REDACTED]","path":"/"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.log()
+			got := last()
+			delete(got, "time")
+			delete(got, "level")
+			delete(got, "msg")
+
+			var want map[string]any
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(want)
+			if !bytes.Equal(gotJSON, wantJSON) {
+				t.Errorf("got\n\t%s\nwant\n\t%s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
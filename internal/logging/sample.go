@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// SamplingHandler drops repeated records below the warn level, so a hot
+// path cannot flood the logs. Within every tick, the first initial records
+// with the same level and message are logged, and then every thereafter-th
+// record. If thereafter is zero, the rest of the tick is dropped.
+type SamplingHandler struct {
+	next  slog.Handler
+	state *samplingState
+}
+
+// samplingState is shared by the handlers derived with WithAttrs and
+// WithGroup, so the counts are per message rather than per logger.
+type samplingState struct {
+	initial    int
+	thereafter int
+	tick       time.Duration
+
+	mu        sync.Mutex
+	tickStart time.Time
+	counts    map[samplingKey]int
+}
+
+type samplingKey struct {
+	level   slog.Level
+	message string
+}
+
+// NewSamplingHandler creates a handler sampling the records passed on to
+// next.
+func NewSamplingHandler(next slog.Handler, initial int, thereafter int, tick time.Duration) *SamplingHandler {
+	return &SamplingHandler{
+		next: next,
+		state: &samplingState{
+			initial:    initial,
+			thereafter: thereafter,
+			tick:       tick,
+			counts:     make(map[samplingKey]int),
+		},
+	}
+}
+
+func (h *SamplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *SamplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn || h.state.sample(r) {
+		return h.next.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (h *SamplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &SamplingHandler{next: h.next.WithAttrs(attrs), state: h.state}
+}
+
+func (h *SamplingHandler) WithGroup(name string) slog.Handler {
+	return &SamplingHandler{next: h.next.WithGroup(name), state: h.state}
+}
+
+// sample reports whether a record is to be logged.
+func (s *samplingState) sample(r slog.Record) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Time.Sub(s.tickStart) >= s.tick || r.Time.Before(s.tickStart) {
+		s.tickStart = r.Time
+		clear(s.counts)
+	}
+
+	key := samplingKey{level: r.Level, message: r.Message}
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.initial {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.initial)%s.thereafter == 0
+}
@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// countingHandler counts the records it handles by message.
+type countingHandler struct {
+	counts map[string]int
+}
+
+func (h *countingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *countingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.counts[r.Message]++
+	return nil
+}
+
+func (h *countingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *countingHandler) WithGroup(string) slog.Handler      { return h }
+
+func TestSamplingHandler(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		initial    int
+		thereafter int
+		level      slog.Level
+		// records are logged a millisecond apart, all within one tick.
+		records int
+		want    int
+	}{
+		{name: "initial and every thereafter", initial: 3, thereafter: 5, level: slog.LevelInfo, records: 20, want: 3 + 3},
+		{name: "drop after initial", initial: 2, thereafter: 0, level: slog.LevelDebug, records: 10, want: 2},
+		{name: "warnings are never dropped", initial: 1, thereafter: 0, level: slog.LevelWarn, records: 10, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &countingHandler{counts: map[string]int{}}
+			h := NewSamplingHandler(next, tt.initial, tt.thereafter, time.Second)
+			for i := range tt.records {
+				r := slog.NewRecord(start.Add(time.Duration(i)*time.Millisecond), tt.level, "hot", 0)
+				if err := h.Handle(ctx, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := next.counts["hot"]; got != tt.want {
+				t.Errorf("got %d records, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSamplingHandlerTick(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	next := &countingHandler{counts: map[string]int{}}
+	h := NewSamplingHandler(next, 2, 0, time.Second)
+
+	log := func(offset time.Duration, message string) {
+		t.Helper()
+		if err := h.Handle(ctx, slog.NewRecord(start.Add(offset), slog.LevelInfo, message, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Messages are counted apart, and the counts are shared by loggers
+	// derived with attributes and groups.
+	for i := range 5 {
+		log(time.Duration(i)*time.Millisecond, "hot")
+		log(time.Duration(i)*time.Millisecond, "other")
+	}
+	derived := h.WithAttrs([]slog.Attr{slog.String("k", "v")}).WithGroup("g")
+	if err := derived.Handle(ctx, slog.NewRecord(start.Add(10*time.Millisecond), slog.LevelInfo, "hot", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if next.counts["hot"] != 2 || next.counts["other"] != 2 {
+		t.Fatalf("got %v, want 2 of each within the first tick", next.counts)
+	}
+
+	// Just before the tick ends the message is still dropped, and the
+	// count restarts once it has passed.
+	log(time.Second-time.Nanosecond, "hot")
+	if next.counts["hot"] != 2 {
+		t.Fatalf("got %d, want the record before the tick boundary dropped", next.counts["hot"])
+	}
+	for i := range 3 {
+		log(time.Second+time.Duration(i)*time.Millisecond, "hot")
+	}
+	if next.counts["hot"] != 4 {
+		t.Fatalf("got %d, want 2 more records in the second tick", next.counts["hot"])
+	}
+
+	// A clock going backwards starts a new tick.
+	log(0, "hot")
+	if next.counts["hot"] != 5 {
+		t.Fatalf("got %d, want the count to restart when time goes backwards", next.counts["hot"])
+	}
+}
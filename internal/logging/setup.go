@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
+
+// New creates the application logger from the config, writing to w if the
+// output is stdout or unset. It also sets the initial level. The returned
+// function closes the output.
+func New(cfg *config.LogConfig, w io.Writer) (*slog.Logger, func() error, error) {
+	noop := func() error { return nil }
+	if cfg == nil {
+		cfg = &config.LogConfig{}
+	}
+
+	if cfg.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, nil, fmt.Errorf("parsing log level: %w", err)
+		}
+		Level.Set(level)
+	}
+
+	closeOutput := noop
+	switch cfg.Output {
+	case "", "stdout":
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening log output: %w", err)
+		}
+		w, closeOutput = f, f.Close
+	}
+
+	opts := &slog.HandlerOptions{Level: Level}
+	var handler slog.Handler
+	switch cfg.Format {
+	case config.LogFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		handler = slog.NewJSONHandler(w, opts)
+	}
+
+	handler = NewRedactHandler(handler, cfg.Redact...)
+	if s := cfg.Sampling; s != nil && s.Enabled {
+		handler = NewSamplingHandler(handler, s.Initial, s.Thereafter, s.Tick)
+	}
+	return slog.New(handler), closeOutput, nil
+}
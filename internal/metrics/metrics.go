@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes the names of all metrics of the application.
+const Namespace = "smartsplit"
+
+// Registry holds the metrics of the application. It is used instead of the
+// global default registry, so only metrics registered on purpose are
+// exposed, and not those of every imported library.
+var Registry = prometheus.NewRegistry()
+
+// Factory creates metrics registered with Registry.
+var Factory = promauto.With(Registry)
+
+var queryDuration = Factory.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of repository queries.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	},
+	[]string{"repository", "query"},
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ObserveQuery records the duration of a repository query. It is meant to be
+// deferred at the start of a repository method:
+//
+//	defer metrics.ObserveQuery("user", "GetByID", time.Now())
+func ObserveQuery(repository, query string, start time.Time) {
+	queryDuration.WithLabelValues(repository, query).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics in Registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,80 @@
+package monolith
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+// clfTimeLayout is the timestamp layout of the Common Log Format.
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// openAccessLog opens the output of the access log, if it is enabled. The
+// returned function closes it.
+func (app *Application) openAccessLog() (func() error, error) {
+	noop := func() error { return nil }
+
+	cfg := app.config.App.AccessLog
+	if cfg == nil || !cfg.Enabled {
+		return noop, nil
+	}
+
+	switch cfg.Output {
+	case "", "stdout":
+		app.accessLog = os.Stdout
+		return noop, nil
+	case "stderr":
+		app.accessLog = os.Stderr
+		return noop, nil
+	}
+
+	f, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening access log: %w", err)
+	}
+	app.accessLog = f
+	return f.Close, nil
+}
+
+// writeAccessLog writes a line in the Common or Combined Log Format. The
+// user is always "-", as the line is written by middleware running before
+// authentication.
+func (app *Application) writeAccessLog(w io.Writer, r *http.Request, rw *responseWriter, start time.Time) {
+	var b bytes.Buffer
+
+	size := "-"
+	if rw.Bytes() > 0 {
+		size = strconv.FormatInt(rw.Bytes(), 10)
+	}
+	fmt.Fprintf(&b, "%s - - [%s] %s %d %s",
+		rest.ClientIP(r),
+		start.Format(clfTimeLayout),
+		strconv.Quote(r.Method+" "+r.RequestURI+" "+r.Proto),
+		rw.Status(),
+		size,
+	)
+	if app.config.App.AccessLog.Format == config.AccessLogCombined {
+		fmt.Fprintf(&b, " %s %s", quoteOrDash(r.Referer()), quoteOrDash(r.UserAgent()))
+	}
+	b.WriteByte('\n')
+
+	// A single write per line, so lines of concurrent requests do not
+	// interleave.
+	if _, err := w.Write(b.Bytes()); err != nil {
+		app.logger.Error("failed to write access log", "error", err)
+	}
+}
+
+func quoteOrDash(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,140 @@
+package monolith
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+func newAccessLogApp(format string) (*Application, *bytes.Buffer) {
+	app := newTestApp(&config.Config{App: &config.AppConfig{
+		Env:       config.TestingEnvironment,
+		AccessLog: &config.AccessLogConfig{Enabled: true, Format: format},
+	}})
+	var buf bytes.Buffer
+	app.accessLog = &buf
+	return app, &buf
+}
+
+func TestAccessLog(t *testing.T) {
+	const clfTime = `\[\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\]`
+
+	tests := []struct {
+		name    string
+		format  string
+		handler http.HandlerFunc
+		header  http.Header
+		want    string
+	}{
+		{
+			name:    "common",
+			format:  config.AccessLogCommon,
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) },
+			want:    `^192\.0\.2\.1 - - ` + clfTime + ` "GET /plans\?id=1 HTTP/1\.1" 200 5\n$`,
+		},
+		{
+			name:    "no body",
+			format:  config.AccessLogCommon,
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+			want:    `" 204 -\n$`,
+		},
+		{
+			name:   "combined",
+			format: config.AccessLogCombined,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "missing", http.StatusNotFound)
+			},
+			header: http.Header{"Referer": {"https://example.com/"}, "User-Agent": {`curl/8.0 "quoted"`}},
+			want:   `" 404 8 "https://example.com/" "curl/8.0 \\"quoted\\""\n$`,
+		},
+		{
+			name:    "combined without headers",
+			format:  config.AccessLogCombined,
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			header:  http.Header{"User-Agent": {""}},
+			want:    `" 200 - "-" "-"\n$`,
+		},
+		{
+			name:   "informational response",
+			format: config.AccessLogCommon,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusCreated)
+			},
+			want: `" 201 -\n$`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, buf := newAccessLogApp(tt.format)
+			r := httptest.NewRequest(http.MethodGet, "/plans?id=1", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			serve(app.logRequest(tt.handler), nil, r)
+
+			if !regexp.MustCompile(tt.want).MatchString(buf.String()) {
+				t.Errorf("got %q, want a match of %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLogRequestID(t *testing.T) {
+	app := newTestApp(nil)
+	var seen string
+	h := app.logRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = rest.RequestIDFromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(rest.RequestIDHeader, "upstream-42")
+	w := serve(h, nil, r)
+	if seen != "upstream-42" || w.Header().Get(rest.RequestIDHeader) != "upstream-42" {
+		t.Errorf("got %q, want the incoming request ID kept", seen)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(rest.RequestIDHeader, "has spaces\n")
+	w = serve(h, nil, r)
+	if seen == "" || strings.ContainsAny(seen, " \n") || w.Header().Get(rest.RequestIDHeader) != seen {
+		t.Errorf("got %q, want an unsafe request ID replaced", seen)
+	}
+}
+
+func TestOpenAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	app, _ := newAccessLogApp(config.AccessLogCommon)
+	app.config.App.AccessLog.Output = path
+
+	closeLog, err := app.openAccessLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(app.logRequest(okHandler), nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	if err := closeLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"GET / HTTP/1.1" 200 -`) {
+		t.Errorf("got %q in the access log", b)
+	}
+
+	app.config.App.AccessLog.Output = filepath.Join(t.TempDir(), "missing", "access.log")
+	if _, err := app.openAccessLog(); err == nil {
+		t.Error("got no error opening a file in a missing directory")
+	}
+}
@@ -5,17 +5,26 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"reflect"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/justinas/alice"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 
 	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/events"
+	"github.com/evenlwanvik/smartsplit/internal/flags"
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+	"github.com/evenlwanvik/smartsplit/internal/scheduler"
 )
 
 type Application struct {
@@ -24,7 +33,24 @@ type Application struct {
 	config  *config.Config
 	logger  *slog.Logger
 	modules Modules
-	done    <-chan os.Signal
+	// ordered holds the modules in dependency order once set up, and
+	// started the ones that have been started.
+	ordered   []Module
+	started   []Module
+	limiter   *rateLimiter
+	ipLimiter *rateLimiter
+	scheduler *scheduler.Scheduler
+	events    *events.Bus
+	flags     *flags.Flags
+	done      <-chan os.Signal
+	// accessLog is where access log lines are written, or nil if the
+	// access log is disabled.
+	accessLog io.Writer
+
+	healthMu     sync.Mutex
+	healthChecks []healthCheck
+	// draining is set once shutdown starts, failing the readiness probe.
+	draining atomic.Bool
 }
 
 func NewApplication(
@@ -33,106 +59,258 @@ func NewApplication(
 	logger *slog.Logger,
 	config *config.Config,
 	modules Modules,
-) *Application {
-	return &Application{
+) (*Application, error) {
+	app := &Application{
 		db:      db,
 		mux:     mux,
 		logger:  logger,
 		config:  config,
 		modules: modules,
 	}
+	var err error
+	app.scheduler, err = scheduler.New(db, app.Dialect(), logger, instanceName())
+	if err != nil {
+		return nil, fmt.Errorf("creating scheduler: %w", err)
+	}
+	app.events = events.NewBus(db, app.Dialect(), logger)
+	app.flags = flags.New(db, app.Dialect(), logger, config.Flags)
+	err = app.scheduler.Register(scheduler.Job{
+		Name:     "events.prune_outbox",
+		Schedule: scheduler.MustCron("30 4 * * *"),
+		Run: func(ctx context.Context) error {
+			n, err := app.events.Prune(ctx)
+			if n > 0 {
+				logging.LoggerFromContext(ctx).Info("pruned published events", "count", n)
+			}
+			return err
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The pool stats are registered once per database, as registering them
+	// again panics.
+	if cfg := config.App.Metrics; cfg != nil && cfg.Enabled {
+		metrics.Registry.MustRegister(collectors.NewDBStatsCollector(db, metrics.Namespace))
+	}
+	app.RegisterHealthCheck("database", app.pingDatabase)
+	app.RegisterHealthCheck("scheduler", app.scheduler.Check)
+	return app, nil
+}
+
+// instanceName identifies the instance in the job run history.
+func instanceName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
 }
 
-func (app *Application) DB() *sql.DB            { return app.db }
-func (app *Application) Logger() *slog.Logger   { return app.logger }
-func (app *Application) Mux() *http.ServeMux    { return app.mux }
-func (app *Application) Config() *config.Config { return app.config }
+func (app *Application) DB() *sql.DB                     { return app.db }
+func (app *Application) Dialect() db.Dialect             { return db.Dialect(app.config.Database.Driver) }
+func (app *Application) Logger() *slog.Logger            { return app.logger }
+func (app *Application) Mux() *http.ServeMux             { return app.mux }
+func (app *Application) Config() *config.Config          { return app.config }
+func (app *Application) Scheduler() *scheduler.Scheduler { return app.scheduler }
+func (app *Application) Events() *events.Bus             { return app.events }
+func (app *Application) Flags() *flags.Flags             { return app.flags }
 func (app *Application) Modules() *Modules {
 	return &app.modules
 }
 
-func (app *Application) SetupModules(ctx context.Context) {
-	app.logger.Info("running setupModules")
-	val := reflect.ValueOf(app.modules)
-
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
+// ShutdownTimeout returns how long shutting down may take.
+func (app *Application) ShutdownTimeout() time.Duration {
+	if app.config.App.ShutdownTimeout > 0 {
+		return app.config.App.ShutdownTimeout
 	}
+	return 30 * time.Second
+}
 
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-		if module, ok := field.Interface().(Module); ok {
-			module.Setup(ctx, app)
+// timeouts returns the read, write and idle timeouts of the server.
+func (app *Application) timeouts() (time.Duration, time.Duration, time.Duration) {
+	read, write, idle := 5*time.Second, 10*time.Second, time.Minute
+	if t := app.config.App.Timeouts; t != nil {
+		if t.Read > 0 {
+			read = t.Read
+		}
+		if t.Write > 0 {
+			write = t.Write
+		}
+		if t.Idle > 0 {
+			idle = t.Idle
 		}
 	}
+	return read, write, idle
 }
 
-func (app *Application) PostSetupModules() {
-	app.logger.Info("running postSetupModules")
-	val := reflect.ValueOf(app.modules)
-
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
+// protocols returns the protocols served. HTTP/2 is negotiated over TLS,
+// and served as cleartext h2c without it.
+func (app *Application) protocols() *http.Protocols {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	if app.config.App.HTTP2 {
+		if tls := app.config.App.TLS; tls != nil && tls.Enabled {
+			p.SetHTTP2(true)
+		} else {
+			p.SetUnencryptedHTTP2(true)
+		}
 	}
+	return &p
+}
 
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
+func (app *Application) routes() http.Handler {
+	app.logger.Info("creating standard middleware chain")
+	standard := alice.New(
+		app.instrument,
+		app.trace,
+		app.logRequest,
+		// Panics are recovered inside the logging and tracing, so the
+		// resulting 500 responses are logged and traced.
+		app.recoverPanic,
+	)
 
-		if module, ok := field.Interface().(Module); ok {
-			module.PostSetup()
+	// The security headers are set on every response, and CORS preflights
+	// are answered before authentication, as they carry no credentials. The
+	// CSRF check runs after it, as token authenticated requests are exempt.
+	security := app.config.App.Security
+	if security != nil {
+		standard = standard.Append(app.securityHeaders)
+		if cors := security.CORS; cors != nil && len(cors.AllowedOrigins) > 0 {
+			app.logger.Info("enabling CORS", "origins", cors.AllowedOrigins)
+			standard = standard.Append(app.cors)
 		}
 	}
-}
-
-func (app *Application) ShutdownModules() {
-	app.logger.Info("running shutdownModules")
-	val := reflect.ValueOf(app.modules)
+	// The IP limit runs before authentication, so floods of requests with
+	// bad credentials are rejected before they are looked up.
+	limiter := app.config.App.Limiter
+	if limiter != nil && limiter.Enabled && limiter.IP != nil {
+		app.logger.Info("enabling IP rate limiter", "rps", limiter.IP.RPS, "burst", limiter.IP.Burst)
+		app.ipLimiter = newIPRateLimiter(limiter, app.mux)
+		standard = standard.Append(app.ipLimiter.Middleware)
+	}
+	standard = standard.Append(app.modules.Auth.Audit, app.modules.Auth.Authenticate)
+	if security != nil && security.CSRF != nil && security.CSRF.Enabled {
+		app.logger.Info("enabling CSRF protection")
+		standard = standard.Append(app.csrf)
+	}
 
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
+	// The limiter runs after authentication, so authenticated clients are
+	// limited per user or token rather than per IP.
+	if limiter != nil && limiter.Enabled {
+		app.logger.Info("enabling rate limiter", "rps", limiter.RPS, "burst", limiter.Burst)
+		app.limiter = newRateLimiter(limiter, app.mux)
+		standard = standard.Append(app.limiter.Middleware)
 	}
 
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
+	app.logger.Info("adding healthcheck routes")
+	app.registerHealthRoutes()
 
-		if module, ok := field.Interface().(Module); ok {
-			module.Shutdown()
-		}
+	if cfg := app.config.App.Metrics; cfg != nil && cfg.Enabled && cfg.Port == 0 {
+		app.logger.Info("adding admin only metrics route", "path", cfg.Path)
+		app.registerMetricsRoute()
 	}
-}
 
-func (app *Application) routes() http.Handler {
-	app.logger.Info("creating standard middleware chain")
-	standard := alice.New(
-		app.recoverPanic,
-		app.logRequest,
-	)
+	app.logger.Info("adding job routes")
+	app.registerJobRoutes()
+
+	app.logger.Info("adding feature flag routes")
+	app.registerFlagRoutes()
 
-	// healthcheck
-	app.logger.Info("adding healthcheck route")
-	app.mux.HandleFunc("GET /api/v1/healthcheck", app.healthcheckHandler)
+	app.logger.Info("adding log level routes")
+	app.registerLogRoutes()
 
-	// profiling
-	app.mux.HandleFunc("GET /debug/pprof/", http.DefaultServeMux.ServeHTTP)
-	app.mux.HandleFunc("GET /debug/pprof/profile", http.DefaultServeMux.ServeHTTP)
-	app.mux.HandleFunc("GET /debug/pprof/heap", http.DefaultServeMux.ServeHTTP)
+	// profiling and runtime debugging
+	if cfg := app.config.App.Debug; cfg.Enabled(app.config.App.Env) && cfg.Port == 0 {
+		app.logger.Info("adding admin only debug routes")
+		app.registerDebugRoutes(app.mux, true)
+	}
 
 	handler := standard.Then(app.mux)
 	return handler
 }
 
+// metricsServer returns the server of the metrics endpoint if it is served
+// on a separate port, or nil otherwise.
+func (app *Application) metricsServer() *http.Server {
+	cfg := app.config.App.Metrics
+	if cfg == nil || !cfg.Enabled || cfg.Port == 0 {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET "+cfg.Path, metrics.Handler())
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Port),
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+}
+
 func (app *Application) Serve() error {
+	closeAccessLog, err := app.openAccessLog()
+	if err != nil {
+		return err
+	}
+	defer closeAccessLog()
+
+	read, write, idle := app.timeouts()
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.App.Port),
 		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  idle,
+		ReadTimeout:  read,
+		WriteTimeout: write,
+		Protocols:    app.protocols(),
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
 	shutdownError := make(chan error)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, l := range []*rateLimiter{app.limiter, app.ipLimiter} {
+		if l != nil {
+			go l.collect(ctx)
+		}
+	}
+
+	if cfg := app.config.App.TLS; cfg != nil && cfg.Enabled {
+		certs, err := newCertReloader(cfg, app.logger)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = certs.tlsConfig()
+		// The server can still serve the loaded certificate if the files
+		// cannot be watched.
+		if err := certs.watch(ctx); err != nil {
+			app.logger.Warn("failed to watch TLS certificate, it will not be reloaded", "error", err)
+		}
+	}
+
+	// Internal servers and the HTTPS redirect failing to start do not stop
+	// the application, as it can serve requests without them.
+	var internalSrvs []*http.Server
+	for name, internalSrv := range map[string]*http.Server{
+		"metrics":  app.metricsServer(),
+		"debug":    app.debugServer(),
+		"redirect": app.redirectServer(),
+	} {
+		if internalSrv == nil {
+			continue
+		}
+		internalSrvs = append(internalSrvs, internalSrv)
+		app.logger.Info("starting internal server", "server", name, "addr", internalSrv.Addr)
+		go func() {
+			err := internalSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("internal server failed", "server", name, "error", err)
+			}
+		}()
+	}
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -140,15 +318,39 @@ func (app *Application) Serve() error {
 
 		slog.Info("shutting down server", "signal", s.String())
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// Fail the readiness probe, and keep serving until load balancers
+		// have noticed and stopped sending new requests.
+		app.draining.Store(true)
+		if delay := app.config.App.DrainDelay; delay > 0 {
+			slog.Info("draining traffic", "delay", delay)
+			time.Sleep(delay)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout())
 		defer cancel()
 
-		shutdownError <- srv.Shutdown(ctx)
+		// Metrics are scraped until the server has stopped, so the final
+		// requests are accounted for, and profiles can be taken of a stuck
+		// shutdown.
+		err := srv.Shutdown(ctx)
+		for _, internalSrv := range internalSrvs {
+			err = errors.Join(err, internalSrv.Shutdown(ctx))
+		}
+		shutdownError <- err
 	}()
 
-	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.App.Env)
+	app.logger.Info("starting server",
+		"addr", srv.Addr,
+		"env", app.config.App.Env,
+		"tls", srv.TLSConfig != nil,
+		"http2", app.config.App.HTTP2,
+	)
 
-	err := srv.ListenAndServe()
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
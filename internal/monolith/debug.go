@@ -0,0 +1,91 @@
+package monolith
+
+import (
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// debugRoutes returns the profiling and runtime debug endpoints. The
+// profile and trace endpoints take longer than the write timeout of the
+// server by default, so they extend it.
+func (app *Application) debugRoutes() rest.RouteDefinitionList {
+	return rest.RouteDefinitionList{
+		{Path: "GET /debug/pprof/", Handler: pprof.Index},
+		{Path: "GET /debug/pprof/cmdline", Handler: pprof.Cmdline},
+		{Path: "GET /debug/pprof/profile", Handler: extendWriteDeadline(pprof.Profile)},
+		{Path: "GET /debug/pprof/symbol", Handler: pprof.Symbol},
+		{Path: "POST /debug/pprof/symbol", Handler: pprof.Symbol},
+		{Path: "GET /debug/pprof/trace", Handler: extendWriteDeadline(pprof.Trace)},
+		{Path: "GET /debug/vars", Handler: expvar.Handler().ServeHTTP},
+		{Path: "GET /debug/goroutines", Handler: goroutinesHandler},
+	}
+}
+
+// registerDebugRoutes serves the debug endpoints if enabled, either on the
+// application mux to admins only, or on a mux of their own.
+func (app *Application) registerDebugRoutes(mux *http.ServeMux, adminOnly bool) {
+	for _, d := range app.debugRoutes() {
+		if adminOnly {
+			d.Handler = auth.RequireAdmin(d.Handler)
+		}
+		d.Class = rest.RateClassExempt
+		app.logger.Info("adding route", "route", d.Path)
+		mux.Handle(d.Path, d)
+	}
+}
+
+// debugServer returns the server of the debug endpoints if they are served
+// on a separate port, or nil otherwise. It only listens on localhost, as the
+// endpoints are not authenticated.
+func (app *Application) debugServer() *http.Server {
+	cfg := app.config.App.Debug
+	if !cfg.Enabled(app.config.App.Env) || cfg.Port == 0 {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	app.registerDebugRoutes(mux, false)
+	return &http.Server{
+		Addr:        fmt.Sprintf("127.0.0.1:%d", cfg.Port),
+		Handler:     mux,
+		ReadTimeout: 5 * time.Second,
+		ErrorLog:    slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+}
+
+// extendWriteDeadline lets a handler write for as long as the seconds query
+// parameter asks for, plus some slack.
+func extendWriteDeadline(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		seconds := 30
+		if s := rest.GetQueryParamInt(r, "seconds"); s != nil && *s > 0 {
+			seconds = *s
+		}
+		deadline := time.Now().Add(time.Duration(seconds)*time.Second + 10*time.Second)
+		_ = http.NewResponseController(w).SetWriteDeadline(deadline)
+		next(w, r)
+	}
+}
+
+// goroutinesHandler dumps the stack of every goroutine as text.
+func goroutinesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := runtimepprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		rest.InternalServerErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,126 @@
+package monolith
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+)
+
+// recordingAuth records the audit entries of other modules. Its other
+// methods are not implemented.
+type recordingAuth struct {
+	auth.Client
+	actions []string
+}
+
+func (a *recordingAuth) RecordAudit(_ context.Context, action string, _ string, _ string, _ any, _ any) {
+	a.actions = append(a.actions, action)
+}
+
+func TestDebugRoutesAdminOnly(t *testing.T) {
+	app := newTestApp(nil)
+	mux := http.NewServeMux()
+	app.registerDebugRoutes(mux, true)
+
+	user := &auth.Principal{UserID: 2, Role: auth.RoleUser}
+	for _, target := range []string{"/debug/pprof/", "/debug/vars", "/debug/goroutines"} {
+		t.Run(target, func(t *testing.T) {
+			if w := serve(mux, nil, httptest.NewRequest(http.MethodGet, target, nil)); w.Code != http.StatusUnauthorized {
+				t.Errorf("got %d for anonymous users, want %d", w.Code, http.StatusUnauthorized)
+			}
+			if w := serve(mux, user, httptest.NewRequest(http.MethodGet, target, nil)); w.Code != http.StatusForbidden {
+				t.Errorf("got %d for users, want %d", w.Code, http.StatusForbidden)
+			}
+			if w := serve(mux, admin, httptest.NewRequest(http.MethodGet, target, nil)); w.Code != http.StatusOK {
+				t.Errorf("got %d for admins, want %d", w.Code, http.StatusOK)
+			}
+		})
+	}
+
+	w := serve(mux, admin, httptest.NewRequest(http.MethodGet, "/debug/goroutines", nil))
+	if !strings.Contains(w.Body.String(), "goroutine ") {
+		t.Errorf("got %q, want the goroutine stacks", w.Body.String())
+	}
+	var vars map[string]any
+	w = serve(mux, admin, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if err := json.NewDecoder(w.Body).Decode(&vars); err != nil || vars["goroutines"] == nil {
+		t.Errorf("got %v, %v, want the goroutine count published", vars, err)
+	}
+}
+
+func TestDebugServer(t *testing.T) {
+	tests := []struct {
+		name  string
+		debug *config.DebugConfig
+		want  string
+	}{
+		{name: "disabled", debug: nil},
+		{name: "other environment", debug: &config.DebugConfig{Environments: []config.Environment{config.DevelopmentEnvironment}, Port: 6060}},
+		{name: "on the application port", debug: &config.DebugConfig{Environments: []config.Environment{config.TestingEnvironment}}},
+		{name: "own port", debug: &config.DebugConfig{Environments: []config.Environment{config.TestingEnvironment}, Port: 6060}, want: "127.0.0.1:6060"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(&config.Config{App: &config.AppConfig{Env: config.TestingEnvironment, Debug: tt.debug}})
+			srv := app.debugServer()
+			if tt.want == "" {
+				if srv != nil {
+					t.Errorf("got a debug server on %s", srv.Addr)
+				}
+				return
+			}
+			if srv == nil || srv.Addr != tt.want {
+				t.Fatalf("got %v, want a debug server on %s", srv, tt.want)
+			}
+			// The separate port is not authenticated.
+			if w := serve(srv.Handler, nil, httptest.NewRequest(http.MethodGet, "/debug/vars", nil)); w.Code != http.StatusOK {
+				t.Errorf("got %d, want %d", w.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestLogLevelRoutes(t *testing.T) {
+	defer logging.Level.Set(logging.Level.Level())
+	logging.Level.Set(slog.LevelInfo)
+
+	audit := &recordingAuth{}
+	app := newTestApp(nil)
+	app.modules.Auth = audit
+	app.registerLogRoutes()
+
+	level := func(principal *auth.Principal, method, body string) (int, string) {
+		t.Helper()
+		w := serve(app.mux, principal, httptest.NewRequest(method, "/api/v0/log/level", strings.NewReader(body)))
+		var msg LogLevelMessage
+		json.NewDecoder(w.Body).Decode(&msg)
+		return w.Code, msg.Level
+	}
+
+	if code, got := level(admin, http.MethodGet, ""); code != http.StatusOK || got != "info" {
+		t.Fatalf("got %d and level %q, want info", code, got)
+	}
+	if code, _ := level(&auth.Principal{UserID: 2, Role: auth.RoleUser}, http.MethodPut, `{"level":"debug"}`); code != http.StatusForbidden {
+		t.Errorf("got %d setting the level as a user, want %d", code, http.StatusForbidden)
+	}
+	if code, _ := level(admin, http.MethodPut, `{"level":"verbose"}`); code != http.StatusBadRequest {
+		t.Errorf("got %d for an unknown level, want %d", code, http.StatusBadRequest)
+	}
+	if code, got := level(admin, http.MethodPut, `{"level":"DEBUG"}`); code != http.StatusOK || got != "debug" {
+		t.Fatalf("got %d and level %q, want debug", code, got)
+	}
+	if logging.Level.Level() != slog.LevelDebug {
+		t.Errorf("got level %v, want the loggers changed", logging.Level.Level())
+	}
+	if len(audit.actions) != 1 || audit.actions[0] != "debug.log_level" {
+		t.Errorf("got audit actions %v, want the change recorded", audit.actions)
+	}
+}
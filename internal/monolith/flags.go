@@ -0,0 +1,155 @@
+package monolith
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/flags"
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+func (app *Application) registerFlagRoutes() {
+	routeDefinitions := rest.RouteDefinitionList{
+		{
+			Path:    "GET /api/v0/flags",
+			Handler: auth.RequireAdmin(app.listFlagsHandler),
+		},
+		{
+			Path:    "GET /api/v0/flags/{name}",
+			Handler: auth.RequireAdmin(app.getFlagHandler),
+		},
+		{
+			Path:    "PUT /api/v0/flags/{name}",
+			Handler: auth.RequireAdmin(app.overrideFlagHandler),
+		},
+		{
+			Path:    "DELETE /api/v0/flags/{name}",
+			Handler: auth.RequireAdmin(app.resetFlagHandler),
+		},
+	}
+
+	for _, d := range routeDefinitions {
+		app.logger.Info("adding route", "route", d.Path)
+		app.mux.Handle(d.Path, d)
+	}
+}
+
+func (app *Application) listFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	err := rest.WriteJSONResponse(w, http.StatusOK, app.flags.List())
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *Application) getFlagHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	flag, err := app.flags.Get(r.PathValue("name"))
+	if err != nil {
+		rest.NotFoundResponse(w, r, err)
+		return
+	}
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, flag)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// overrideFlagHandler replaces the rule of a flag, until it is reset.
+func (app *Application) overrideFlagHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	name := r.PathValue("name")
+	var input flags.Rule
+	if err := rest.DecodeJSONFromRequest(r, &input); err != nil {
+		rest.BadRequestResponse(w, r, rest.UnableToDecodeRequestBody, err)
+		return
+	}
+	logger = logger.With(slog.Group("input", slog.String("name", name), slog.Any("rule", input)))
+
+	before, err := app.flags.Get(name)
+	if err != nil {
+		rest.NotFoundResponse(w, r, err)
+		return
+	}
+
+	var updatedBy *int
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		updatedBy = &principal.UserID
+	}
+
+	logger.Info("overriding feature flag")
+	after, err := app.flags.Override(ctx, name, input, updatedBy)
+	if err != nil {
+		logger.Error("failed to override feature flag", "error", err)
+		switch {
+		case errors.Is(err, flags.ErrUnknownFlag):
+			rest.NotFoundResponse(w, r, err)
+		case errors.Is(err, flags.ErrInvalidRule):
+			rest.BadRequestResponse(w, r, err.Error(), err)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.modules.Auth.RecordAudit(ctx, "flag.override", "flag", name, before.Rule, after.Rule)
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, after)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// resetFlagHandler deletes the override of a flag, returning it to the rule
+// in the config.
+func (app *Application) resetFlagHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	name := r.PathValue("name")
+	logger = logger.With(slog.Group("input", slog.String("name", name)))
+
+	before, err := app.flags.Get(name)
+	if err != nil {
+		rest.NotFoundResponse(w, r, err)
+		return
+	}
+
+	logger.Info("resetting feature flag")
+	after, err := app.flags.Reset(ctx, name)
+	if err != nil {
+		logger.Error("failed to reset feature flag", "error", err)
+		switch {
+		case errors.Is(err, flags.ErrUnknownFlag):
+			rest.NotFoundResponse(w, r, err)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.modules.Auth.RecordAudit(ctx, "flag.reset", "flag", name, before.Rule, after.Rule)
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, after)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
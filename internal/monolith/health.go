@@ -0,0 +1,109 @@
+package monolith
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout limits how long a single health check may take.
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheckFunc reports whether a dependency of the application is
+// healthy, by returning an error if it is not.
+type HealthCheckFunc func(ctx context.Context) error
+
+type healthCheck struct {
+	name  string
+	check HealthCheckFunc
+}
+
+const (
+	HealthStatusOK       = "ok"
+	HealthStatusFailing  = "failing"
+	HealthStatusDraining = "draining"
+)
+
+// HealthCheckResult is the outcome of a single health check.
+type HealthCheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport aggregates the outcome of all health checks. The status is
+// only ok if every check is.
+type HealthReport struct {
+	Status string               `json:"status"`
+	Checks []*HealthCheckResult `json:"checks"`
+}
+
+// RegisterHealthCheck adds a check to the readiness probe. The check is given
+// a context with a timeout, and should return once it is done.
+func (app *Application) RegisterHealthCheck(name string, check HealthCheckFunc) {
+	app.healthMu.Lock()
+	defer app.healthMu.Unlock()
+
+	app.logger.Info("registering health check", "check", name)
+	app.healthChecks = append(app.healthChecks, healthCheck{name: name, check: check})
+}
+
+// checkHealth runs every health check concurrently.
+func (app *Application) checkHealth(ctx context.Context) *HealthReport {
+	app.healthMu.Lock()
+	checks := append([]healthCheck(nil), app.healthChecks...)
+	app.healthMu.Unlock()
+
+	report := &HealthReport{
+		Status: HealthStatusOK,
+		Checks: make([]*HealthCheckResult, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = runHealthCheck(ctx, c)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != HealthStatusOK {
+			report.Status = HealthStatusFailing
+		}
+	}
+	if app.draining.Load() {
+		report.Status = HealthStatusDraining
+	}
+	return report
+}
+
+func runHealthCheck(ctx context.Context, c healthCheck) (result *HealthCheckResult) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	result = &HealthCheckResult{Name: c.name, Status: HealthStatusOK}
+	start := time.Now()
+	defer func() {
+		if err := recover(); err != nil {
+			result.Status = HealthStatusFailing
+			result.Error = fmt.Sprintf("check panicked: %v", err)
+		}
+		result.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	}()
+
+	if err := c.check(ctx); err != nil {
+		result.Status = HealthStatusFailing
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// pingDatabase checks that a connection to the database can be made.
+func (app *Application) pingDatabase(ctx context.Context) error {
+	return app.db.PingContext(ctx)
+}
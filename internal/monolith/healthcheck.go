@@ -3,27 +3,91 @@ package monolith
 import (
 	"net/http"
 
+	"github.com/evenlwanvik/smartsplit/internal/auth"
 	"github.com/evenlwanvik/smartsplit/internal/config"
 	"github.com/evenlwanvik/smartsplit/internal/rest"
 )
 
+// registerHealthRoutes adds the probes. Only the liveness probe is exempt from
+// the rate limit, as it is cheap. The readiness probes run the health checks,
+// which query the database, so they are limited like any other route.
+func (app *Application) registerHealthRoutes() {
+	probes := rest.RouteDefinitionList{
+		{Path: "GET /api/v1/healthcheck", Handler: app.healthcheckHandler},
+		{Path: "GET /livez", Handler: app.livezHandler, Class: rest.RateClassExempt},
+		{Path: "GET /readyz", Handler: app.readyzHandler},
+	}
+	for _, d := range probes {
+		app.mux.Handle(d.Path, d)
+	}
+}
+
 type HealthCheckMessage struct {
 	Status      string             `json:"status"`
 	Environment config.Environment `json:"environment"`
 }
 
 // @Summary Healthcheck
-// @Description Endpoint to check if the API is running
+// @Description Endpoint to check if the API is running, and its health checks
+// @Description are passing
 // @Tags    Healthcheck
 // @Produce json
 // @Success 200 {object} HealthCheckMessage "OK"
-// @Failure 500 {object} rest.ErrorMessage "Internal Server Error"
+// @Failure 503 {object} HealthCheckMessage "Unavailable"
 // @Router /smartsplit/v1/healthcheck [get]
 func (app *Application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	report := app.checkHealth(r.Context())
+
 	healthCheckMessage := HealthCheckMessage{
 		Status:      "available",
 		Environment: app.config.App.Env,
 	}
+	status := http.StatusOK
+	if report.Status != HealthStatusOK {
+		healthCheckMessage.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	rest.RespondWithJSON(w, r, status, healthCheckMessage, http.Header{"Cache-Control": {"no-store"}})
+}
 
-	rest.RespondWithJSON(w, r, http.StatusOK, healthCheckMessage, nil)
+// @Summary Liveness probe
+// @Description Reports that the process is running and serving requests. It
+// @Description does not check dependencies, so a failing database does not
+// @Description get the process restarted.
+// @Tags    Healthcheck
+// @Produce json
+// @Success 200 {object} HealthReport "OK"
+// @Router /livez [get]
+func (app *Application) livezHandler(w http.ResponseWriter, r *http.Request) {
+	report := HealthReport{
+		Status: HealthStatusOK,
+		Checks: []*HealthCheckResult{},
+	}
+	rest.RespondWithJSON(w, r, http.StatusOK, report, nil)
+}
+
+// @Summary Readiness probe
+// @Description Runs the health checks registered by the modules, and reports
+// @Description whether the application is ready to receive traffic. It is not
+// @Description ready while shutting down, so load balancers drain it first.
+// @Description The errors of failing checks are only shown to admins.
+// @Tags    Healthcheck
+// @Produce json
+// @Success 200 {object} HealthReport "Ready"
+// @Failure 503 {object} HealthReport "Not ready"
+// @Router /readyz [get]
+func (app *Application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := app.checkHealth(r.Context())
+
+	status := http.StatusOK
+	if report.Status != HealthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	// The errors may hold internal details, such as database addresses.
+	if principal, ok := auth.PrincipalFromContext(r.Context()); !ok || !principal.IsAdmin() {
+		for _, result := range report.Checks {
+			result.Error = ""
+		}
+	}
+	rest.RespondWithJSON(w, r, status, report, http.Header{"Cache-Control": {"no-store"}})
 }
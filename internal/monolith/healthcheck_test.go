@@ -0,0 +1,143 @@
+package monolith
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
+
+const dbError = "dial tcp 10.0.0.5:5432: connect: connection refused"
+
+func newHealthTestApp(failing bool) *Application {
+	app := newTestApp(nil)
+	app.RegisterHealthCheck("ok", func(ctx context.Context) error { return nil })
+	app.RegisterHealthCheck("database", func(ctx context.Context) error {
+		if failing {
+			return errors.New(dbError)
+		}
+		return nil
+	})
+	return app
+}
+
+func TestHealthcheckHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		failing    bool
+		wantCode   int
+		wantStatus string
+	}{
+		{"passing", false, http.StatusOK, "available"},
+		{"failing", true, http.StatusServiceUnavailable, "unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newHealthTestApp(tt.failing)
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/healthcheck", nil)
+			w := serve(http.HandlerFunc(app.healthcheckHandler), nil, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantCode)
+			}
+			var msg HealthCheckMessage
+			if err := json.NewDecoder(w.Body).Decode(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Status != tt.wantStatus {
+				t.Errorf("got %q, want %q", msg.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		failing   bool
+		draining  bool
+		principal *auth.Principal
+		wantCode  int
+		wantError string
+	}{
+		{name: "passing", wantCode: http.StatusOK},
+		{name: "draining", draining: true, wantCode: http.StatusServiceUnavailable},
+		{name: "failing anonymous", failing: true, wantCode: http.StatusServiceUnavailable},
+		{
+			name:      "failing user",
+			failing:   true,
+			principal: &auth.Principal{UserID: 2, Role: auth.RoleUser},
+			wantCode:  http.StatusServiceUnavailable,
+		},
+		{
+			name:      "failing admin",
+			failing:   true,
+			principal: admin,
+			wantCode:  http.StatusServiceUnavailable,
+			wantError: dbError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newHealthTestApp(tt.failing)
+			app.draining.Store(tt.draining)
+			r := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			w := serve(http.HandlerFunc(app.readyzHandler), tt.principal, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantCode)
+			}
+
+			var report HealthReport
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Checks) != 2 {
+				t.Fatalf("got %d checks, want 2", len(report.Checks))
+			}
+			for _, c := range report.Checks {
+				if c.Name != "database" {
+					continue
+				}
+				wantStatus := HealthStatusOK
+				if tt.failing {
+					wantStatus = HealthStatusFailing
+				}
+				if c.Status != wantStatus || c.Error != tt.wantError {
+					t.Errorf("got database check %q with error %q, want %q with %q", c.Status, c.Error, wantStatus, tt.wantError)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthRoutesRateLimit(t *testing.T) {
+	app := newHealthTestApp(false)
+	app.registerHealthRoutes()
+	cfg := &config.LimiterConfig{
+		RPS:     100,
+		Burst:   100,
+		Enabled: true,
+		IP:      &config.LimiterRate{RPS: 0.001, Burst: 1},
+	}
+	h := newIPRateLimiter(cfg, app.mux).Middleware(app.mux)
+
+	// The liveness probe is never limited, but the readiness probes run
+	// the health checks, and share the bucket of the IP.
+	for range 3 {
+		if w := request(h, http.MethodGet, "/livez", "192.0.2.1", 0); w.Code != http.StatusOK {
+			t.Fatalf("got status %d for /livez, want %d", w.Code, http.StatusOK)
+		}
+	}
+	if w := request(h, http.MethodGet, "/readyz", "192.0.2.1", 0); w.Code != http.StatusOK {
+		t.Fatalf("got status %d for /readyz, want %d", w.Code, http.StatusOK)
+	}
+	for _, target := range []string{"/readyz", "/api/v1/healthcheck"} {
+		if w := request(h, http.MethodGet, target, "192.0.2.1", 0); w.Code != http.StatusTooManyRequests {
+			t.Errorf("got status %d for %s, want %d", w.Code, target, http.StatusTooManyRequests)
+		}
+	}
+}
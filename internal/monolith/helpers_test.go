@@ -0,0 +1,53 @@
+package monolith
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	// The tracers are global, so the spans of every test are recorded.
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	os.Exit(m.Run())
+}
+
+// newTestApp returns an application without a database or modules, for
+// testing handlers and middleware on their own.
+func newTestApp(cfg *config.Config) *Application {
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	if cfg.App == nil {
+		cfg.App = &config.AppConfig{Env: config.TestingEnvironment}
+	}
+	return &Application{
+		mux:    http.NewServeMux(),
+		config: cfg,
+		logger: slog.New(slog.DiscardHandler),
+	}
+}
+
+var admin = &auth.Principal{UserID: 1, Role: auth.RoleAdmin}
+
+// serve sends the request as the given principal, or anonymously if it is
+// nil, to the handler.
+func serve(h http.Handler, principal *auth.Principal, r *http.Request) *httptest.ResponseRecorder {
+	if principal != nil {
+		r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,85 @@
+package monolith
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+	"github.com/evenlwanvik/smartsplit/internal/scheduler"
+)
+
+const defaultJobRunsPageSize = 20
+
+func (app *Application) registerJobRoutes() {
+	routeDefinitions := rest.RouteDefinitionList{
+		{
+			Path:    "GET /api/v0/jobs",
+			Handler: auth.RequireAdmin(app.listJobsHandler),
+		},
+		{
+			Path:    "GET /api/v0/jobs/{name}/runs",
+			Handler: auth.RequireAdmin(app.listJobRunsHandler),
+		},
+	}
+
+	for _, d := range routeDefinitions {
+		app.logger.Info("adding route", "route", d.Path)
+		app.mux.Handle(d.Path, d)
+	}
+}
+
+func (app *Application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	jobs, err := app.scheduler.Jobs(ctx)
+	if err != nil {
+		logger.Error("failed to list jobs", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, jobs)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *Application) listJobRunsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	name := r.PathValue("name")
+	pageSize := defaultJobRunsPageSize
+	if n := rest.GetQueryParamInt(r, "page_size"); n != nil && *n > 0 {
+		pageSize = min(*n, 100)
+	}
+	logger = logger.With(slog.Group("input", slog.String("name", name), slog.Int("page_size", pageSize)))
+
+	runs, err := app.scheduler.Runs(ctx, name, pageSize)
+	if err != nil {
+		logger.Error("failed to list job runs", "error", err)
+		switch {
+		case errors.Is(err, scheduler.ErrUnknownJob):
+			rest.NotFoundResponse(w, r, err)
+		default:
+			rest.InternalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	if runs == nil {
+		runs = []*scheduler.Run{}
+	}
+
+	err = rest.WriteJSONResponse(w, http.StatusOK, runs)
+	if err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,163 @@
+package monolith
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrUnknownDependency is returned when a module depends on a module
+	// that does not exist.
+	ErrUnknownDependency = errors.New("unknown module dependency")
+	// ErrDependencyCycle is returned when modules depend on each other, and
+	// cannot be ordered.
+	ErrDependencyCycle = errors.New("module dependency cycle")
+)
+
+// listModules returns the modules set in the Modules struct, in field order.
+func (app *Application) listModules() []Module {
+	var modules []Module
+	val := reflect.ValueOf(app.modules)
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Field(i)
+		if field.IsNil() {
+			continue
+		}
+		if module, ok := field.Interface().(Module); ok {
+			modules = append(modules, module)
+		}
+	}
+	return modules
+}
+
+// sortModules orders the modules so every module comes after its
+// dependencies. Modules without a dependency between them keep their
+// relative order, so the order is the same on every run.
+func sortModules(modules []Module) ([]Module, error) {
+	byName := make(map[string]Module, len(modules))
+	for _, m := range modules {
+		if _, ok := byName[m.Name()]; ok {
+			return nil, fmt.Errorf("duplicate module name %q", m.Name())
+		}
+		byName[m.Name()] = m
+	}
+	for _, m := range modules {
+		for _, dep := range m.Dependencies() {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("%w: %s depends on %s", ErrUnknownDependency, m.Name(), dep)
+			}
+		}
+	}
+
+	sorted := make([]Module, 0, len(modules))
+	done := make(map[string]bool, len(modules))
+	for len(sorted) < len(modules) {
+		progress := false
+		for _, m := range modules {
+			if done[m.Name()] {
+				continue
+			}
+			ready := !slices.ContainsFunc(m.Dependencies(), func(dep string) bool {
+				return !done[dep]
+			})
+			if ready {
+				sorted = append(sorted, m)
+				done[m.Name()] = true
+				progress = true
+			}
+		}
+		if !progress {
+			var remaining []string
+			for _, m := range modules {
+				if !done[m.Name()] {
+					remaining = append(remaining, m.Name())
+				}
+			}
+			return nil, fmt.Errorf("%w between %s", ErrDependencyCycle, strings.Join(remaining, ", "))
+		}
+	}
+	return sorted, nil
+}
+
+// SetupModules sets up the modules in dependency order, and stops at the
+// first module failing to set up.
+func (app *Application) SetupModules(ctx context.Context) error {
+	app.logger.Info("running setupModules")
+
+	ordered, err := sortModules(app.listModules())
+	if err != nil {
+		return err
+	}
+
+	for _, m := range ordered {
+		app.logger.Info("setting up module", "module", m.Name())
+		if err := m.Setup(ctx, app); err != nil {
+			return fmt.Errorf("setting up module %s: %w", m.Name(), err)
+		}
+	}
+	app.ordered = ordered
+	return nil
+}
+
+// StartModules loads the feature flag overrides, starts the modules in
+// dependency order, and then the scheduler running the jobs they registered
+// and the bus delivering events to their subscribers. If a module fails to
+// start, the modules started before it are stopped again.
+func (app *Application) StartModules(ctx context.Context) error {
+	app.logger.Info("running startModules")
+	app.flags.Start(ctx)
+
+	for _, m := range app.ordered {
+		app.logger.Info("starting module", "module", m.Name())
+		if err := m.Start(ctx); err != nil {
+			err = fmt.Errorf("starting module %s: %w", m.Name(), err)
+			if stopErr := app.StopModules(context.WithoutCancel(ctx)); stopErr != nil {
+				err = errors.Join(err, stopErr)
+			}
+			return err
+		}
+		app.started = append(app.started, m)
+	}
+
+	app.scheduler.Start(ctx)
+	app.events.Start(ctx)
+	return nil
+}
+
+// StopModules drains the event bus and the scheduler first, as subscribers
+// and jobs use the modules, and then stops the started modules in reverse
+// dependency order, so no module is stopped while a module depending on it
+// is still running. Every module is asked to stop even if some fail, or the
+// deadline of the context has passed, and all the errors are returned.
+func (app *Application) StopModules(ctx context.Context) error {
+	app.logger.Info("running stopModules")
+
+	var errs []error
+	if err := app.events.Stop(ctx); err != nil {
+		app.logger.Error("failed to stop event bus", "error", err)
+		errs = append(errs, err)
+	}
+	if err := app.scheduler.Stop(ctx); err != nil {
+		app.logger.Error("failed to stop scheduler", "error", err)
+		errs = append(errs, err)
+	}
+
+	for i := len(app.started) - 1; i >= 0; i-- {
+		m := app.started[i]
+		app.logger.Info("stopping module", "module", m.Name())
+		if err := m.Stop(ctx); err != nil {
+			app.logger.Error("failed to stop module", "module", m.Name(), "error", err)
+			errs = append(errs, fmt.Errorf("stopping module %s: %w", m.Name(), err))
+		}
+	}
+	app.started = nil
+
+	if err := app.flags.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("stopping feature flags: %w", err))
+	}
+	return errors.Join(errs...)
+}
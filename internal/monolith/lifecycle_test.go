@@ -0,0 +1,159 @@
+package monolith
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/db/dbtest"
+)
+
+// fakeModule records its lifecycle calls in a log shared between modules.
+type fakeModule struct {
+	name      string
+	deps      []string
+	log       *[]string
+	failStart bool
+	failStop  bool
+}
+
+func (m *fakeModule) Name() string           { return m.name }
+func (m *fakeModule) Dependencies() []string { return m.deps }
+
+func (m *fakeModule) Setup(context.Context, Monolith) error {
+	*m.log = append(*m.log, "setup "+m.name)
+	return nil
+}
+
+func (m *fakeModule) Start(context.Context) error {
+	*m.log = append(*m.log, "start "+m.name)
+	if m.failStart {
+		return errors.New("start failed")
+	}
+	return nil
+}
+
+func (m *fakeModule) Stop(context.Context) error {
+	*m.log = append(*m.log, "stop "+m.name)
+	if m.failStop {
+		return errors.New("stop failed")
+	}
+	return nil
+}
+
+func names(modules []Module) []string {
+	var names []string
+	for _, m := range modules {
+		names = append(names, m.Name())
+	}
+	return names
+}
+
+func TestSortModules(t *testing.T) {
+	module := func(name string, deps ...string) Module {
+		return &fakeModule{name: name, deps: deps}
+	}
+
+	tests := []struct {
+		name    string
+		modules []Module
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "dependencies first",
+			modules: []Module{module("web", "workout", "auth"), module("workout", "auth"), module("auth")},
+			want:    []string{"auth", "workout", "web"},
+		},
+		{
+			name:    "independent modules keep their order",
+			modules: []Module{module("b"), module("a"), module("c", "a")},
+			want:    []string{"b", "a", "c"},
+		},
+		{
+			name:    "unknown dependency",
+			modules: []Module{module("web", "billing")},
+			wantErr: ErrUnknownDependency,
+		},
+		{
+			name:    "cycle",
+			modules: []Module{module("auth"), module("a", "b"), module("b", "a")},
+			wantErr: ErrDependencyCycle,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted, err := sortModules(tt.modules)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got := names(sorted); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := sortModules([]Module{module("auth"), module("auth")}); err == nil {
+		t.Error("got no error for duplicate names")
+	}
+}
+
+// newLifecycleApp returns an application on a SQLite database, with the
+// modules set up in the given order.
+func newLifecycleApp(t *testing.T, modules ...Module) *Application {
+	t.Helper()
+	cfg := &config.Config{
+		App:      &config.AppConfig{Env: config.TestingEnvironment},
+		Database: &config.DatabaseConfig{Driver: config.DriverSQLite},
+	}
+	app, err := NewApplication(dbtest.NewSQLite(t), http.NewServeMux(), slog.Default(), cfg, Modules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.ordered = modules
+	return app
+}
+
+func TestStartAndStopModules(t *testing.T) {
+	ctx := context.Background()
+	var log []string
+	app := newLifecycleApp(t,
+		&fakeModule{name: "auth", log: &log},
+		&fakeModule{name: "workout", log: &log, failStop: true},
+		&fakeModule{name: "web", log: &log},
+	)
+
+	if err := app.StartModules(ctx); err != nil {
+		t.Fatal(err)
+	}
+	err := app.StopModules(ctx)
+	if err == nil {
+		t.Error("got no error, want the failed stop")
+	}
+	want := []string{"start auth", "start workout", "start web", "stop web", "stop workout", "stop auth"}
+	if !slices.Equal(log, want) {
+		t.Errorf("got %v, want %v", log, want)
+	}
+}
+
+func TestStartModulesStopsStartedOnFailure(t *testing.T) {
+	ctx := context.Background()
+	var log []string
+	app := newLifecycleApp(t,
+		&fakeModule{name: "auth", log: &log},
+		&fakeModule{name: "workout", log: &log, failStart: true},
+		&fakeModule{name: "web", log: &log},
+	)
+
+	if err := app.StartModules(ctx); err == nil {
+		t.Fatal("got no error, want the failed start")
+	}
+	want := []string{"start auth", "start workout", "stop auth"}
+	if !slices.Equal(log, want) {
+		t.Errorf("got %v, want %v", log, want)
+	}
+}
@@ -0,0 +1,82 @@
+package monolith
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+// LogLevelMessage is the level of the application loggers.
+type LogLevelMessage struct {
+	Level string `json:"level"`
+}
+
+// registerLogRoutes serves the log level to admins. Unlike the debug
+// endpoints, these are served in every environment, so the level can be
+// raised in production without a restart.
+func (app *Application) registerLogRoutes() {
+	routeDefinitions := rest.RouteDefinitionList{
+		{
+			Path:    "GET /api/v0/log/level",
+			Handler: auth.RequireAdmin(logLevelHandler),
+		},
+		{
+			Path:    "PUT /api/v0/log/level",
+			Handler: auth.RequireAdmin(app.setLogLevelHandler),
+		},
+	}
+
+	for _, d := range routeDefinitions {
+		app.logger.Info("adding route", "route", d.Path)
+		app.mux.Handle(d.Path, d)
+	}
+}
+
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	msg := LogLevelMessage{Level: strings.ToLower(logging.Level.Level().String())}
+	if err := rest.WriteJSONResponse(w, http.StatusOK, msg); err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+	}
+}
+
+// setLogLevelHandler changes the level of the application loggers, until
+// the application restarts.
+func (app *Application) setLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.LoggerFromContext(ctx)
+
+	var input LogLevelMessage
+	if err := rest.DecodeJSONFromRequest(r, &input); err != nil {
+		rest.BadRequestResponse(w, r, rest.UnableToDecodeRequestBody, err)
+		return
+	}
+	logger = logger.With(slog.Group("input", slog.String("level", input.Level)))
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(input.Level)); err != nil {
+		err = errors.New("level must be one of debug, info, warn or error")
+		rest.BadRequestResponse(w, r, err.Error(), err)
+		return
+	}
+
+	before := LogLevelMessage{Level: strings.ToLower(logging.Level.Level().String())}
+	logging.Level.Set(level)
+	after := LogLevelMessage{Level: strings.ToLower(level.String())}
+	logger.Warn("changed log level", "before", before.Level, "after", after.Level)
+
+	app.modules.Auth.RecordAudit(ctx, "debug.log_level", "logger", "application", before, after)
+
+	if err := rest.WriteJSONResponse(w, http.StatusOK, after); err != nil {
+		logger.Error("failed to write response", "error", err)
+		rest.InternalServerErrorResponse(w, r, err)
+	}
+}
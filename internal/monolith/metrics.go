@@ -0,0 +1,75 @@
+package monolith
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+var (
+	httpRequests = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by route pattern and status.",
+	}, []string{"route", "status"})
+	httpDuration = metrics.Factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests by route pattern and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "status"})
+	httpInFlight = metrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Number of HTTP requests being served.",
+	})
+)
+
+// registerMetricsRoute serves the metrics on the application mux. Anyone
+// who can reach the API could read them, so they are limited to admins like
+// the debug routes, and scrapers should use a separate port.
+func (app *Application) registerMetricsRoute() {
+	d := rest.RouteDefinition{
+		Path:    "GET " + app.config.App.Metrics.Path,
+		Handler: auth.RequireAdmin(metrics.Handler().ServeHTTP),
+		Class:   rest.RateClassExempt,
+	}
+	app.mux.Handle(d.Path, d)
+}
+
+// routePattern returns the mux pattern matching the request. Requests
+// matching no route share a single label, so scanners probing random paths
+// cannot blow up the number of series.
+func (app *Application) routePattern(r *http.Request) string {
+	_, pattern := app.mux.Handler(r)
+	if pattern == "" {
+		return "unmatched"
+	}
+	return pattern
+}
+
+// instrument records the count and duration of requests, and the number of
+// requests in flight.
+func (app *Application) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		start := time.Now()
+		rw := wrapResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		labels := prometheus.Labels{"route": app.routePattern(r), "status": strconv.Itoa(rw.Status())}
+		httpRequests.With(labels).Inc()
+		httpDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,35 @@
+package monolith
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
+
+func TestMetricsRouteRequiresAdmin(t *testing.T) {
+	app := newTestApp(&config.Config{App: &config.AppConfig{
+		Metrics: &config.MetricsConfig{Enabled: true, Path: "/metrics"},
+	}})
+	app.registerMetricsRoute()
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		want      int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"user", &auth.Principal{UserID: 2, Role: auth.RoleUser}, http.StatusForbidden},
+		{"admin", admin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(app.mux, tt.principal, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
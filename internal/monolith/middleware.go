@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/evenlwanvik/smartsplit/internal/logging"
 	"github.com/evenlwanvik/smartsplit/internal/rest"
@@ -19,21 +21,57 @@ const RequestUrlKey requestUrlKey = "requestUrlKey"
 func (app *Application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		requestLogger := app.logger.With(
-			slog.Group(
-				"request",
-				slog.String("id", uuid.New().String()),
-				slog.String("method", r.Method),
-				slog.String("protocol", r.Proto),
-				slog.String("url", r.URL.Path),
-			),
-		)
+
+		// An incoming request ID is kept so requests can be correlated
+		// with upstream proxies, as long as it is safe to log.
+		requestID := r.Header.Get(rest.RequestIDHeader)
+		if !rest.ValidRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(rest.RequestIDHeader, requestID)
+
+		attrs := []any{
+			slog.String("id", requestID),
+			slog.String("method", r.Method),
+			slog.String("protocol", r.Proto),
+			slog.String("url", r.URL.Path),
+		}
+		// The trace IDs let logs be looked up from a trace, and the other
+		// way around.
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			attrs = append(attrs,
+				slog.String("trace_id", sc.TraceID().String()),
+				slog.String("span_id", sc.SpanID().String()),
+			)
+		}
+		requestLogger := app.logger.With(slog.Group("request", attrs...))
 		ctx = logging.WithLogger(ctx, requestLogger)
 		ctx = context.WithValue(ctx, RequestUrlKey, r.URL.Path)
+		ctx = rest.WithRequestID(ctx, requestID)
 
 		requestLogger.Info("received request")
-		next.ServeHTTP(w, r.WithContext(ctx))
-		requestLogger.Info("received completed")
+
+		start := time.Now()
+		rw := wrapResponseWriter(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+		duration := time.Since(start)
+
+		level := slog.LevelInfo
+		switch {
+		case rw.Status() >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case rw.Status() >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		requestLogger.LogAttrs(ctx, level, "completed request",
+			slog.Int("status", rw.Status()),
+			slog.Int64("bytes", rw.Bytes()),
+			slog.Duration("duration", duration),
+		)
+
+		if app.accessLog != nil {
+			app.writeAccessLog(app.accessLog, r, rw, start)
+		}
 	})
 }
 
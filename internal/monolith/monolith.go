@@ -6,15 +6,34 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/events"
+	"github.com/evenlwanvik/smartsplit/internal/flags"
+	"github.com/evenlwanvik/smartsplit/internal/scheduler"
 	"github.com/evenlwanvik/smartsplit/internal/workout"
 )
 
 // Monolith is the interface that represents the main application
 type Monolith interface {
 	DB() *sql.DB
+	// Dialect is the SQL dialect of the database, which repositories
+	// rewrite their queries to.
+	Dialect() db.Dialect
 	Logger() *slog.Logger
 	Mux() *http.ServeMux
+	Config() *config.Config
 	Modules() *Modules
+	// RegisterHealthCheck adds a check to the readiness probe.
+	RegisterHealthCheck(name string, check HealthCheckFunc)
+	// Scheduler runs periodic jobs. Jobs are registered during Setup.
+	Scheduler() *scheduler.Scheduler
+	// Events is the bus delivering domain events between modules.
+	// Subscriptions are made during Setup.
+	Events() *events.Bus
+	// Flags evaluates the feature flags defined in the config.
+	Flags() *flags.Flags
 }
 
 type Modules struct {
@@ -23,37 +42,40 @@ type Modules struct {
 	Workout Workout
 }
 
-type Auth interface{}
+type Auth interface {
+	auth.Client
+}
 type Web interface{}
 type Workout interface {
 	workout.Client
 }
 
 type Module interface {
+	// Name is the unique name other modules refer to this module by in
+	// their dependencies.
+	Name() string
+	// Dependencies are the names of the modules this module uses. They are
+	// guaranteed to be set up before, and started before, this module, and
+	// stopped after it.
+	Dependencies() []string
 	// Setup sets up the module using the context and resources from the
-	// monolith. For example, initializing database models, message queues
-	// and so on.
-	//
-	// Be aware that in case of injecting resources from another module,
-	// no method call should be made within the Setup process. This can
-	// cause segmentation faults as there is no guarantee that injected modules
-	// have completed their own startup process.
+	// monolith. For example, initializing database models, registering
+	// routes and so on.
 	//
-	// If resources are required from other modules as part of the startup
-	// process, add a PostSetup or Startup method, and set it to run after
-	// Setup has completed.
-	Setup(ctx context.Context, app Monolith)
-	// PostSetup performs any additional setup tasks, usually in cases where
-	// external resources from other modules were required, and a guarantee
-	// that the initial setup of any given module is completed to avoid
-	// segmentation faults. Note that API calls through HTTP will not work
-	// if the Monolith server is not running.
-	PostSetup()
-	// Shutdown performs any necessary cleanup tasks before application
-	// termination. Examples of such tasks could be closing channels,
-	// connections created by the module and so on.
+	// Modules listed as dependencies have completed their own Setup, and may
+	// be called. Other modules must not be used. Background work should
+	// not be started before Start, as administrative commands set up the
+	// modules without starting them.
 	//
-	// Typically called with the defer keyword immediately after Setup and
-	// PostSetup.
-	Shutdown()
+	// An error aborts the startup of the application.
+	Setup(ctx context.Context, app Monolith) error
+	// Start starts any background work of the module, such as workers and
+	// subscriptions. An error aborts the startup of the application, and
+	// the modules started so far are stopped.
+	Start(ctx context.Context) error
+	// Stop performs any necessary cleanup tasks before application
+	// termination, such as stopping workers and closing connections
+	// created by the module. It must return once the context is done,
+	// even if the cleanup is not complete.
+	Stop(ctx context.Context) error
 }
@@ -0,0 +1,166 @@
+package monolith
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+// bucket is the token bucket of a single client within a rate class.
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter limits the request rate with a token bucket per client and
+// rate class. Anonymous clients are told apart by IP, and authenticated ones
+// by user or token, so users behind a shared IP do not starve each other.
+type rateLimiter struct {
+	cfg *config.LimiterConfig
+	mux *http.ServeMux
+	// byIP limits every client by IP with the IP rate, whatever its rate
+	// class, as it runs before authentication.
+	byIP bool
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newRateLimiter(cfg *config.LimiterConfig, mux *http.ServeMux) *rateLimiter {
+	return &rateLimiter{
+		cfg:     cfg,
+		mux:     mux,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// newIPRateLimiter returns a limiter of the IP rate, to run before
+// authentication.
+func newIPRateLimiter(cfg *config.LimiterConfig, mux *http.ServeMux) *rateLimiter {
+	l := newRateLimiter(cfg, mux)
+	l.byIP = true
+	return l
+}
+
+// limit returns the rate and burst of a rate class.
+func (l *rateLimiter) limit(class rest.RateClass) (float64, int) {
+	if l.byIP {
+		return l.cfg.IP.RPS, l.cfg.IP.Burst
+	}
+	if c, ok := l.cfg.Classes[string(class)]; ok {
+		return c.RPS, c.Burst
+	}
+	return l.cfg.RPS, l.cfg.Burst
+}
+
+// class returns the rate class of the route matching the request.
+func (l *rateLimiter) class(r *http.Request) rest.RateClass {
+	h, _ := l.mux.Handler(r)
+	if d, ok := h.(rest.RouteDefinition); ok {
+		return d.Class
+	}
+	return rest.RateClassDefault
+}
+
+// client identifies the caller of a request.
+func client(r *http.Request) string {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		if principal.TokenID != nil {
+			return "token:" + strconv.Itoa(*principal.TokenID)
+		}
+		return "user:" + strconv.Itoa(principal.UserID)
+	}
+	return "ip:" + rest.ClientIP(r)
+}
+
+func (l *rateLimiter) bucket(class rest.RateClass, client string) *rate.Limiter {
+	key := string(class) + "|" + client
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		rps, burst := l.limit(class)
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = time.Now()
+	return b.limiter
+}
+
+// Middleware rejects requests exceeding the rate limit with 429 Too Many
+// Requests. Every limited response carries RateLimit-* headers describing
+// the remaining quota, and rejected ones a Retry-After header.
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := l.class(r)
+		if class == rest.RateClassExempt {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var limiter *rate.Limiter
+		if l.byIP {
+			limiter = l.bucket(rest.RateClassDefault, "ip:"+rest.ClientIP(r))
+		} else {
+			limiter = l.bucket(class, client(r))
+		}
+		allowed := limiter.Allow()
+
+		rps, burst := float64(limiter.Limit()), limiter.Burst()
+		tokens := max(limiter.Tokens(), 0)
+		reset := math.Ceil((float64(burst) - tokens) / rps)
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(int(tokens)))
+		h.Set("RateLimit-Reset", strconv.Itoa(int(reset)))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", burst, int(math.Ceil(float64(burst)/rps))))
+
+		if !allowed {
+			retryAfter := math.Ceil((1 - tokens) / rps)
+			h.Set("Retry-After", strconv.Itoa(max(int(retryAfter), 1)))
+			rest.ErrorResponse(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// collect removes the buckets of clients that have been idle for longer
+// than the idle timeout, until the context is cancelled.
+func (l *rateLimiter) collect(ctx context.Context) {
+	idle := l.cfg.IdleTimeout
+	if idle <= 0 {
+		idle = 3 * time.Minute
+	}
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			for key, b := range l.buckets {
+				if time.Since(b.lastSeen) > idle {
+					delete(l.buckets, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
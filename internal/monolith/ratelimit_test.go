@@ -0,0 +1,111 @@
+package monolith
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+// newLimitedMux returns a mux with a route of every rate class.
+func newLimitedMux() *http.ServeMux {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	mux := http.NewServeMux()
+	for _, d := range (rest.RouteDefinitionList{
+		{Path: "GET /default", Handler: ok},
+		{Path: "POST /login", Handler: ok, Class: rest.RateClassAuth},
+		{Path: "GET /static", Handler: ok, Class: rest.RateClassExempt},
+	}) {
+		mux.Handle(d.Path, d)
+	}
+	return mux
+}
+
+// request sends a request from the given IP, as the given user if it is not
+// zero, and returns the status.
+func request(h http.Handler, method string, target string, ip string, userID int) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.RemoteAddr = ip + ":1234"
+	var principal *auth.Principal
+	if userID != 0 {
+		principal = &auth.Principal{UserID: userID, Role: auth.RoleUser}
+	}
+	return serve(h, principal, r)
+}
+
+func TestRateLimiterClasses(t *testing.T) {
+	mux := newLimitedMux()
+	cfg := &config.LimiterConfig{
+		RPS:     0.001,
+		Burst:   3,
+		Enabled: true,
+		Classes: map[string]config.LimiterRate{"auth": {RPS: 0.001, Burst: 1}},
+	}
+	h := newRateLimiter(cfg, mux).Middleware(mux)
+
+	for i := range 3 {
+		if w := request(h, http.MethodGet, "/default", "192.0.2.1", 0); w.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+	w := request(h, http.MethodGet, "/default", "192.0.2.1", 0)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d after the burst", w.Code, http.StatusTooManyRequests)
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 {
+		t.Errorf("got Retry-After %q, want a positive number of seconds", w.Header().Get("Retry-After"))
+	}
+
+	// The auth class has a bucket of its own.
+	if w := request(h, http.MethodPost, "/login", "192.0.2.1", 0); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d for the first login", w.Code, http.StatusOK)
+	}
+	if w := request(h, http.MethodPost, "/login", "192.0.2.1", 0); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d for the second login", w.Code, http.StatusTooManyRequests)
+	}
+
+	// Users behind the limited IP, and other IPs, are not limited.
+	if w := request(h, http.MethodGet, "/default", "192.0.2.1", 2); w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d for a user behind the IP", w.Code, http.StatusOK)
+	}
+	if w := request(h, http.MethodGet, "/default", "192.0.2.2", 0); w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d for another IP", w.Code, http.StatusOK)
+	}
+	// Exempt routes are never limited.
+	if w := request(h, http.MethodGet, "/static", "192.0.2.1", 0); w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d for an exempt route", w.Code, http.StatusOK)
+	}
+}
+
+func TestIPRateLimiter(t *testing.T) {
+	mux := newLimitedMux()
+	cfg := &config.LimiterConfig{
+		RPS:     100,
+		Burst:   100,
+		Enabled: true,
+		IP:      &config.LimiterRate{RPS: 0.001, Burst: 2},
+	}
+	h := newIPRateLimiter(cfg, mux).Middleware(mux)
+
+	// Every user and class behind the IP shares its bucket, as the IP
+	// limit runs before authentication.
+	if w := request(h, http.MethodGet, "/default", "192.0.2.1", 2); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := request(h, http.MethodPost, "/login", "192.0.2.1", 3); w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := request(h, http.MethodGet, "/default", "192.0.2.1", 4); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d after the IP burst", w.Code, http.StatusTooManyRequests)
+	}
+	if w := request(h, http.MethodGet, "/static", "192.0.2.1", 0); w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d for an exempt route", w.Code, http.StatusOK)
+	}
+	if w := request(h, http.MethodGet, "/default", "192.0.2.2", 0); w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d for another IP", w.Code, http.StatusOK)
+	}
+}
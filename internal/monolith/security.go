@@ -0,0 +1,174 @@
+package monolith
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+// csrfCookieName is the name of the cookie holding the CSRF token, which
+// requests changing state must send back in a header or form field.
+const csrfCookieName = "smartsplit_csrf"
+
+// securityHeaders sets the configured security headers on every response.
+// HSTS is only sent over HTTPS, as browsers ignore it otherwise.
+func (app *Application) securityHeaders(next http.Handler) http.Handler {
+	cfg := app.config.App.Security
+	hsts := "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		if cfg.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", cfg.ContentSecurityPolicy)
+		}
+		if cfg.FrameOptions != "" {
+			h.Set("X-Frame-Options", cfg.FrameOptions)
+		}
+		if cfg.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", cfg.ReferrerPolicy)
+		}
+		if cfg.HSTSMaxAge > 0 && r.TLS != nil {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors lets the allowed origins call the application from a browser.
+// Preflight requests are answered here, and refused for other origins.
+// Credentials are not allowed, so cross-origin clients authenticate with
+// bearer tokens rather than the session cookie.
+func (app *Application) cors(next http.Handler) http.Handler {
+	cfg := app.config.App.Security.CORS
+	origins := make([]string, len(cfg.AllowedOrigins))
+	for i, origin := range cfg.AllowedOrigins {
+		origins[i] = strings.TrimSuffix(origin, "/")
+	}
+	anyOrigin := slices.Contains(origins, "*")
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !anyOrigin && !slices.Contains(origins, origin) {
+			if preflight {
+				rest.ForbiddenResponse(w, r, fmt.Errorf("origin %q is not allowed", origin))
+				return
+			}
+			// Same-origin requests also carry an Origin header, and are
+			// served without CORS headers.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if preflight {
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", rest.RequestIDHeader)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrf protects against cross-site request forgery with a double-submit
+// cookie. Every client is given a random token in a cookie, which pages
+// embed and send back with requests changing state. Other sites can make
+// the browser send the cookie, but cannot read it to send it back.
+//
+// The token is embedded in the request context for the pages to render.
+func (app *Application) csrf(next http.Handler) http.Handler {
+	secure := false
+	if cfg := app.config.Auth; cfg != nil && cfg.Session != nil {
+		secure = cfg.Session.SecureCookie
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if cookie, err := r.Cookie(csrfCookieName); err == nil {
+			token = cookie.Value
+		}
+		if token == "" {
+			token = newCSRFToken()
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   secure,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+
+		if csrfRequired(r) {
+			sent := r.Header.Get(rest.CSRFHeader)
+			if sent == "" {
+				sent = r.PostFormValue(rest.CSRFFormField)
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				rest.ForbiddenResponse(w, r, errors.New("missing or invalid CSRF token"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(rest.WithCSRFToken(r.Context(), token)))
+	})
+}
+
+// csrfRequired reports whether a request must send the CSRF token. Only
+// unsafe methods are checked. Requests authenticated with a bearer token
+// cannot be forged by a browser, and neither can API requests without the
+// session cookie, as they carry no credentials. Logins are the exception:
+// they carry no credentials, but a forged login signs the victim in to the
+// account of the attacker. Pages are always checked.
+func csrfRequired(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok && principal.TokenID != nil {
+		return false
+	}
+	if r.URL.Path == auth.LoginPath {
+		return true
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		_, err := r.Cookie(auth.SessionCookieName)
+		return err == nil
+	}
+	return true
+}
+
+// newCSRFToken returns a random token.
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
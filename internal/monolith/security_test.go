@@ -0,0 +1,161 @@
+package monolith
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/auth"
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/rest"
+)
+
+// okHandler answers every request it is passed with 200.
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func newSecurityApp(origins ...string) *Application {
+	return newTestApp(&config.Config{App: &config.AppConfig{
+		Env: config.TestingEnvironment,
+		Security: &config.SecurityConfig{
+			CORS: &config.CORSConfig{
+				AllowedOrigins: origins,
+				AllowedMethods: []string{"GET", "POST"},
+				AllowedHeaders: []string{"Authorization", "Content-Type"},
+				MaxAge:         10 * time.Minute,
+			},
+			CSRF: &config.CSRFConfig{Enabled: true},
+		},
+	}})
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name       string
+		origins    []string
+		method     string
+		origin     string
+		preflight  bool
+		wantStatus int
+		// wantAllow is the Access-Control-Allow-Origin header, empty if no
+		// CORS headers are sent.
+		wantAllow string
+	}{
+		{name: "preflight allowed", origins: []string{"https://app.example.com/"}, method: http.MethodOptions, origin: "https://app.example.com", preflight: true, wantStatus: http.StatusNoContent, wantAllow: "https://app.example.com"},
+		{name: "preflight any origin", origins: []string{"*"}, method: http.MethodOptions, origin: "https://other.example.com", preflight: true, wantStatus: http.StatusNoContent, wantAllow: "*"},
+		{name: "preflight denied", origins: []string{"https://app.example.com"}, method: http.MethodOptions, origin: "https://evil.example.com", preflight: true, wantStatus: http.StatusForbidden},
+		{name: "request allowed", origins: []string{"https://app.example.com"}, method: http.MethodGet, origin: "https://app.example.com", wantStatus: http.StatusOK, wantAllow: "https://app.example.com"},
+		{name: "request from other origin", origins: []string{"https://app.example.com"}, method: http.MethodGet, origin: "https://evil.example.com", wantStatus: http.StatusOK},
+		{name: "options without preflight", origins: []string{"https://app.example.com"}, method: http.MethodOptions, origin: "https://evil.example.com", wantStatus: http.StatusOK},
+		{name: "no origin", origins: []string{"https://app.example.com"}, method: http.MethodGet, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newSecurityApp(tt.origins...)
+			r := httptest.NewRequest(tt.method, "/api/v0/workout/muscles", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := serve(app.cors(okHandler), nil, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+			h := w.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+				t.Errorf("got allowed origin %q, want %q", got, tt.wantAllow)
+			}
+			if tt.wantAllow == "" {
+				return
+			}
+			if tt.preflight {
+				if h.Get("Access-Control-Allow-Methods") != "GET, POST" || h.Get("Access-Control-Allow-Headers") != "Authorization, Content-Type" || h.Get("Access-Control-Max-Age") != "600" {
+					t.Errorf("got preflight headers %v", h)
+				}
+			} else if h.Get("Access-Control-Expose-Headers") != rest.RequestIDHeader {
+				t.Errorf("got exposed headers %q, want %q", h.Get("Access-Control-Expose-Headers"), rest.RequestIDHeader)
+			}
+		})
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	const token = "token"
+	tokenID := 7
+	bearer := &auth.Principal{UserID: 2, Role: auth.RoleUser, TokenID: &tokenID}
+	session := &auth.Principal{UserID: 2, Role: auth.RoleUser}
+
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		principal *auth.Principal
+		session   bool
+		header    string
+		form      string
+		want      int
+	}{
+		{name: "safe method", method: http.MethodGet, target: "/dashboard", want: http.StatusOK},
+		{name: "page without token", method: http.MethodPost, target: "/plans/new", principal: session, session: true, want: http.StatusForbidden},
+		{name: "page with header", method: http.MethodPost, target: "/plans/new", principal: session, session: true, header: token, want: http.StatusOK},
+		{name: "page with form field", method: http.MethodPost, target: "/plans/new", principal: session, session: true, form: token, want: http.StatusOK},
+		{name: "page with wrong token", method: http.MethodPost, target: "/plans/new", principal: session, session: true, header: "other", want: http.StatusForbidden},
+		{name: "api with session", method: http.MethodDelete, target: "/api/v0/auth/users/2", principal: session, session: true, want: http.StatusForbidden},
+		{name: "api with session and header", method: http.MethodDelete, target: "/api/v0/auth/users/2", principal: session, session: true, header: token, want: http.StatusOK},
+		{name: "api with bearer token", method: http.MethodDelete, target: "/api/v0/auth/users/2", principal: bearer, want: http.StatusOK},
+		{name: "anonymous api", method: http.MethodPost, target: "/api/v0/auth/users/register", want: http.StatusOK},
+		{name: "login without token", method: http.MethodPost, target: auth.LoginPath, want: http.StatusForbidden},
+		{name: "login with header", method: http.MethodPost, target: auth.LoginPath, header: token, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newSecurityApp()
+			form := url.Values{}
+			if tt.form != "" {
+				form.Set(rest.CSRFFormField, tt.form)
+			}
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+			if tt.session {
+				r.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: "session"})
+			}
+			if tt.header != "" {
+				r.Header.Set(rest.CSRFHeader, tt.header)
+			}
+
+			if w := serve(app.csrf(okHandler), tt.principal, r); w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFIssuesToken(t *testing.T) {
+	app := newSecurityApp()
+	var embedded string
+	h := app.csrf(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		embedded = rest.CSRFTokenFromContext(r.Context())
+	}))
+
+	w := serve(h, nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("got cookies %v, want the CSRF cookie", cookies)
+	}
+	if embedded == "" || embedded != cookies[0].Value {
+		t.Errorf("got embedded token %q, want the cookie value %q", embedded, cookies[0].Value)
+	}
+
+	// A client with the cookie keeps its token.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	if w := serve(h, nil, r); len(w.Result().Cookies()) != 0 || embedded != cookies[0].Value {
+		t.Errorf("got a new token %q for a client with one", embedded)
+	}
+}
@@ -0,0 +1,180 @@
+package monolith
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+// reloadDelay is how long the certificate files must be left alone before
+// they are reloaded, so a certificate and key written one after the other
+// are loaded together.
+const reloadDelay = 500 * time.Millisecond
+
+var certExpiry = metrics.Factory.NewGauge(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "tls",
+	Name:      "certificate_expiry_timestamp_seconds",
+	Help:      "Expiry of the served TLS certificate, in seconds since the epoch.",
+})
+
+// certReloader serves the certificate in the configured files, and reloads
+// it when the files change. If a reload fails, e.g. as the key does not
+// match the certificate yet, the previous certificate is served until the
+// next change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(cfg *config.TLSConfig, logger *slog.Logger) (*certReloader, error) {
+	r := &certReloader{
+		certFile: cfg.CertFile,
+		keyFile:  cfg.KeyFile,
+		logger:   logger,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the certificate and key files.
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parsing TLS certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+
+	certExpiry.Set(float64(leaf.NotAfter.Unix()))
+	r.logger.Info("loaded TLS certificate",
+		"subject", leaf.Subject.String(),
+		"dns_names", leaf.DNSNames,
+		"not_after", leaf.NotAfter,
+	)
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// watch reloads the certificate when the files change, until the context
+// is cancelled. The directories are watched rather than the files, as
+// certificates are often replaced by renaming, e.g. by Kubernetes secret
+// mounts swapping a symlink.
+func (r *certReloader) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := map[string]bool{
+		filepath.Dir(r.certFile): true,
+		filepath.Dir(r.keyFile):  true,
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("watching %s: %w", dir, err)
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if ev.Has(fsnotify.Chmod) {
+					continue
+				}
+				timer.Reset(reloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				r.logger.Warn("failed to watch TLS certificate", "error", err)
+			case <-timer.C:
+				if err := r.load(); err != nil {
+					r.logger.Error("failed to reload TLS certificate, keeping the previous one", "error", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// tlsConfig returns the TLS settings of the server.
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// redirectServer returns a server redirecting plain HTTP requests to HTTPS
+// on the port of the application, or nil if the redirect is disabled.
+func (app *Application) redirectServer() *http.Server {
+	cfg := app.config.App.TLS
+	if cfg == nil || !cfg.Enabled || cfg.RedirectPort == 0 {
+		return nil
+	}
+
+	port := app.config.App.Port
+	return &http.Server{
+		Addr: fmt.Sprintf(":%d", cfg.RedirectPort),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if port != 443 {
+				host = net.JoinHostPort(host, strconv.Itoa(port))
+			}
+			target := "https://" + host + r.URL.RequestURI()
+			http.Redirect(w, r, target, http.StatusPermanentRedirect)
+		}),
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       time.Minute,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+}
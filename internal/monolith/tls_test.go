@@ -0,0 +1,157 @@
+package monolith
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/config"
+)
+
+// writeCert writes a self-signed certificate for the name, and its key.
+func writeCert(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if certFile != "" {
+		writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+	if keyFile != "" {
+		writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	}
+}
+
+// writeFile replaces a file by renaming, as secret mounts do.
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedName(r *certReloader) string {
+	cert, _ := r.GetCertificate(nil)
+	return cert.Leaf.Subject.CommonName
+}
+
+// waitForName waits for the reloader to serve a certificate for the name.
+func waitForName(t *testing.T, r *certReloader, name string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for servedName(r) != name {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %q served, want %q", servedName(r), name)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.TLSConfig{
+		Enabled:  true,
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+	}
+
+	if _, err := newCertReloader(cfg, slog.Default()); err == nil {
+		t.Fatal("got no error without certificate files")
+	}
+
+	writeCert(t, cfg.CertFile, cfg.KeyFile, "one.example.com")
+	r, err := newCertReloader(cfg, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := servedName(r); got != "one.example.com" {
+		t.Fatalf("got %q served", got)
+	}
+	if r.tlsConfig().MinVersion < tls.VersionTLS12 {
+		t.Error("got TLS versions before 1.2 allowed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := r.watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	writeCert(t, cfg.CertFile, cfg.KeyFile, "two.example.com")
+	waitForName(t, r, "two.example.com")
+
+	// A certificate without its key is not loaded, and the previous one is
+	// served until the key is written too.
+	writeCert(t, cfg.CertFile, "", "three.example.com")
+	time.Sleep(3 * reloadDelay)
+	if got := servedName(r); got != "two.example.com" {
+		t.Fatalf("got %q served after a failed reload, want the previous certificate", got)
+	}
+	writeCert(t, cfg.CertFile, cfg.KeyFile, "four.example.com")
+	waitForName(t, r, "four.example.com")
+}
+
+func TestRedirectServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		port   int
+		target string
+		want   string
+	}{
+		{name: "custom port", port: 8443, target: "http://example.com:8080/plans?id=1", want: "https://example.com:8443/plans?id=1"},
+		{name: "default port", port: 443, target: "http://example.com/plans", want: "https://example.com/plans"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(&config.Config{App: &config.AppConfig{
+				Port: tt.port,
+				TLS:  &config.TLSConfig{Enabled: true, RedirectPort: 8080},
+			}})
+			srv := app.redirectServer()
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.want {
+				t.Errorf("got %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), http.StatusPermanentRedirect, tt.want)
+			}
+		})
+	}
+
+	app := newTestApp(&config.Config{App: &config.AppConfig{TLS: &config.TLSConfig{Enabled: true}}})
+	if app.redirectServer() != nil {
+		t.Error("got a redirect server without a redirect port")
+	}
+}
@@ -0,0 +1,145 @@
+package monolith
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spans records the spans ended by every test, see TestMain.
+var spans = tracetest.NewSpanRecorder()
+
+const (
+	remoteTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	remoteSpanID  = "00f067aa0ba902b7"
+)
+
+func TestTrace(t *testing.T) {
+	app := newTestApp(nil)
+	app.mux.HandleFunc("GET /api/v0/plans/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	h := app.trace(app.mux)
+
+	tests := []struct {
+		name        string
+		target      string
+		traceparent string
+		wantName    string
+		wantStatus  int
+		wantCode    codes.Code
+	}{
+		{name: "new trace", target: "/api/v0/plans/1", wantName: "GET /api/v0/plans/{id}", wantStatus: http.StatusOK},
+		{
+			name:        "continued trace",
+			target:      "/api/v0/plans/1",
+			traceparent: "00-" + remoteTraceID + "-" + remoteSpanID + "-01",
+			wantName:    "GET /api/v0/plans/{id}",
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:       "server error",
+			target:     "/api/v0/plans/fail",
+			wantName:   "GET /api/v0/plans/{id}",
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   codes.Error,
+		},
+		{name: "unmatched", target: "/nowhere", wantName: "unmatched", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans.Reset()
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.traceparent != "" {
+				r.Header.Set("traceparent", tt.traceparent)
+			}
+			serve(h, nil, r)
+
+			ended := spans.Ended()
+			if len(ended) != 1 {
+				t.Fatalf("got %d spans, want 1", len(ended))
+			}
+			span := ended[0]
+			if span.Name() != tt.wantName {
+				t.Errorf("got span name %q, want %q", span.Name(), tt.wantName)
+			}
+			if span.SpanKind() != trace.SpanKindServer {
+				t.Errorf("got span kind %v, want %v", span.SpanKind(), trace.SpanKindServer)
+			}
+			if span.Status().Code != tt.wantCode {
+				t.Errorf("got span status %v, want %v", span.Status().Code, tt.wantCode)
+			}
+			want := attribute.Int("http.response.status_code", tt.wantStatus)
+			if !slices.Contains(span.Attributes(), want) {
+				t.Errorf("got attributes %v, want %v", span.Attributes(), want)
+			}
+
+			parent := span.Parent()
+			if tt.traceparent == "" {
+				if parent.IsValid() {
+					t.Errorf("got parent %v, want a new trace", parent)
+				}
+				return
+			}
+			if !parent.IsRemote() || parent.SpanID().String() != remoteSpanID {
+				t.Errorf("got parent %v, want the remote span %s", parent, remoteSpanID)
+			}
+			if got := span.SpanContext().TraceID().String(); got != remoteTraceID {
+				t.Errorf("got trace ID %s, want %s", got, remoteTraceID)
+			}
+		})
+	}
+}
+
+func TestLogRequestTraceIDs(t *testing.T) {
+	var buf bytes.Buffer
+	app := newTestApp(nil)
+	app.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	app.mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {})
+	spans.Reset()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("traceparent", "00-"+remoteTraceID+"-"+remoteSpanID+"-01")
+	serve(app.trace(app.logRequest(app.mux)), nil, r)
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("got %d spans, want 1", len(ended))
+	}
+	wantSpanID := ended[0].SpanContext().SpanID().String()
+
+	lines := 0
+	dec := json.NewDecoder(&buf)
+	for ; dec.More(); lines++ {
+		var line struct {
+			Msg     string `json:"msg"`
+			Request struct {
+				TraceID string `json:"trace_id"`
+				SpanID  string `json:"span_id"`
+			} `json:"request"`
+		}
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		if line.Request.TraceID != remoteTraceID || line.Request.SpanID != wantSpanID {
+			t.Errorf("%s: got trace %q and span %q, want %s and %s",
+				line.Msg, line.Request.TraceID, line.Request.SpanID, remoteTraceID, wantSpanID)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("got %d log lines, want the received and completed request", lines)
+	}
+}
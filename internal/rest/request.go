@@ -0,0 +1,71 @@
+package rest
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/evenlwanvik/smartsplit/internal/common"
+)
+
+// RequestIDHeader carries the ID correlating a request across services and
+// logs.
+const RequestIDHeader = "X-Request-ID"
+
+const RequestIDCtxKey common.ContextKey = "request_id"
+
+// CSRFHeader carries the CSRF token of requests changing state, e.g. from
+// htmx. Plain HTML forms send it as the CSRFFormField field instead.
+const (
+	CSRFHeader    = "X-CSRF-Token"
+	CSRFFormField = "csrf_token"
+)
+
+const CSRFTokenCtxKey common.ContextKey = "csrf_token"
+
+// ClientIP returns the IP address of the client that sent the request.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// WithRequestID embeds a request ID in the given context.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, RequestIDCtxKey, id)
+}
+
+// RequestIDFromContext returns the embedded request ID, or an empty string
+// outside of requests.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(RequestIDCtxKey).(string)
+	return id
+}
+
+// WithCSRFToken embeds the CSRF token of the client in the given context.
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, CSRFTokenCtxKey, token)
+}
+
+// CSRFTokenFromContext returns the CSRF token pages must send back, or an
+// empty string if CSRF protection is disabled.
+func CSRFTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(CSRFTokenCtxKey).(string)
+	return token
+}
+
+// ValidRequestID reports whether a request ID sent by a client is safe to
+// log and store.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -11,9 +11,29 @@ import (
 	"github.com/evenlwanvik/smartsplit/internal/logging"
 )
 
+// RateClass groups routes that share a rate limit.
+type RateClass string
+
+const (
+	// RateClassDefault is the rate limit of routes without a class.
+	RateClassDefault RateClass = ""
+	// RateClassAuth is a stricter rate limit for credential checks, such as
+	// logins and registration.
+	RateClassAuth RateClass = "auth"
+	// RateClassExempt routes are not rate limited, e.g. static files.
+	RateClassExempt RateClass = "exempt"
+)
+
 type RouteDefinition struct {
 	Path    string
 	Handler http.HandlerFunc
+	Class   RateClass
+}
+
+// ServeHTTP implements http.Handler, so the definition itself can be
+// registered with the mux and middleware can look up its class.
+func (d RouteDefinition) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.Handler(w, r)
 }
 
 type RouteDefinitionList []RouteDefinition
@@ -40,6 +60,9 @@ const (
 	ResourceNotFoundMessage    = "the requested resource was not found"
 	InternalServerErrorMessage = "the server encountered a problem and could not process your request"
 	BadRequestMessage          = "the request was invalid or cannot be otherwise served"
+	UnauthorizedMessage        = "you must be authenticated to access this resource"
+	ForbiddenMessage           = "you do not have permission to access this resource"
+	ConflictMessage            = "the request conflicts with the current state of the resource"
 )
 
 // logError logs an error that occurred while processing a request.
@@ -140,6 +163,34 @@ func NotFoundResponse(
 	http.Error(w, ResourceNotFoundMessage, http.StatusNotFound)
 }
 
+// UnauthorizedResponse sends a 401 Unauthorized response with a generic message and logs
+// the error.
+func UnauthorizedResponse(
+	w http.ResponseWriter, r *http.Request, err error,
+) {
+	logError(r, err)
+	http.Error(w, UnauthorizedMessage, http.StatusUnauthorized)
+}
+
+// ForbiddenResponse sends a 403 Forbidden response with a generic message and logs the error.
+func ForbiddenResponse(
+	w http.ResponseWriter, r *http.Request, err error,
+) {
+	logError(r, err)
+	http.Error(w, ForbiddenMessage, http.StatusForbidden)
+}
+
+// ConflictResponse sends a 409 Conflict response with a custom message and logs the error.
+func ConflictResponse(
+	w http.ResponseWriter, r *http.Request, message string, err error,
+) {
+	if message == "" {
+		message = ConflictMessage
+	}
+	logError(r, err)
+	http.Error(w, message, http.StatusConflict)
+}
+
 // UnableToGetPathParamFromRequest sends a 400 Bad Request response when a query parameter
 // cannot be retrieved from the request, along with a custom error message.
 func UnableToGetPathParamFromRequest(
@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+)
+
+// RunStatus is the outcome of a job run.
+type RunStatus string
+
+const (
+	RunStatusRunning   RunStatus = "running"
+	RunStatusSucceeded RunStatus = "succeeded"
+	RunStatusFailed    RunStatus = "failed"
+)
+
+// Job is periodic work run by the scheduler.
+type Job struct {
+	// Name identifies the job in logs and the run history, and is
+	// namespaced by module, e.g. "auth.purge".
+	Name     string
+	Schedule Schedule
+	// Timeout limits each attempt. Zero means DefaultTimeout.
+	Timeout time.Duration
+	// Retries is how many times a failed attempt is retried, waiting
+	// Backoff before the first retry, and twice as long before each next.
+	Retries int
+	Backoff time.Duration
+	// Run does the work. It must return once the context is done.
+	Run func(ctx context.Context) error
+}
+
+// Run is an entry in the run history of a job.
+type Run struct {
+	ID         int64      `json:"id"`
+	Job        string     `json:"job"`
+	Instance   string     `json:"instance"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Attempts   int        `json:"attempts"`
+	Status     RunStatus  `json:"status"`
+	Error      string     `json:"error"`
+}
+
+// JobStatus describes a registered job.
+type JobStatus struct {
+	Name    string    `json:"name"`
+	NextRun time.Time `json:"next_run"`
+	LastRun *Run      `json:"last_run"`
+}
@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+type Repository struct {
+	// db runs the queries rewritten to the dialect.
+	db db.Querier
+}
+
+func NewRepository(db *sql.DB, dialect db.Dialect) *Repository {
+	return &Repository{db: dialect.Wrap(db)}
+}
+
+// Start records the start of a run and returns its ID.
+func (r *Repository) Start(ctx context.Context, job string, instance string) (int64, error) {
+	defer metrics.ObserveQuery("scheduler", "Start", time.Now())
+	query := `
+	INSERT INTO scheduler.job_runs (job, instance)
+	VALUES ($1, $2)
+	RETURNING id
+	`
+	var id int64
+	err := r.db.QueryRowContext(ctx, query, job, instance).Scan(&id)
+	return id, err
+}
+
+// Finish records the outcome of a run.
+func (r *Repository) Finish(
+	ctx context.Context, id int64, attempts int, status RunStatus, runErr string,
+) error {
+	defer metrics.ObserveQuery("scheduler", "Finish", time.Now())
+	query := `
+	UPDATE scheduler.job_runs
+	SET finished_at = now(), attempts = $2, status = $3, error = $4
+	WHERE id = $1
+	`
+	_, err := r.db.ExecContext(ctx, query, id, attempts, status, runErr)
+	return err
+}
+
+// Last returns the latest run of a job, or nil if it has never run.
+func (r *Repository) Last(ctx context.Context, job string) (*Run, error) {
+	defer metrics.ObserveQuery("scheduler", "Last", time.Now())
+	runs, err := r.List(ctx, job, 1)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return runs[0], nil
+}
+
+// List returns the latest runs of a job, newest first.
+func (r *Repository) List(ctx context.Context, job string, limit int) ([]*Run, error) {
+	defer metrics.ObserveQuery("scheduler", "List", time.Now())
+	query := `
+	SELECT id, job, instance, started_at, finished_at, attempts, status, error
+	FROM scheduler.job_runs
+	WHERE job = $1
+	ORDER BY started_at DESC
+	LIMIT $2
+	`
+	rows, err := r.db.QueryContext(ctx, query, job, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*Run
+	for rows.Next() {
+		var run Run
+		err := rows.Scan(
+			&run.ID,
+			&run.Job,
+			&run.Instance,
+			&run.StartedAt,
+			&run.FinishedAt,
+			&run.Attempts,
+			&run.Status,
+			&run.Error,
+		)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, &run)
+	}
+	return runs, rows.Err()
+}
+
+// Prune deletes runs started before the cutoff.
+func (r *Repository) Prune(ctx context.Context, before time.Time) (int64, error) {
+	defer metrics.ObserveQuery("scheduler", "Prune", time.Now())
+	query := `
+	DELETE FROM scheduler.job_runs
+	WHERE started_at < $1
+	`
+	res, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
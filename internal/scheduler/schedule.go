@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule decides when a job runs.
+type Schedule interface {
+	// Next returns the first time after t the job should run.
+	Next(t time.Time) time.Time
+}
+
+type interval time.Duration
+
+// Every runs a job at a fixed interval. Runs are aligned to multiples of the
+// interval since the Unix epoch, so every instance agrees on the times.
+func Every(d time.Duration) Schedule {
+	return interval(d)
+}
+
+func (i interval) Next(t time.Time) time.Time {
+	d := time.Duration(i)
+	return t.Truncate(d).Add(d)
+}
+
+// Cron runs a job on a standard five field cron expression, such as
+// "0 3 * * 1" for Mondays at 03:00. Times are in UTC, unless the expression
+// is prefixed with CRON_TZ=<zone>.
+func Cron(expr string) (Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// MustCron is like Cron, but panics if the expression is invalid. It is
+// meant for expressions that are constants.
+func MustCron(expr string) Schedule {
+	s, err := Cron(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
@@ -0,0 +1,483 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/logging"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+const (
+	// DefaultTimeout limits the attempts of jobs without a timeout.
+	DefaultTimeout = 5 * time.Minute
+	// leaderLockID is the key of the advisory lock held by the leader.
+	leaderLockID int64 = 0x736d7363_686564 // "smsched"
+	// electionInterval is how often followers try to become leader, and
+	// the leader checks that it still holds the lock.
+	electionInterval = 15 * time.Second
+	// runRetention is how long the run history is kept.
+	runRetention = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidJob is returned when registering a job without a name,
+	// schedule or function.
+	ErrInvalidJob = errors.New("invalid job")
+	// ErrDuplicateJob is returned when registering a job with the name of
+	// another job.
+	ErrDuplicateJob = errors.New("job already registered")
+	// ErrSchedulerStarted is returned when registering a job after the
+	// scheduler has started.
+	ErrSchedulerStarted = errors.New("scheduler already started")
+	// ErrUnknownJob is returned when looking up a job that is not
+	// registered.
+	ErrUnknownJob = errors.New("unknown job")
+)
+
+var (
+	tracer = otel.Tracer("github.com/evenlwanvik/smartsplit/internal/scheduler")
+
+	jobRuns = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "scheduler",
+		Name:      "job_runs_total",
+		Help:      "Number of job runs by job and status.",
+	}, []string{"job", "status"})
+	jobDuration = metrics.Factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "scheduler",
+		Name:      "job_duration_seconds",
+		Help:      "Duration of job runs, including retries.",
+		Buckets:   []float64{.01, .1, .5, 1, 5, 15, 60, 300, 900},
+	}, []string{"job"})
+	leaderGauge = metrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "scheduler",
+		Name:      "leader",
+		Help:      "Whether this instance is the scheduler leader.",
+	})
+)
+
+type scheduledJob struct {
+	Job
+	// next is when the job is due next, in Unix nanoseconds.
+	next atomic.Int64
+}
+
+// maxDuration is how long a run may take with every attempt timing out.
+func (j *scheduledJob) maxDuration() time.Duration {
+	d := time.Duration(j.Retries+1) * j.Timeout
+	backoff := j.Backoff
+	for range j.Retries {
+		d += backoff
+		backoff *= 2
+	}
+	return d
+}
+
+// Scheduler runs periodic jobs. All instances schedule the jobs, but only
+// the leader runs them. The leader is the instance holding a Postgres
+// advisory lock, which is released when its connection is lost, so another
+// instance takes over if the leader dies. A SQLite database is only used by
+// a single instance, which always leads.
+type Scheduler struct {
+	db       *sql.DB
+	dialect  db.Dialect
+	repo     *Repository
+	logger   *slog.Logger
+	instance string
+
+	mu      sync.Mutex
+	jobs    []*scheduledJob
+	started bool
+
+	leader atomic.Bool
+	// conn holds the leader lock. It is only used by the election loop,
+	// and by Stop once the loop has returned.
+	conn *sql.Conn
+
+	stopLoops  context.CancelFunc
+	cancelRuns context.CancelFunc
+	loops      sync.WaitGroup
+}
+
+// New creates a scheduler. The instance identifies this instance in the run
+// history.
+func New(db *sql.DB, dialect db.Dialect, logger *slog.Logger, instance string) (*Scheduler, error) {
+	s := &Scheduler{
+		db:       db,
+		dialect:  dialect,
+		repo:     NewRepository(db, dialect),
+		logger:   logger.With(slog.Group("module", slog.String("name", "scheduler"))),
+		instance: instance,
+	}
+	err := s.Register(Job{
+		Name:     "scheduler.prune_runs",
+		Schedule: MustCron("0 4 * * *"),
+		Run: func(ctx context.Context) error {
+			n, err := s.repo.Prune(ctx, time.Now().Add(-runRetention))
+			if n > 0 {
+				logging.LoggerFromContext(ctx).Info("pruned job runs", "count", n)
+			}
+			return err
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Register adds a job. Jobs must be registered before the scheduler starts,
+// i.e. during the setup of the modules.
+func (s *Scheduler) Register(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Run == nil {
+		return fmt.Errorf("%w: name, schedule and run are required", ErrInvalidJob)
+	}
+	if job.Timeout <= 0 {
+		job.Timeout = DefaultTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return fmt.Errorf("%w: cannot register %s", ErrSchedulerStarted, job.Name)
+	}
+	for _, j := range s.jobs {
+		if j.Name == job.Name {
+			return fmt.Errorf("%w: %s", ErrDuplicateJob, job.Name)
+		}
+	}
+
+	s.logger.Info("registering job", "job", job.Name)
+	s.jobs = append(s.jobs, &scheduledJob{Job: job})
+	return nil
+}
+
+// Start starts scheduling the jobs, and campaigning for leadership.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	// Runs outlive the scheduling loops, so they can be drained on
+	// shutdown.
+	loopCtx, stopLoops := context.WithCancel(context.WithoutCancel(ctx))
+	runCtx, cancelRuns := context.WithCancel(logging.WithLogger(context.WithoutCancel(ctx), s.logger))
+	s.stopLoops = stopLoops
+	s.cancelRuns = cancelRuns
+
+	s.logger.Info("starting scheduler", "instance", s.instance, "jobs", len(s.jobs))
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		s.elect(loopCtx)
+	}()
+	for _, j := range s.jobs {
+		s.loops.Add(1)
+		go func() {
+			defer s.loops.Done()
+			s.schedule(loopCtx, runCtx, j)
+		}()
+	}
+}
+
+// Stop stops scheduling jobs, and waits for the runs in progress to finish.
+// Runs still in progress when the context is done are cancelled. Leadership
+// is given up last.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if !started || s.stopLoops == nil {
+		return nil
+	}
+
+	s.logger.Info("stopping scheduler")
+	s.stopLoops()
+
+	done := make(chan struct{})
+	go func() {
+		s.loops.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn("cancelling job runs in progress")
+		s.cancelRuns()
+		<-done
+		err = fmt.Errorf("draining job runs: %w", ctx.Err())
+	}
+	s.cancelRuns()
+
+	s.resign()
+	return err
+}
+
+// schedule runs a job whenever it is due, until the loop context is done. A
+// job never overlaps with itself, as runs are waited for before scheduling
+// the next.
+func (s *Scheduler) schedule(loopCtx context.Context, runCtx context.Context, j *scheduledJob) {
+	for {
+		next := j.Schedule.Next(time.Now())
+		j.next.Store(next.UnixNano())
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-loopCtx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if s.leader.Load() {
+			s.run(runCtx, j)
+		}
+	}
+}
+
+// run runs a job, retrying failed attempts, and records the run.
+func (s *Scheduler) run(ctx context.Context, j *scheduledJob) {
+	logger := s.logger.With(slog.String("job", j.Name))
+	ctx = logging.WithLogger(ctx, logger)
+
+	ctx, span := tracer.Start(ctx, "scheduler.job "+j.Name,
+		trace.WithAttributes(
+			attribute.String("job.name", j.Name),
+			attribute.String("job.instance", s.instance),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	id, err := s.repo.Start(ctx, j.Name, s.instance)
+	if err != nil {
+		logger.Error("failed to record job run", "error", err)
+	}
+
+	logger.Info("running job")
+	var (
+		attempts int
+		runErr   error
+	)
+	backoff := j.Backoff
+	for attempts <= j.Retries {
+		if attempts > 0 {
+			logger.Warn("job attempt failed, retrying", "attempt", attempts, "backoff", backoff, "error", runErr)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if ctx.Err() != nil {
+			runErr = errors.Join(runErr, ctx.Err())
+			break
+		}
+
+		attempts++
+		runErr = attempt(ctx, j)
+		if runErr == nil {
+			break
+		}
+	}
+
+	status := RunStatusSucceeded
+	errMsg := ""
+	if runErr != nil {
+		status = RunStatusFailed
+		errMsg = runErr.Error()
+		span.SetStatus(codes.Error, errMsg)
+		logger.Error("job failed", "attempts", attempts, "error", runErr)
+	} else {
+		logger.Info("job succeeded", "attempts", attempts, "duration", time.Since(start))
+	}
+	jobRuns.WithLabelValues(j.Name, string(status)).Inc()
+	jobDuration.WithLabelValues(j.Name).Observe(time.Since(start).Seconds())
+
+	if id != 0 {
+		err := s.repo.Finish(context.WithoutCancel(ctx), id, attempts, status, errMsg)
+		if err != nil {
+			logger.Error("failed to record job outcome", "error", err)
+		}
+	}
+}
+
+// attempt runs a job once with its timeout, turning panics into errors.
+func attempt(ctx context.Context, j *scheduledJob) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, j.Timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return j.Run(ctx)
+}
+
+// elect campaigns for leadership until the context is done.
+func (s *Scheduler) elect(ctx context.Context) {
+	ticker := time.NewTicker(electionInterval)
+	defer ticker.Stop()
+
+	for {
+		s.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign checks that the leader still holds the lock, or tries to take it
+// if there is no leader.
+func (s *Scheduler) campaign(ctx context.Context) {
+	if s.dialect == db.SQLite {
+		if !s.leader.Load() {
+			s.logger.Info("became scheduler leader", "instance", s.instance)
+			s.setLeader(true)
+		}
+		return
+	}
+	if s.conn != nil {
+		if err := s.conn.PingContext(ctx); err == nil || ctx.Err() != nil {
+			return
+		}
+		s.logger.Warn("lost scheduler leadership")
+		s.setLeader(false)
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		s.logger.Warn("failed to campaign for scheduler leadership", "error", err)
+		return
+	}
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, leaderLockID).Scan(&acquired)
+	if err != nil || !acquired {
+		conn.Close()
+		return
+	}
+
+	s.logger.Info("became scheduler leader", "instance", s.instance)
+	s.conn = conn
+	s.setLeader(true)
+}
+
+// resign gives up leadership, so another instance can take over without
+// waiting for the connection to time out.
+func (s *Scheduler) resign() {
+	s.setLeader(false)
+	if s.conn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, leaderLockID); err != nil {
+		s.logger.Warn("failed to release scheduler leadership", "error", err)
+	}
+	s.conn.Close()
+	s.conn = nil
+}
+
+func (s *Scheduler) setLeader(leader bool) {
+	s.leader.Store(leader)
+	if leader {
+		leaderGauge.Set(1)
+	} else {
+		leaderGauge.Set(0)
+	}
+}
+
+// IsLeader reports whether this instance runs the jobs.
+func (s *Scheduler) IsLeader() bool {
+	return s.leader.Load()
+}
+
+// Check is a health check failing when a job is overdue by more than its
+// longest possible run, meaning its loop is stuck. Only the leader checks,
+// as followers do not run jobs.
+func (s *Scheduler) Check(ctx context.Context) error {
+	if !s.leader.Load() {
+		return nil
+	}
+
+	s.mu.Lock()
+	jobs := s.jobs
+	s.mu.Unlock()
+
+	var errs []error
+	for _, j := range jobs {
+		lag := time.Since(time.Unix(0, j.next.Load()))
+		if j.next.Load() != 0 && lag > j.maxDuration()+time.Minute {
+			errs = append(errs, fmt.Errorf("job %s is %s overdue", j.Name, lag.Round(time.Second)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Jobs returns the registered jobs with their latest run.
+func (s *Scheduler) Jobs(ctx context.Context) ([]*JobStatus, error) {
+	s.mu.Lock()
+	jobs := s.jobs
+	s.mu.Unlock()
+
+	statuses := make([]*JobStatus, 0, len(jobs))
+	for _, j := range jobs {
+		last, err := s.repo.Last(ctx, j.Name)
+		if err != nil {
+			return nil, err
+		}
+		next := j.Schedule.Next(time.Now())
+		if j.next.Load() != 0 {
+			next = time.Unix(0, j.next.Load())
+		}
+		statuses = append(statuses, &JobStatus{
+			Name:    j.Name,
+			NextRun: next,
+			LastRun: last,
+		})
+	}
+	return statuses, nil
+}
+
+// Runs returns the latest runs of a job, newest first.
+func (s *Scheduler) Runs(ctx context.Context, job string, limit int) ([]*Run, error) {
+	s.mu.Lock()
+	known := false
+	for _, j := range s.jobs {
+		known = known || j.Name == job
+	}
+	s.mu.Unlock()
+	if !known {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownJob, job)
+	}
+	return s.repo.List(ctx, job, limit)
+}
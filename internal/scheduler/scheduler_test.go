@@ -0,0 +1,89 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	os.Exit(m.Run())
+}
+
+func TestRegister(t *testing.T) {
+	s, err := New(nil, db.Postgres, slog.Default(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(context.Context) error { return nil }
+
+	tests := []struct {
+		name string
+		job  Job
+		want error
+	}{
+		{name: "valid", job: Job{Name: "test.job", Schedule: Every(time.Minute), Run: run}},
+		{name: "duplicate", job: Job{Name: "test.job", Schedule: Every(time.Hour), Run: run}, want: ErrDuplicateJob},
+		{name: "duplicate builtin", job: Job{Name: "scheduler.prune_runs", Schedule: Every(time.Hour), Run: run}, want: ErrDuplicateJob},
+		{name: "no name", job: Job{Schedule: Every(time.Minute), Run: run}, want: ErrInvalidJob},
+		{name: "no schedule", job: Job{Name: "test.other", Run: run}, want: ErrInvalidJob},
+		{name: "no run", job: Job{Name: "test.other", Schedule: Every(time.Minute)}, want: ErrInvalidJob},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Register(tt.job); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if len(s.jobs) != 2 || s.jobs[1].Timeout != DefaultTimeout {
+		t.Errorf("got %d jobs, want the builtin and test job with the default timeout", len(s.jobs))
+	}
+
+	s.started = true
+	if err := s.Register(Job{Name: "test.late", Schedule: Every(time.Minute), Run: run}); !errors.Is(err, ErrSchedulerStarted) {
+		t.Errorf("got %v registering after start, want %v", err, ErrSchedulerStarted)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		from     string
+		want     string
+	}{
+		{name: "every aligns to the interval", schedule: Every(15 * time.Minute), from: "2026-03-02T10:07:30Z", want: "2026-03-02T10:15:00Z"},
+		{name: "every skips the current time", schedule: Every(time.Hour), from: "2026-03-02T10:00:00Z", want: "2026-03-02T11:00:00Z"},
+		{name: "cron", schedule: MustCron("30 4 * * *"), from: "2026-03-02T10:00:00Z", want: "2026-03-03T04:30:00Z"},
+		{name: "cron weekday", schedule: MustCron("0 3 * * 1"), from: "2026-03-03T00:00:00Z", want: "2026-03-09T03:00:00Z"},
+		{name: "cron time zone", schedule: MustCron("CRON_TZ=Europe/Oslo 0 3 * * *"), from: "2026-03-02T00:00:00Z", want: "2026-03-02T02:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Next(at(tt.from)); !got.Equal(at(tt.want)) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Cron("61 * * * *"); err == nil {
+		t.Error("got no error for an invalid expression")
+	}
+}
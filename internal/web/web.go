@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/evenlwanvik/smartsplit/internal/flags"
 	"github.com/evenlwanvik/smartsplit/internal/logging"
 	"github.com/evenlwanvik/smartsplit/internal/rest"
 	"github.com/evenlwanvik/smartsplit/internal/workout"
@@ -16,14 +17,36 @@ import (
 type Service struct {
 	tpl     *template.Template
 	workout workout.Client
+	flags   *flags.Flags
 }
 
 // NewWebService creates a new WebService.
-func NewService(workout workout.Client) Service {
+func NewService(workout workout.Client, flags *flags.Flags) Service {
+	// flag and csrfToken are bound to the request in render.
+	funcs := template.FuncMap{
+		"flag":      func(string) bool { return false },
+		"csrfToken": func() string { return "" },
+	}
 	return Service{
-		tpl:     template.Must(template.ParseFS(htmlFS, "templates/*.html")),
+		tpl:     template.Must(template.New("").Funcs(funcs).ParseFS(htmlFS, "templates/*.html")),
 		workout: workout,
+		flags:   flags,
+	}
+}
+
+// render executes a template, with {{ flag "name" }} reporting whether a
+// feature flag is on for the requesting user, and {{ csrfToken }} returning
+// the token requests changing state must send.
+func (svc *Service) render(w http.ResponseWriter, r *http.Request, name string, data any) error {
+	tpl, err := svc.tpl.Clone()
+	if err != nil {
+		return err
 	}
+	tpl.Funcs(template.FuncMap{
+		"flag":      func(flag string) bool { return svc.flags.Enabled(r.Context(), flag) },
+		"csrfToken": func() string { return rest.CSRFTokenFromContext(r.Context()) },
+	})
+	return tpl.ExecuteTemplate(w, name, data)
 }
 
 // RegisterRoutes hooks up endpoints.
@@ -32,38 +55,43 @@ func (svc *Service) RegisterRoutes(ctx context.Context, mux *http.ServeMux) {
 
 	routeDefinitions := rest.RouteDefinitionList{
 		{
-			"GET /dashboard",
-			svc.dashboardPage,
+			Path:    "GET /dashboard",
+			Handler: svc.dashboardPage,
+		},
+		{
+			Path:    "POST /plans/new",
+			Handler: svc.newPlanPage,
 		},
 		{
-			"POST /plans/new",
-			svc.newPlanPage,
+			Path:    "GET /plans/{id}",
+			Handler: svc.planPage,
 		},
 		{
-			"GET /plans/{id}",
-			svc.planPage,
+			Path:    "DELETE /plans/{id}",
+			Handler: svc.deletePlan,
 		},
 		{
-			"DELETE /plans/{id}",
-			svc.deletePlan,
+			Path:    "POST /plans/entries",
+			Handler: svc.planEntriesPage,
 		},
 		{
-			"POST /plans/entries",
-			svc.planEntriesPage,
+			Path:    "GET /plans/recent",
+			Handler: svc.recentPlans,
 		},
 		{
-			"GET /plans/recent",
-			svc.recentPlans,
+			Path:    "GET /history",
+			Handler: svc.historyPage,
 		},
 		{
-			"GET /history",
-			svc.historyPage,
+			Path:    "GET /static/",
+			Handler: http.FileServerFS(staticFS).ServeHTTP,
+			Class:   rest.RateClassExempt,
 		},
 	}
 
 	for _, d := range routeDefinitions {
 		logger.Info("adding route", "route", d.Path)
-		mux.Handle(d.Path, d.Handler)
+		mux.Handle(d.Path, d)
 	}
 }
 
@@ -111,13 +139,15 @@ func (svc *Service) dashboardPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	vm := DashboardVM{
-		Suggestion:    &SuggestionVM{ID: "seed", PrimaryLabel: "Upper Pull (back, biceps)", Accessories: "Core stability", Avoid: "Chest"},
 		RecentMuscles: []MuscleVM{{Name: "Chest"}, {Name: "Quads"}},
 		KPI:           KPI{Sessions: 3, UniqueMuscles: 8, RunKM: 18},
 		Muscles:       muscles,
 	}
+	if svc.flags.Enabled(ctx, "suggestions") {
+		vm.Suggestion = &SuggestionVM{ID: "seed", PrimaryLabel: "Upper Pull (back, biceps)", Accessories: "Core stability", Avoid: "Chest"}
+	}
 
-	if err := svc.tpl.ExecuteTemplate(w, "dashboard.html", vm); err != nil {
+	if err := svc.render(w, r, "dashboard.html", vm); err != nil {
 		rest.InternalServerErrorResponse(w, r, err)
 		return
 	}
@@ -161,7 +191,7 @@ func (svc *Service) newPlanPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = svc.tpl.ExecuteTemplate(w, "_plan_entries_form.html", plan)
+	err = svc.render(w, r, "_plan_entries_form.html", plan)
 	if err != nil {
 		rest.InternalServerErrorResponse(w, r, err)
 		return
@@ -257,7 +287,7 @@ func (svc *Service) recentPlans(w http.ResponseWriter, r *http.Request) {
 	for _, p := range plans {
 		recentPlansVM = append(recentPlansVM, &RecentPlansVM{p, humanizeTime(p.CreatedAt)})
 	}
-	err = svc.tpl.ExecuteTemplate(w, "_recent_plans.html", recentPlansVM)
+	err = svc.render(w, r, "_recent_plans.html", recentPlansVM)
 	if err != nil {
 		rest.InternalServerErrorResponse(w, r, err)
 		return
@@ -286,7 +316,7 @@ func (svc *Service) historyPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	data := HistoryVM{plans, metadata.LastSeen}
-	err = svc.tpl.ExecuteTemplate(w, "history.html", data)
+	err = svc.render(w, r, "history.html", data)
 	if err != nil {
 		rest.InternalServerErrorResponse(w, r, err)
 		return
@@ -310,7 +340,7 @@ func (svc *Service) planPage(w http.ResponseWriter, r *http.Request) {
 		rest.BadRequestResponse(w, r, "could not list plans", err)
 		return
 	}
-	err = svc.tpl.ExecuteTemplate(w, "_plan_item.html", plan)
+	err = svc.render(w, r, "_plan_item.html", plan)
 	if err != nil {
 		rest.InternalServerErrorResponse(w, r, err)
 		return
@@ -0,0 +1,75 @@
+package workout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MusclesChannel is the Postgres channel notified when the muscles change.
+const MusclesChannel = "workout_muscles_changed"
+
+// muscleCacheTTL bounds how long the cached muscles are served, in case a
+// change notification is missed, e.g. while the listener reconnects, or on
+// SQLite, where other processes such as an import cannot notify.
+const muscleCacheTTL = 5 * time.Minute
+
+// muscleCache is a read-through cache of the muscle catalog, which rarely
+// changes but is read on every dashboard render.
+type muscleCache struct {
+	mu       sync.RWMutex
+	muscles  []Muscle
+	loadedAt time.Time
+	// generation is bumped on every invalidation, so a load started before
+	// one is not cached.
+	generation uint64
+}
+
+// get returns the cached muscles, loading them if they are missing or
+// expired. The muscles are copied, so callers may modify them.
+func (c *muscleCache) get(ctx context.Context, load func(ctx context.Context) ([]*Muscle, error)) ([]*Muscle, error) {
+	c.mu.RLock()
+	muscles, loadedAt, generation := c.muscles, c.loadedAt, c.generation
+	c.mu.RUnlock()
+
+	if muscles != nil && time.Since(loadedAt) < muscleCacheTTL {
+		muscleCacheRequests.WithLabelValues("hit").Inc()
+		return copyMuscles(muscles), nil
+	}
+	muscleCacheRequests.WithLabelValues("miss").Inc()
+
+	loaded, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	muscles = make([]Muscle, len(loaded))
+	for i, m := range loaded {
+		muscles[i] = *m
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.muscles = muscles
+		c.loadedAt = time.Now()
+	}
+	c.mu.Unlock()
+	return loaded, nil
+}
+
+// invalidate drops the cached muscles, so the next read loads them again.
+func (c *muscleCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.muscles = nil
+	c.generation++
+	muscleCacheInvalidations.Inc()
+}
+
+func copyMuscles(muscles []Muscle) []*Muscle {
+	out := make([]*Muscle, len(muscles))
+	for i := range muscles {
+		m := muscles[i]
+		out[i] = &m
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+package workout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/db/dbtest"
+)
+
+// countingLoader loads the given muscles, counting the loads.
+type countingLoader struct {
+	muscles []*Muscle
+	err     error
+	loads   int
+	// during is called while loading, before the muscles are returned.
+	during func()
+}
+
+func (l *countingLoader) load(context.Context) ([]*Muscle, error) {
+	l.loads++
+	if l.during != nil {
+		l.during()
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+	out := make([]*Muscle, len(l.muscles))
+	for i, m := range l.muscles {
+		muscle := *m
+		out[i] = &muscle
+	}
+	return out, nil
+}
+
+func TestMuscleCache(t *testing.T) {
+	ctx := context.Background()
+	var c muscleCache
+	l := &countingLoader{muscles: []*Muscle{{ID: 1, Name: "Biceps"}, {ID: 2, Name: "Triceps"}}}
+
+	get := func() []*Muscle {
+		t.Helper()
+		muscles, err := c.get(ctx, l.load)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return muscles
+	}
+
+	first := get()
+	second := get()
+	if l.loads != 1 || len(second) != 2 || second[1].Name != "Triceps" {
+		t.Fatalf("got %d loads and %v, want the second read cached", l.loads, second)
+	}
+
+	// Callers get copies.
+	first[0].Name = "changed"
+	second[1].Name = "changed"
+	if got := get(); got[0].Name != "Biceps" || got[1].Name != "Triceps" {
+		t.Errorf("got %v, want the cache unchanged by callers", got)
+	}
+
+	// Invalidating loads the muscles again.
+	c.invalidate()
+	l.muscles = append(l.muscles, &Muscle{ID: 3, Name: "Deltoids"})
+	if got := get(); l.loads != 2 || len(got) != 3 {
+		t.Fatalf("got %d loads and %d muscles after invalidating", l.loads, len(got))
+	}
+
+	// Expired muscles are loaded again.
+	c.mu.Lock()
+	c.loadedAt = time.Now().Add(-muscleCacheTTL)
+	c.mu.Unlock()
+	get()
+	if l.loads != 3 {
+		t.Errorf("got %d loads, want the expired muscles loaded again", l.loads)
+	}
+	get()
+	if l.loads != 3 {
+		t.Errorf("got %d loads, want the reloaded muscles cached", l.loads)
+	}
+}
+
+func TestMuscleCacheInvalidatedDuringLoad(t *testing.T) {
+	ctx := context.Background()
+	var c muscleCache
+	l := &countingLoader{muscles: []*Muscle{{ID: 1, Name: "Biceps"}}}
+
+	// A change lands while the muscles are loaded, so the loaded muscles
+	// may be stale, and are returned but not cached.
+	l.during = c.invalidate
+	if _, err := c.get(ctx, l.load); err != nil {
+		t.Fatal(err)
+	}
+	l.during = nil
+	if _, err := c.get(ctx, l.load); err != nil {
+		t.Fatal(err)
+	}
+	if l.loads != 2 {
+		t.Errorf("got %d loads, want the load racing an invalidation not cached", l.loads)
+	}
+
+	// Errors are not cached either.
+	c.invalidate()
+	l.err = errors.New("connection refused")
+	if _, err := c.get(ctx, l.load); !errors.Is(err, l.err) {
+		t.Fatalf("got %v, want %v", err, l.err)
+	}
+	l.err = nil
+	if muscles, err := c.get(ctx, l.load); err != nil || len(muscles) != 1 {
+		t.Errorf("got %v, %v after a failed load", muscles, err)
+	}
+}
+
+func TestServiceInvalidatesMusclesOnCreate(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewRepository(dbtest.NewSQLite(t), db.SQLite), noAudit{})
+
+	before, err := svc.ReadMuscles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.CreateMuscle(ctx, &MuscleInput{Name: "Tibialis", MuscleGroup: "legs"}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := svc.ReadMuscles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before)+1 {
+		t.Errorf("got %d muscles after creating one, want %d", len(after), len(before)+1)
+	}
+}
+
+// noAudit discards audit records.
+type noAudit struct{}
+
+func (noAudit) RecordAudit(context.Context, string, string, string, any, any) {}
@@ -20,18 +20,18 @@ func (h *Handlers) RegisterRoutes(ctx context.Context, mux *http.ServeMux) {
 
 	routeDefinitions := rest.RouteDefinitionList{
 		{
-			"GET /api/v0/workout/muscles",
-			h.listMuscles,
+			Path:    "GET /api/v0/workout/muscles",
+			Handler: h.listMuscles,
 		},
 		{
-			"GET /api/v0/workout/muscles/create",
-			h.createMuscle,
+			Path:    "GET /api/v0/workout/muscles/create",
+			Handler: h.createMuscle,
 		},
 	}
 
 	for _, d := range routeDefinitions {
 		logger.Info("adding route", "route", d.Path)
-		mux.Handle(d.Path, d.Handler)
+		mux.Handle(d.Path, d)
 	}
 }
 
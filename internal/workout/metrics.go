@@ -0,0 +1,34 @@
+package workout
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+)
+
+var (
+	plansCreated = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "workout",
+		Name:      "plans_created_total",
+		Help:      "Number of workout plans created.",
+	})
+	entriesLogged = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "workout",
+		Name:      "plan_entries_logged_total",
+		Help:      "Number of plan entries logged.",
+	})
+	muscleCacheRequests = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "workout",
+		Name:      "muscle_cache_requests_total",
+		Help:      "Number of muscle catalog reads by cache result, hit or miss.",
+	}, []string{"result"})
+	muscleCacheInvalidations = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "workout",
+		Name:      "muscle_cache_invalidations_total",
+		Help:      "Number of times the cached muscle catalog was dropped.",
+	})
+)
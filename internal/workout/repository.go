@@ -3,26 +3,62 @@ package workout
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/events"
+	"github.com/evenlwanvik/smartsplit/internal/metrics"
+	"github.com/evenlwanvik/smartsplit/internal/tracing"
 )
 
 // Repository provides access to workout domain store.
 type Repository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect db.Dialect
+	// q runs the queries rewritten to the dialect, and is the transaction
+	// within WithTx.
+	q db.Querier
 }
 
 // NewRepository creates a new Workout repository.
-func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db: db}
+func NewRepository(db *sql.DB, dialect db.Dialect) *Repository {
+	return &Repository{db: db, dialect: dialect, q: dialect.Wrap(db)}
+}
+
+// WithTx calls fn with a repository running its queries in a transaction,
+// which is committed if fn returns nil and rolled back otherwise.
+func (r *Repository) WithTx(ctx context.Context, fn func(tx *Repository) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&Repository{db: r.db, dialect: r.dialect, q: r.dialect.Wrap(tx)}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AppendEvent stores a domain event in the outbox. It must be called within
+// WithTx, so the event is only published if the change is committed.
+func (r *Repository) AppendEvent(ctx context.Context, ev events.Event) error {
+	return events.Append(ctx, r.q, ev)
 }
 
 func (r *Repository) SelectMuscle(ctx context.Context, id int) (*Muscle, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.SelectMuscle")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "SelectMuscle", time.Now())
 	const query = `
 SELECT id, name, muscle_group, description
 FROM workout.muscles
 WHERE id = $1;
 `
 	var muscle Muscle
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
+	err := r.q.QueryRowContext(ctx, query, id).Scan(
 		&muscle.ID,
 		&muscle.Name,
 		&muscle.Group,
@@ -33,12 +69,15 @@ WHERE id = $1;
 
 // SelectMuscles returns slice of muscles.
 func (r *Repository) SelectMuscles(ctx context.Context) ([]*Muscle, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.SelectMuscles")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "SelectMuscles", time.Now())
 	// TODO: Make muscles user specific. Maybe in a later version.
 	const query = `
 SELECT id, name, muscle_group, description
 FROM workout.muscles;
 `
-	rows, err := r.db.QueryContext(ctx, query)
+	rows, err := r.q.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -60,13 +99,16 @@ FROM workout.muscles;
 
 // InsertMuscle inserts a new muscle and returns its ID.
 func (r *Repository) InsertMuscle(ctx context.Context, input *MuscleInput) (*Muscle, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.InsertMuscle")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "InsertMuscle", time.Now())
 	const query = `
 INSERT INTO workout.muscles (name, muscle_group, description)
 VALUES ($1, $2, $3)
 RETURNING id, name, muscle_group, description;
 `
 	var muscle Muscle
-	err := r.db.QueryRowContext(
+	err := r.q.QueryRowContext(
 		ctx,
 		query,
 		input.Name,
@@ -83,12 +125,15 @@ RETURNING id, name, muscle_group, description;
 
 // SelectRanks returns a slice of muscle ranks.
 func (r *Repository) SelectRanks(ctx context.Context, filters Filters) ([]*MuscleRank, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.SelectRanks")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "SelectRanks", time.Now())
 	const query = `
 SELECT id, user_id, muscle_id, rank, updated_at
-FROM workout.muscles_ranks
-WHERE (user_id = :user_id OR :user_id IS NULL);
+FROM workout.muscle_ranks
+WHERE (user_id = $1 OR $1 IS NULL);
 `
-	rows, err := r.db.QueryContext(ctx, query, sql.Named("user_id", filters.UserID))
+	rows, err := r.q.QueryContext(ctx, query, filters.UserID)
 	if err != nil {
 		return nil, err
 	}
@@ -110,15 +155,18 @@ WHERE (user_id = :user_id OR :user_id IS NULL);
 
 // UpsertRank creates or updates a muscle rank for a user.
 func (r *Repository) UpsertRank(ctx context.Context, input *MuscleRank) (*MuscleRank, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.UpsertRank")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "UpsertRank", time.Now())
 	const query = `
-INSERT INTO workout.muscles_ranks (user_id, muscle_id, rank)
+INSERT INTO workout.muscle_ranks (user_id, muscle_id, rank)
 VALUES ($1, $2, $3)
 ON CONFLICT (user_id, muscle_id)
   DO UPDATE SET rank = EXCLUDED.rank, updated_at = now()
 RETURNING id, user_id, muscle_id, rank, updated_at;
 `
 	var mr MuscleRank
-	err := r.db.QueryRowContext(ctx, query, input.UserID, input.MuscleID, input.Rank).
+	err := r.q.QueryRowContext(ctx, query, input.UserID, input.MuscleID, input.Rank).
 		Scan(&mr.ID, &mr.UserID, &mr.MuscleID, &mr.Rank, &mr.UpdatedAt)
 	return &mr, err
 }
@@ -127,6 +175,9 @@ RETURNING id, user_id, muscle_id, rank, updated_at;
 func (r *Repository) SelectPlans(
 	ctx context.Context, filters Filters,
 ) ([]*Plan, *Metadata, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.SelectPlans")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "SelectPlans", time.Now())
 	const query = `
 SELECT id, user_id, date, created_at, notes
 FROM workout.plans
@@ -136,7 +187,7 @@ AND (id > $3 OR $3 IS NULL)
 ORDER BY id
 LIMIT $4;
 `
-	rows, err := r.db.QueryContext(
+	rows, err := r.q.QueryContext(
 		ctx,
 		query,
 		filters.UserID,
@@ -169,13 +220,16 @@ LIMIT $4;
 
 // InsertPlan inserts a new workout plan and returns its ID.
 func (r *Repository) InsertPlan(ctx context.Context, input PlanInput) (*Plan, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.InsertPlan")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "InsertPlan", time.Now())
 	const query = `
 INSERT INTO workout.plans (user_id, date, notes)
 VALUES ($1, NOW(), $2)
 RETURNING id, user_id, date, notes;
 `
 	var plan Plan
-	err := r.db.QueryRowContext(ctx, query, input.UserID, input.Notes).Scan(
+	err := r.q.QueryRowContext(ctx, query, input.UserID, input.Notes).Scan(
 		&plan.ID, &plan.UserID, &plan.Date, &plan.Notes,
 	)
 	return &plan, err
@@ -183,13 +237,16 @@ RETURNING id, user_id, date, notes;
 
 // DeletePlan deletes a workout plan by ID; returns deleted plan.
 func (r *Repository) DeletePlan(ctx context.Context, id int) (*Plan, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.DeletePlan")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "DeletePlan", time.Now())
 	const query = `
 DELETE FROM workout.plans
 WHERE id = $1
 RETURNING id, user_id, date, created_at, notes;
 `
 	var plan Plan
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
+	err := r.q.QueryRowContext(ctx, query, id).Scan(
 		&plan.ID, &plan.UserID, &plan.Date, &plan.CreatedAt, &plan.Notes,
 	)
 	return &plan, err
@@ -197,12 +254,15 @@ RETURNING id, user_id, date, created_at, notes;
 
 // SelectPlanEntries returns a slice of plan entries.
 func (r *Repository) SelectPlanEntries(ctx context.Context, filters Filters) ([]*PlanEntry, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.SelectPlanEntries")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "SelectPlanEntries", time.Now())
 	const query = `
 SELECT id, plan_id, muscle_id, sets, created_at
 FROM workout.plan_entries
 WHERE (plan_id = $1 OR $1 IS NULL);
 `
-	rows, err := r.db.QueryContext(
+	rows, err := r.q.QueryContext(
 		ctx,
 		query,
 		filters.PlanID,
@@ -226,14 +286,84 @@ WHERE (plan_id = $1 OR $1 IS NULL);
 	return entries, nil
 }
 
+// SelectPlansWithEntries returns workout plans with their entries and the
+// muscles of the entries, in two queries however many plans there are.
+func (r *Repository) SelectPlansWithEntries(
+	ctx context.Context, filters Filters,
+) ([]*Plan, *Metadata, error) {
+	ctx, span := tracer.Start(ctx, "workout.Repository.SelectPlansWithEntries")
+	defer span.End()
+
+	plans, metadata, err := r.SelectPlans(ctx, filters)
+	if err != nil || len(plans) == 0 {
+		return plans, metadata, err
+	}
+
+	ids := make([]int, len(plans))
+	byID := make(map[int]*Plan, len(plans))
+	for i, p := range plans {
+		ids[i] = p.ID
+		byID[p.ID] = p
+	}
+	entries, err := r.SelectPlanEntriesWithMuscles(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		p := byID[e.PlanID]
+		p.Entries = append(p.Entries, e)
+	}
+	return plans, metadata, nil
+}
+
+// SelectPlanEntriesWithMuscles returns the entries of the given plans with
+// their muscles, ordered by plan and then by entry.
+func (r *Repository) SelectPlanEntriesWithMuscles(ctx context.Context, planIDs []int) ([]*PlanEntry, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.SelectPlanEntriesWithMuscles")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "SelectPlanEntriesWithMuscles", time.Now())
+	const query = `
+SELECT e.id, e.plan_id, e.muscle_id, e.sets, e.created_at,
+       m.id, m.name, m.muscle_group, m.description
+FROM workout.plan_entries e
+JOIN workout.muscles m ON m.id = e.muscle_id
+WHERE e.plan_id = ANY($1)
+ORDER BY e.plan_id, e.id;
+`
+	rows, err := r.q.QueryContext(ctx, query, db.Array(r.dialect, planIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*PlanEntry
+	for rows.Next() {
+		e := &PlanEntry{Muscle: new(Muscle)}
+		if err := rows.Scan(
+			&e.ID, &e.PlanID, &e.MuscleID, &e.Sets, &e.CreatedAt,
+			&e.Muscle.ID, &e.Muscle.Name, &e.Muscle.Group, &e.Muscle.Description,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
 // DeleteManyPlanEntries deletes plan entries by filters; returns number of deleted entries.
 func (r *Repository) DeleteManyPlanEntries(ctx context.Context, filters Filters) (int64, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.DeleteManyPlanEntries")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "DeleteManyPlanEntries", time.Now())
 	const query = `
 DELETE FROM workout.plan_entries
 WHERE (plan_id = $1 OR $1 IS NULL)
 AND (muscle_id = $2 OR $2 IS NULL);
 `
-	result, err := r.db.ExecContext(
+	result, err := r.q.ExecContext(
 		ctx,
 		query,
 		filters.PlanID,
@@ -247,13 +377,16 @@ AND (muscle_id = $2 OR $2 IS NULL);
 
 // InsertPlanEntry creates a new plan entry; returns error if duplicate.
 func (r *Repository) InsertPlanEntry(ctx context.Context, input PlanEntry) (*PlanEntry, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.InsertPlanEntry")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "InsertPlanEntry", time.Now())
 	const query = `
 INSERT INTO workout.plan_entries (plan_id, muscle_id, sets)
 VALUES ($1, $2, $3)
 RETURNING id, created_at, plan_id, muscle_id, sets;
 `
 	var pe PlanEntry
-	err := r.db.QueryRowContext(
+	err := r.q.QueryRowContext(
 		ctx,
 		query,
 		input.PlanID,
@@ -263,7 +396,35 @@ RETURNING id, created_at, plan_id, muscle_id, sets;
 	return &pe, err
 }
 
+// InsertPlanEntries creates plan entries in a single statement; returns
+// error if any is a duplicate.
+func (r *Repository) InsertPlanEntries(ctx context.Context, inputs []PlanEntry) error {
+	if len(inputs) == 0 {
+		return nil
+	}
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.InsertPlanEntries")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "InsertPlanEntries", time.Now())
+
+	var query strings.Builder
+	query.WriteString("INSERT INTO workout.plan_entries (plan_id, muscle_id, sets)\nVALUES ")
+	args := make([]any, 0, 3*len(inputs))
+	for i, input := range inputs {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&query, "($%d, $%d, $%d)", n+1, n+2, n+3)
+		args = append(args, input.PlanID, input.MuscleID, input.Sets)
+	}
+	_, err := r.q.ExecContext(ctx, query.String(), args...)
+	return err
+}
+
 func (r *Repository) PatchPlanEntry(ctx context.Context, input PlanEntryPatch) (*PlanEntry, error) {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.PatchPlanEntry")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "PatchPlanEntry", time.Now())
 	const query = `
 UPDATE workout.plan_entries
 SET sets = $2
@@ -271,7 +432,7 @@ WHERE id = $1
 RETURNING id, created_at, plan_id, muscle_id, sets;
 `
 	var pe PlanEntry
-	err := r.db.QueryRowContext(
+	err := r.q.QueryRowContext(
 		ctx,
 		query,
 		input.ID,
@@ -279,3 +440,32 @@ RETURNING id, created_at, plan_id, muscle_id, sets;
 	).Scan(&pe.ID, &pe.CreatedAt, &pe.PlanID, &pe.MuscleID, &pe.Sets)
 	return &pe, err
 }
+
+// DeleteUserData deletes all plans, plan entries and muscle ranks owned by a
+// user within the given transaction.
+func (r *Repository) DeleteUserData(ctx context.Context, tx *sql.Tx, userID int) error {
+	ctx, span := tracing.StartQuery(ctx, tracer, "workout.Repository.DeleteUserData")
+	defer span.End()
+	defer metrics.ObserveQuery("workout", "DeleteUserData", time.Now())
+	queries := []string{
+		`
+DELETE FROM workout.plan_entries
+WHERE plan_id IN (SELECT id FROM workout.plans WHERE user_id = $1);
+`,
+		`
+DELETE FROM workout.plans
+WHERE user_id = $1;
+`,
+		`
+DELETE FROM workout.muscle_ranks
+WHERE user_id = $1;
+`,
+	}
+	q := r.dialect.Wrap(tx)
+	for _, query := range queries {
+		if _, err := q.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package workout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/evenlwanvik/smartsplit/internal/db"
+	"github.com/evenlwanvik/smartsplit/internal/db/dbtest"
+)
+
+func TestRepositoryRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRepository(dbtest.NewSQLite(t), db.SQLite)
+
+	t.Run("muscles", func(t *testing.T) {
+		created, err := repo.InsertMuscle(ctx, &MuscleInput{Name: "Tibialis", MuscleGroup: "legs", Description: "front of the shin"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.SelectMuscle(ctx, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != *created || got.Group != "legs" {
+			t.Errorf("got %+v, want %+v", got, created)
+		}
+		muscles, err := repo.SelectMuscles(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.ContainsFunc(muscles, func(m *Muscle) bool { return m.ID == created.ID }) {
+			t.Errorf("got %d muscles, want the created one among them", len(muscles))
+		}
+		if _, err := repo.SelectMuscle(ctx, 9999); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("got error %v, want %v", err, sql.ErrNoRows)
+		}
+	})
+
+	t.Run("ranks", func(t *testing.T) {
+		for _, rank := range []int{3, 5} {
+			got, err := repo.UpsertRank(ctx, &MuscleRank{UserID: 1, MuscleID: 1, Rank: &rank})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got.Rank != rank {
+				t.Errorf("got rank %d, want %d", *got.Rank, rank)
+			}
+		}
+		userID := 1
+		ranks, err := repo.SelectRanks(ctx, Filters{UserID: &userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ranks) != 1 || *ranks[0].Rank != 5 {
+			t.Errorf("got %d ranks, want the one rank to be updated", len(ranks))
+		}
+	})
+
+	t.Run("plans", func(t *testing.T) {
+		var planIDs []int
+		for range 3 {
+			plan, err := repo.InsertPlan(ctx, PlanInput{UserID: 1, Notes: "legs day"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.InsertPlanEntries(ctx, benchEntryInputs(plan.ID)[:2]); err != nil {
+				t.Fatal(err)
+			}
+			planIDs = append(planIDs, plan.ID)
+		}
+
+		pageSize := 2
+		plans, metadata, err := repo.SelectPlansWithEntries(ctx, Filters{PageSize: &pageSize})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plans) != 2 || plans[0].ID != planIDs[0] || metadata.LastSeen != planIDs[1] {
+			t.Fatalf("got %d plans up to %d, want the first page of 2", len(plans), metadata.LastSeen)
+		}
+		for _, plan := range plans {
+			if plan.Notes != "legs day" || len(plan.Entries) != 2 {
+				t.Fatalf("got plan %+v, want its notes and 2 entries", plan)
+			}
+			for _, entry := range plan.Entries {
+				if entry.PlanID != plan.ID || entry.Muscle == nil || entry.Muscle.ID != entry.MuscleID || entry.Sets != 3 {
+					t.Errorf("got entry %+v, want it with its muscle", entry)
+				}
+			}
+		}
+		plans, _, err = repo.SelectPlansWithEntries(ctx, Filters{PageSize: &pageSize, LastSeen: &metadata.LastSeen})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plans) != 1 || plans[0].ID != planIDs[2] {
+			t.Fatalf("got %d plans, want the last plan on the second page", len(plans))
+		}
+
+		entry := plans[0].Entries[0]
+		patched, err := repo.PatchPlanEntry(ctx, PlanEntryPatch{ID: entry.ID, Sets: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if patched.Sets != 5 || patched.PlanID != entry.PlanID {
+			t.Errorf("got %+v, want 5 sets", patched)
+		}
+		n, err := repo.DeleteManyPlanEntries(ctx, Filters{PlanID: &planIDs[2]})
+		if err != nil || n != 2 {
+			t.Errorf("got %d, %v, want 2 entries deleted", n, err)
+		}
+		deleted, err := repo.DeletePlan(ctx, planIDs[2])
+		if err != nil || deleted.ID != planIDs[2] {
+			t.Errorf("got %v, %v, want plan %d deleted", deleted, err, planIDs[2])
+		}
+	})
+
+	t.Run("delete user data", func(t *testing.T) {
+		tx, err := repo.db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if err := repo.DeleteUserData(ctx, tx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		userID := 1
+		plans, _, err := repo.SelectPlans(ctx, Filters{UserID: &userID})
+		if err != nil || len(plans) != 0 {
+			t.Errorf("got %d plans, %v, want none left", len(plans), err)
+		}
+		ranks, err := repo.SelectRanks(ctx, Filters{UserID: &userID})
+		if err != nil || len(ranks) != 0 {
+			t.Errorf("got %d ranks, %v, want none left", len(ranks), err)
+		}
+	})
+}
+
+const (
+	benchPlans   = 50
+	benchEntries = 6
+)
+
+// countingQuerier counts the queries run, which is what the benchmarks
+// compare. Each one is a round trip to Postgres, so the timings on SQLite
+// understate the difference.
+type countingQuerier struct {
+	q db.Querier
+	n *atomic.Int64
+}
+
+func (c countingQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	c.n.Add(1)
+	return c.q.ExecContext(ctx, query, args...)
+}
+
+func (c countingQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	c.n.Add(1)
+	return c.q.QueryContext(ctx, query, args...)
+}
+
+func (c countingQuerier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	c.n.Add(1)
+	return c.q.QueryRowContext(ctx, query, args...)
+}
+
+// newBenchRepository returns a repository on a migrated SQLite database
+// holding benchPlans plans of benchEntries entries each.
+func newBenchRepository(b *testing.B) *Repository {
+	b.Helper()
+	ctx := context.Background()
+
+	repo := NewRepository(dbtest.NewSQLite(b), db.SQLite)
+	for range benchPlans {
+		plan, err := repo.InsertPlan(ctx, PlanInput{UserID: 1, Notes: "bench"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := repo.InsertPlanEntries(ctx, benchEntryInputs(plan.ID)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func benchEntryInputs(planID int) []PlanEntry {
+	inputs := make([]PlanEntry, benchEntries)
+	for i := range inputs {
+		inputs[i] = PlanEntry{PlanID: planID, MuscleID: i + 1, Sets: 3}
+	}
+	return inputs
+}
+
+// counting returns a copy of the repository counting its queries in n.
+func (r *Repository) counting(n *atomic.Int64) *Repository {
+	return &Repository{db: r.db, dialect: r.dialect, q: countingQuerier{q: r.q, n: n}}
+}
+
+func BenchmarkListPlans(b *testing.B) {
+	ctx := context.Background()
+	repo := newBenchRepository(b)
+	pageSize := benchPlans
+
+	b.Run("per_entry", func(b *testing.B) {
+		var n atomic.Int64
+		repo := repo.counting(&n)
+		for b.Loop() {
+			plans, _, err := repo.SelectPlans(ctx, Filters{PageSize: &pageSize})
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, plan := range plans {
+				plan.Entries, err = repo.SelectPlanEntries(ctx, Filters{PlanID: &plan.ID})
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, entry := range plan.Entries {
+					if entry.Muscle, err = repo.SelectMuscle(ctx, entry.MuscleID); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		}
+		b.ReportMetric(float64(n.Load())/float64(b.N), "queries/op")
+	})
+
+	b.Run("batched", func(b *testing.B) {
+		var n atomic.Int64
+		repo := repo.counting(&n)
+		for b.Loop() {
+			plans, _, err := repo.SelectPlansWithEntries(ctx, Filters{PageSize: &pageSize})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(plans) != benchPlans || len(plans[0].Entries) != benchEntries || plans[0].Entries[0].Muscle.Name == "" {
+				b.Fatalf("got %d plans, want %d with %d entries and their muscles", len(plans), benchPlans, benchEntries)
+			}
+		}
+		b.ReportMetric(float64(n.Load())/float64(b.N), "queries/op")
+	})
+}
+
+func BenchmarkCreatePlanWithEntries(b *testing.B) {
+	ctx := context.Background()
+	repo := newBenchRepository(b)
+
+	b.Run("per_entry", func(b *testing.B) {
+		var n atomic.Int64
+		for b.Loop() {
+			err := repo.WithTx(ctx, func(tx *Repository) error {
+				tx = tx.counting(&n)
+				plan, err := tx.InsertPlan(ctx, PlanInput{UserID: 1})
+				if err != nil {
+					return err
+				}
+				for _, input := range benchEntryInputs(plan.ID) {
+					entry, err := tx.InsertPlanEntry(ctx, input)
+					if err != nil {
+						return err
+					}
+					if entry.Muscle, err = tx.SelectMuscle(ctx, entry.MuscleID); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(n.Load())/float64(b.N), "queries/op")
+	})
+
+	b.Run("multi_row", func(b *testing.B) {
+		var n atomic.Int64
+		for b.Loop() {
+			err := repo.WithTx(ctx, func(tx *Repository) error {
+				tx = tx.counting(&n)
+				plan, err := tx.InsertPlan(ctx, PlanInput{UserID: 1})
+				if err != nil {
+					return err
+				}
+				if err := tx.InsertPlanEntries(ctx, benchEntryInputs(plan.ID)); err != nil {
+					return err
+				}
+				plan.Entries, err = tx.SelectPlanEntriesWithMuscles(ctx, []int{plan.ID})
+				return err
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(n.Load())/float64(b.N), "queries/op")
+	})
+}
@@ -2,14 +2,18 @@ package workout
 
 import (
 	"context"
+	"database/sql"
 	"log/slog"
+	"strconv"
 	"time"
 
+	"github.com/evenlwanvik/smartsplit/internal/events"
 	"github.com/evenlwanvik/smartsplit/internal/logging"
 )
 
 type Client interface {
 	ReadMuscles(ctx context.Context) ([]*Muscle, error)
+	CreateMuscle(ctx context.Context, input *MuscleInput) (*Muscle, error)
 	CreatePlanWithEntries(ctx context.Context, notes string, muscleIDs []int) (*Plan, error)
 	UpdatePlanEntrySets(ctx context.Context, id int, sets int) (*PlanEntry, error)
 	ListPLans(ctx context.Context, filters Filters) ([]*Plan, *Metadata, error)
@@ -17,23 +21,45 @@ type Client interface {
 	DeletePlan(ctx context.Context, id int) error
 }
 
+// AuditRecorder records security relevant changes in the audit log kept by
+// the auth module.
+type AuditRecorder interface {
+	RecordAudit(ctx context.Context, action string, targetType string, targetID string, before any, after any)
+}
+
 type Service struct {
-	repo *Repository
+	repo    *Repository
+	audit   AuditRecorder
+	muscles muscleCache
 }
 
-func NewService(repo *Repository) *Service {
-	return &Service{repo: repo}
+func NewService(repo *Repository, audit AuditRecorder) *Service {
+	return &Service{repo: repo, audit: audit}
 }
 
 func (s *Service) ReadMuscles(ctx context.Context) ([]*Muscle, error) {
-	return s.repo.SelectMuscles(ctx)
+	ctx, span := tracer.Start(ctx, "workout.Service.ReadMuscles")
+	defer span.End()
+	return s.muscles.get(ctx, s.repo.SelectMuscles)
+}
+
+// InvalidateMuscles drops the cached muscles, e.g. when notified that
+// another instance changed them.
+func (s *Service) InvalidateMuscles() {
+	s.muscles.invalidate()
 }
 
 func (s *Service) CreateMuscle(ctx context.Context, input *MuscleInput) (*Muscle, error) {
+	ctx, span := tracer.Start(ctx, "workout.Service.CreateMuscle")
+	defer span.End()
 	muscle, err := s.repo.InsertMuscle(ctx, input)
 	if err != nil {
 		return nil, err
 	}
+	// Other instances are notified by a trigger, but this one should see
+	// the change right away.
+	s.muscles.invalidate()
+	s.audit.RecordAudit(ctx, "workout.muscle.create", "muscle", strconv.Itoa(muscle.ID), nil, muscle)
 	return muscle, nil
 }
 
@@ -42,6 +68,8 @@ func (s *Service) CreatePlanWithEntries(
 	notes string,
 	musclesIds []int,
 ) (*Plan, error) {
+	ctx, span := tracer.Start(ctx, "workout.Service.CreatePlanWithEntries")
+	defer span.End()
 	planInput := PlanInput{
 		Notes: notes,
 		// Properly set user ID.
@@ -49,30 +77,42 @@ func (s *Service) CreatePlanWithEntries(
 		// TODO: Let user choose date
 		Date: time.Now(),
 	}
-	// TODO: Setup transactions
-	plan, err := s.repo.InsertPlan(ctx, planInput)
-	if err != nil {
-		return nil, err
-	}
+	var plan *Plan
+	err := s.repo.WithTx(ctx, func(tx *Repository) error {
+		var err error
+		plan, err = tx.InsertPlan(ctx, planInput)
+		if err != nil {
+			return err
+		}
 
-	var entries []*PlanEntry
-	for _, muscleID := range musclesIds {
-		entryInput := PlanEntry{
-			MuscleID: muscleID,
-			Sets:     1,
-			PlanID:   plan.ID,
+		entries := make([]PlanEntry, len(musclesIds))
+		for i, muscleID := range musclesIds {
+			entries[i] = PlanEntry{
+				MuscleID: muscleID,
+				Sets:     1,
+				PlanID:   plan.ID,
+			}
 		}
-		entry, err := s.repo.InsertPlanEntry(ctx, entryInput)
-		if err != nil {
-			return nil, err
+		if err := tx.InsertPlanEntries(ctx, entries); err != nil {
+			return err
 		}
-		entry.Muscle, err = s.repo.SelectMuscle(ctx, muscleID)
+		plan.Entries, err = tx.SelectPlanEntriesWithMuscles(ctx, []int{plan.ID})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		entries = append(entries, entry)
+
+		return tx.AppendEvent(ctx, events.PlanCreated{
+			PlanID:    plan.ID,
+			UserID:    plan.UserID,
+			MuscleIDs: musclesIds,
+		})
+	})
+	if err != nil {
+		return nil, err
 	}
-	plan.Entries = entries
+
+	plansCreated.Inc()
+	entriesLogged.Add(float64(len(plan.Entries)))
 	return plan, nil
 }
 
@@ -81,11 +121,26 @@ func (s *Service) UpdatePlanEntrySets(
 	id int,
 	sets int,
 ) (*PlanEntry, error) {
+	ctx, span := tracer.Start(ctx, "workout.Service.UpdatePlanEntrySets")
+	defer span.End()
 	planEntryPatch := PlanEntryPatch{
 		ID:   id,
 		Sets: sets,
 	}
-	entry, err := s.repo.PatchPlanEntry(ctx, planEntryPatch)
+	var entry *PlanEntry
+	err := s.repo.WithTx(ctx, func(tx *Repository) error {
+		var err error
+		entry, err = tx.PatchPlanEntry(ctx, planEntryPatch)
+		if err != nil {
+			return err
+		}
+		return tx.AppendEvent(ctx, events.EntryUpdated{
+			EntryID:  entry.ID,
+			PlanID:   entry.PlanID,
+			MuscleID: entry.MuscleID,
+			Sets:     entry.Sets,
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -95,82 +150,77 @@ func (s *Service) UpdatePlanEntrySets(
 func (s *Service) ListPLans(
 	ctx context.Context, filters Filters,
 ) ([]*Plan, *Metadata, error) {
+	ctx, span := tracer.Start(ctx, "workout.Service.ListPLans")
+	defer span.End()
 	logger := logging.LoggerFromContext(ctx)
 
 	logger = logger.With(slog.Group("ListPlans", slog.Any("filters", filters)))
 
-	plans, metadata, err := s.repo.SelectPlans(ctx, filters)
+	plans, metadata, err := s.repo.SelectPlansWithEntries(ctx, filters)
 	if err != nil {
 		logger.Error("failed to list plans", slog.Any("error", err))
 		return nil, nil, err
 	}
-	for _, plan := range plans {
-		entries, err := s.repo.SelectPlanEntries(ctx, Filters{PlanID: &plan.ID})
-		if err != nil {
-			logger.Error(
-				"failed to list plan entries for plan",
-				slog.Int("plan_id", plan.ID),
-				slog.Any("error", err))
-			return nil, metadata, err
-		}
-		for _, entry := range entries {
-			muscle, err := s.repo.SelectMuscle(ctx, entry.MuscleID)
-			if err != nil {
-				return nil, metadata, err
-			}
-			entry.Muscle = muscle
-		}
-		plan.Entries = entries
-	}
 	return plans, metadata, nil
 }
 
 func (s *Service) ReadPlan(ctx context.Context, id int) (*Plan, error) {
+	ctx, span := tracer.Start(ctx, "workout.Service.ReadPlan")
+	defer span.End()
 	logger := logging.LoggerFromContext(ctx)
 	logger = logger.With(slog.Group("ReadPlan", slog.Int("plan_id", id)))
 
-	plans, _, err := s.repo.SelectPlans(ctx, Filters{PlanID: &id})
+	plans, _, err := s.repo.SelectPlansWithEntries(ctx, Filters{PlanID: &id})
 	if err != nil {
 		logger.Error("failed to read plan", slog.Any("error", err))
 		return nil, err
 	}
-	plan := plans[0]
-	entries, err := s.repo.SelectPlanEntries(ctx, Filters{PlanID: &plan.ID})
-	if err != nil {
-		logger.Error(
-			"failed to list plan entries for plan",
-			slog.Any("error", err),
-		)
-		return nil, err
-	}
-	for _, entry := range entries {
-		muscle, err := s.repo.SelectMuscle(ctx, entry.MuscleID)
-		if err != nil {
-			return nil, err
-		}
-		entry.Muscle = muscle
+	if len(plans) == 0 {
+		return nil, sql.ErrNoRows
 	}
-	plan.Entries = entries
-	return plan, nil
+	return plans[0], nil
 }
 
 func (s *Service) DeletePlan(ctx context.Context, id int) error {
+	ctx, span := tracer.Start(ctx, "workout.Service.DeletePlan")
+	defer span.End()
 	logger := logging.LoggerFromContext(ctx)
 	logger = logger.With(slog.Group("DeletePlan", slog.Int("plan_id", id)))
 
-	// TODO: Introduce transactions
-	nDeleted, err := s.repo.DeleteManyPlanEntries(ctx, Filters{PlanID: &id})
+	err := s.repo.WithTx(ctx, func(tx *Repository) error {
+		nDeleted, err := tx.DeleteManyPlanEntries(ctx, Filters{PlanID: &id})
+		if err != nil {
+			logger.Error("failed to delete plan entries", slog.Any("error", err))
+			return err
+		}
+		logger.Info("deleted plan entries", slog.Int64("n_deleted", nDeleted))
+
+		plan, err := tx.DeletePlan(ctx, id)
+		if err != nil {
+			logger.Error("failed to delete plan", slog.Any("error", err))
+			return err
+		}
+		return tx.AppendEvent(ctx, events.PlanDeleted{PlanID: plan.ID, UserID: plan.UserID})
+	})
 	if err != nil {
-		logger.Error("failed to delete plan entries", slog.Any("error", err))
 		return err
 	}
-	logger.Info("deleted plan entries", slog.Int64("n_deleted", nDeleted))
+	logger.Info("deleted plan")
+	return nil
+}
 
-	_, err = s.repo.DeletePlan(ctx, id)
-	if err != nil {
-		logger.Error("failed to delete plan", slog.Any("error", err))
+// DeleteUserData removes all workout data owned by a user as part of the
+// user deletion transaction.
+func (s *Service) DeleteUserData(ctx context.Context, tx *sql.Tx, userID int) error {
+	ctx, span := tracer.Start(ctx, "workout.Service.DeleteUserData")
+	defer span.End()
+	logger := logging.LoggerFromContext(ctx)
+	logger = logger.With(slog.Group("DeleteUserData", slog.Int("user_id", userID)))
+
+	if err := s.repo.DeleteUserData(ctx, tx, userID); err != nil {
+		logger.Error("failed to delete user data", slog.Any("error", err))
 		return err
 	}
-	logger.Info("deleted plan")
+	logger.Info("deleted user data")
 	return nil
 }
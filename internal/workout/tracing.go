@@ -0,0 +1,7 @@
+package workout
+
+import (
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/evenlwanvik/smartsplit/internal/workout")